@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	httpHandler "github.com/Bloom0716/mini-bigtable/internal/interface/http"
 	"github.com/Bloom0716/mini-bigtable/internal/service"
@@ -16,13 +17,26 @@ func main() {
 	// Create persistent directory for data storage
 	dataDir := filepath.Join("data", "mini_lsm")
 
-	// Create LSM service
-	service, err := service.NewLSMTableService(dataDir, 3)
+	// Create LSM service; memtables are sized by bytes, not entries
+	options := service.DefaultOptions()
+	if envWorkers := os.Getenv("COMPACTION_WORKERS"); envWorkers != "" {
+		workers, err := strconv.Atoi(envWorkers)
+		if err != nil {
+			log.Fatalf("Invalid COMPACTION_WORKERS: %v", err)
+		}
+		options.CompactionWorkers = workers
+	}
+	// Opening the data directory recovers what the previous run left behind
+	service, err := service.NewLSMTableServiceWithOptions(dataDir, options)
 	if err != nil {
 		log.Fatalf("Failed to create LSM service: %v", err)
 	}
 	defer service.Close()
 
+	recovery := service.GetRecoveryStats()
+	fmt.Printf("Recovered %d SSTables and %d WAL entries from %d WAL files in %v\n",
+		recovery.LoadedSSTables, recovery.ReplayedEntries, recovery.ReplayedWALs, recovery.Duration)
+
 	// Create HTTP handler and server
 	handler := httpHandler.NewHandler(service)
 
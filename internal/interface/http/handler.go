@@ -1,10 +1,17 @@
 package http
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Bloom0716/mini-bigtable/internal/model"
 	"github.com/Bloom0716/mini-bigtable/internal/service"
 )
 
@@ -15,8 +22,9 @@ type Handler struct {
 
 // Request/Response structures
 type PutRequest struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+	TTLSeconds int64  `json:"ttl_seconds,omitempty"`
 }
 
 type GetResponse struct {
@@ -25,15 +33,95 @@ type GetResponse struct {
 	Found bool   `json:"found"`
 }
 
+type CompareAndSwapRequest struct {
+	Key           string `json:"key"`
+	ExpectedValue string `json:"expected_value"`
+	NewValue      string `json:"new_value"`
+}
+
 type DeleteRequest struct {
 	Key string `json:"key"`
 }
 
 type StatusResponse struct {
-	ActiveMemTableSize int         `json:"active_memtable_size"`
-	ImmutableCount     int         `json:"immutable_count"`
-	SSTableStats       map[int]int `json:"sstable_stats"`
-	Message            string      `json:"message"`
+	ActiveMemTableSize int                     `json:"active_memtable_size"`
+	ImmutableCount     int                     `json:"immutable_count"`
+	SSTableStats       map[int]int             `json:"sstable_stats"`
+	ManualCompaction   *ManualCompactionStatus `json:"manual_compaction,omitempty"`
+	WriteStall         WriteStallStatus        `json:"write_stall"`
+	WriteBuffer        WriteBufferStatus       `json:"write_buffer"`
+	Message            string                  `json:"message"`
+}
+
+type WriteBufferStatus struct {
+	MemoryUsage int64 `json:"memory_usage"`
+	BufferSize  int64 `json:"buffer_size"`
+}
+
+type WriteStallStatus struct {
+	Condition     string `json:"condition"`
+	Reason        string `json:"reason,omitempty"`
+	DelayedWrites uint64 `json:"delayed_writes"`
+	StoppedWrites uint64 `json:"stopped_writes"`
+	DelayMillis   int64  `json:"delay_ms"`
+	StopMillis    int64  `json:"stop_ms"`
+}
+
+type CompactRequest struct {
+	ColumnFamily string `json:"column_family,omitempty"`
+	Start        string `json:"start,omitempty"`
+	End          string `json:"end,omitempty"`
+	Wait         bool   `json:"wait,omitempty"`
+}
+
+type ManualCompactionStatus struct {
+	Start          string `json:"start,omitempty"`
+	End            string `json:"end,omitempty"`
+	Level          int    `json:"level"`
+	Done           bool   `json:"done"`
+	TasksCompleted int    `json:"tasks_completed"`
+	BytesCompacted uint64 `json:"bytes_compacted"`
+	Error          string `json:"error,omitempty"`
+}
+
+type CreateColumnFamilyRequest struct {
+	Name               string `json:"name"`
+	MaxTableSize       int    `json:"max_table_size,omitempty"`
+	WriteBufferSize    int    `json:"write_buffer_size,omitempty"`
+	CompactionStrategy string `json:"compaction_strategy,omitempty"`
+	DynamicLevelBytes  bool   `json:"dynamic_level_bytes,omitempty"`
+	FilePicking        string `json:"file_picking,omitempty"`
+}
+
+type ColumnFamilyListResponse struct {
+	ColumnFamilies []string `json:"column_families"`
+}
+
+type ColumnFamilyStatusResponse struct {
+	Name                string      `json:"name"`
+	ActiveMemTableSize  int         `json:"active_memtable_size"`
+	ActiveMemTableBytes int         `json:"active_memtable_bytes"`
+	ImmutableCount      int         `json:"immutable_count"`
+	SSTableStats        map[int]int `json:"sstable_stats"`
+}
+
+type RateLimitRequest struct {
+	BytesPerSecond int64 `json:"bytes_per_second"`
+}
+
+type RateLimitResponse struct {
+	BytesPerSecond int64 `json:"bytes_per_second"`
+}
+
+type RecoveryResponse struct {
+	LoadedSSTables  int    `json:"loaded_sstables"`
+	OrphanedFiles   int    `json:"orphaned_files"`
+	ObsoleteWALs    int    `json:"obsolete_wals"`
+	ReplayedWALs    int    `json:"replayed_wals"`
+	ReplayedEntries int    `json:"replayed_entries"`
+	FlushedSSTables int    `json:"flushed_sstables"`
+	LastSequence    uint64 `json:"last_sequence"`
+	DurationMillis  int64  `json:"duration_ms"`
 }
 
 type ErrorResponse struct {
@@ -65,7 +153,39 @@ func (h *Handler) HandlePut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.Put([]byte(req.Key), []byte(req.Value)); err != nil {
+	if req.TTLSeconds < 0 {
+		h.writeErrorResponse(w, http.StatusBadRequest, "TTL cannot be negative")
+		return
+	}
+
+	writeOptions, err := parseWriteOptions(r)
+	if err != nil {
+		h.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+
+	switch {
+	case r.Header.Get("If-Match") != "":
+		// Conditional write against a version token returned as ETag by GET
+		version, parseErr := parseETag(r.Header.Get("If-Match"))
+		if parseErr != nil {
+			h.writeErrorResponse(w, http.StatusBadRequest, "Invalid If-Match header")
+			return
+		}
+		err = h.service.PutIfVersionWithOptions([]byte(req.Key), []byte(req.Value), version, ttl, writeOptions)
+	case r.Header.Get("If-None-Match") == "*":
+		err = h.service.PutIfAbsentWithOptions([]byte(req.Key), []byte(req.Value), ttl, writeOptions)
+	case ttl > 0:
+		err = h.service.PutWithTTLWithOptions([]byte(req.Key), []byte(req.Value), ttl, writeOptions)
+	default:
+		err = h.service.PutWithOptions([]byte(req.Key), []byte(req.Value), writeOptions)
+	}
+	if errors.Is(err, service.ErrConflict) {
+		h.writeErrorResponse(w, http.StatusConflict, fmt.Sprintf("Key '%s' was modified concurrently", req.Key))
+		return
+	}
+	if err != nil {
 		h.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Failed to put: %v", err))
 		return
 	}
@@ -89,7 +209,7 @@ func (h *Handler) HandleGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	value, err := h.service.Get([]byte(key))
+	value, version, err := h.service.GetWithVersion([]byte(key))
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusNotFound)
@@ -102,6 +222,7 @@ func (h *Handler) HandleGet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(version))
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(GetResponse{
 		Key:   key,
@@ -110,6 +231,86 @@ func (h *Handler) HandleGet(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// POST /api/cas - Replace a value only if it currently equals the expected value
+func (h *Handler) HandleCompareAndSwap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CompareAndSwapRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, http.StatusBadRequest, "Invalid JSON format")
+		return
+	}
+
+	if req.Key == "" {
+		h.writeErrorResponse(w, http.StatusBadRequest, "Key cannot be empty")
+		return
+	}
+
+	err := h.service.CompareAndSwap([]byte(req.Key), []byte(req.ExpectedValue), []byte(req.NewValue))
+	if errors.Is(err, service.ErrConflict) {
+		h.writeErrorResponse(w, http.StatusConflict, fmt.Sprintf("Key '%s' does not hold the expected value", req.Key))
+		return
+	}
+	if err != nil {
+		h.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Failed to compare and swap: %v", err))
+		return
+	}
+
+	h.writeSuccessResponse(w, map[string]string{
+		"status":  "success",
+		"message": fmt.Sprintf("Key '%s' swapped successfully", req.Key),
+	})
+}
+
+// POST /api/put_if_absent - Store a key-value pair only if the key does not exist
+func (h *Handler) HandlePutIfAbsent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, http.StatusBadRequest, "Invalid JSON format")
+		return
+	}
+
+	if req.Key == "" {
+		h.writeErrorResponse(w, http.StatusBadRequest, "Key cannot be empty")
+		return
+	}
+
+	if req.TTLSeconds < 0 {
+		h.writeErrorResponse(w, http.StatusBadRequest, "TTL cannot be negative")
+		return
+	}
+
+	writeOptions, err := parseWriteOptions(r)
+	if err != nil {
+		h.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	err = h.service.PutIfAbsentWithOptions([]byte(req.Key), []byte(req.Value), ttl, writeOptions)
+	if errors.Is(err, service.ErrConflict) {
+		h.writeErrorResponse(w, http.StatusConflict, fmt.Sprintf("Key '%s' already exists", req.Key))
+		return
+	}
+	if err != nil {
+		h.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Failed to put: %v", err))
+		return
+	}
+
+	h.writeSuccessResponse(w, map[string]string{
+		"status":  "success",
+		"message": fmt.Sprintf("Key '%s' stored successfully", req.Key),
+	})
+}
+
 // DELETE /api/delete - Delete a key
 func (h *Handler) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete && r.Method != http.MethodPost {
@@ -128,7 +329,13 @@ func (h *Handler) HandleDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.Delete([]byte(req.Key)); err != nil {
+	writeOptions, err := parseWriteOptions(r)
+	if err != nil {
+		h.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.service.DeleteWithOptions([]byte(req.Key), writeOptions); err != nil {
 		h.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Failed to delete: %v", err))
 		return
 	}
@@ -148,32 +355,403 @@ func (h *Handler) HandleStatus(w http.ResponseWriter, r *http.Request) {
 
 	activeSize, immutableCount := h.service.GetMemTableStats()
 	sstableStats := h.service.GetSSTableStats()
+	stall := h.service.GetWriteStallStats()
+	memoryUsage, bufferSize := h.service.GetWriteBufferStats()
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(StatusResponse{
+	response := StatusResponse{
 		ActiveMemTableSize: activeSize,
 		ImmutableCount:     immutableCount,
 		SSTableStats:       sstableStats,
-		Message:            "LSM-Tree service is running",
+		WriteStall: WriteStallStatus{
+			Condition:     stall.Condition.String(),
+			Reason:        stall.Reason,
+			DelayedWrites: stall.DelayedWrites,
+			StoppedWrites: stall.StoppedWrites,
+			DelayMillis:   stall.DelayTime.Milliseconds(),
+			StopMillis:    stall.StopTime.Milliseconds(),
+		},
+		WriteBuffer: WriteBufferStatus{
+			MemoryUsage: memoryUsage,
+			BufferSize:  bufferSize,
+		},
+		Message: "LSM-Tree service is running",
+	}
+	if progress, ok := h.service.ManualCompactionProgress(); ok {
+		response.ManualCompaction = newManualCompactionStatus(progress)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// POST /api/compact - Compact a key range down to the bottom level
+func (h *Handler) HandleCompact(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// An empty body compacts the whole default column family in the background
+	var req CompactRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		h.writeErrorResponse(w, http.StatusBadRequest, "Invalid JSON format")
+		return
+	}
+
+	var start, end []byte
+	if req.Start != "" {
+		start = []byte(req.Start)
+	}
+	if req.End != "" {
+		end = []byte(req.End)
+	}
+	if start != nil && end != nil && bytes.Compare(start, end) > 0 {
+		h.writeErrorResponse(w, http.StatusBadRequest, "start must not be greater than end")
+		return
+	}
+
+	if !req.Wait {
+		err := h.service.StartCompactRangeCF(req.ColumnFamily, start, end)
+		if errors.Is(err, service.ErrColumnFamilyNotFound) {
+			h.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("Column family '%s' not found", req.ColumnFamily))
+			return
+		}
+		if err != nil {
+			h.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Failed to start compaction: %v", err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "accepted",
+			"message": "Compaction started; see /api/status for progress",
+		})
+		return
+	}
+
+	// The response is sent once the whole compaction finished, which may take longer than the server's
+	// WriteTimeout; the deadline is lifted for this response (writers without deadlines need nothing)
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	err := h.service.CompactRangeCF(req.ColumnFamily, start, end)
+	if errors.Is(err, service.ErrColumnFamilyNotFound) {
+		h.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("Column family '%s' not found", req.ColumnFamily))
+		return
+	}
+	if err != nil {
+		h.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Compaction failed: %v", err))
+		return
+	}
+
+	h.writeSuccessResponse(w, map[string]string{
+		"status":  "success",
+		"message": "Compaction completed successfully",
 	})
 }
 
-// POST /api/recovery - Trigger recovery
-func (h *Handler) HandleRecovery(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+// GET /api/admin/rate_limit - Get the SSTable write rate limit
+// PUT /api/admin/rate_limit - Change the SSTable write rate limit
+func (h *Handler) HandleRateLimit(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.writeSuccessResponse(w, RateLimitResponse{
+			BytesPerSecond: h.service.WriteRateLimit(),
+		})
+	case http.MethodPut, http.MethodPost:
+		var req RateLimitRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeErrorResponse(w, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+
+		if err := h.service.SetWriteRateLimit(req.BytesPerSecond); err != nil {
+			h.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		h.writeSuccessResponse(w, RateLimitResponse{
+			BytesPerSecond: h.service.WriteRateLimit(),
+		})
+	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// newManualCompactionStatus converts manual compaction progress into its JSON form
+func newManualCompactionStatus(progress model.ManualCompactionProgress) *ManualCompactionStatus {
+	status := &ManualCompactionStatus{
+		Start:          string(progress.Start),
+		End:            string(progress.End),
+		Level:          progress.Level,
+		Done:           progress.Done,
+		TasksCompleted: progress.TasksCompleted,
+		BytesCompacted: progress.BytesCompacted,
+	}
+	if progress.Err != nil {
+		status.Error = progress.Err.Error()
+	}
+	return status
+}
+
+// GET /api/cf - List column families
+// POST /api/cf - Create a column family
+func (h *Handler) HandleColumnFamilies(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.writeSuccessResponse(w, ColumnFamilyListResponse{
+			ColumnFamilies: h.service.ListColumnFamilies(),
+		})
+	case http.MethodPost:
+		var req CreateColumnFamilyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeErrorResponse(w, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+
+		if req.Name == "" {
+			h.writeErrorResponse(w, http.StatusBadRequest, "Column family name cannot be empty")
+			return
+		}
+
+		if req.MaxTableSize < 0 {
+			h.writeErrorResponse(w, http.StatusBadRequest, "max_table_size cannot be negative")
+			return
+		}
+
+		if req.WriteBufferSize < 0 {
+			h.writeErrorResponse(w, http.StatusBadRequest, "write_buffer_size cannot be negative")
+			return
+		}
+
+		options := service.ColumnFamilyOptions{
+			MaxTableSize:       req.MaxTableSize,
+			WriteBufferSize:    req.WriteBufferSize,
+			CompactionStrategy: model.LeveledCompaction,
+			DynamicLevelBytes:  req.DynamicLevelBytes,
+		}
+		if req.CompactionStrategy != "" {
+			strategy, err := model.ParseCompactionStrategy(req.CompactionStrategy)
+			if err != nil {
+				h.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			options.CompactionStrategy = strategy
+		}
+		if req.FilePicking != "" {
+			policy, err := model.ParseFilePickingPolicy(req.FilePicking)
+			if err != nil {
+				h.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			options.FilePicking = policy
+		}
+
+		err := h.service.CreateColumnFamily(req.Name, options)
+		if errors.Is(err, service.ErrColumnFamilyExists) {
+			h.writeErrorResponse(w, http.StatusConflict, fmt.Sprintf("Column family '%s' already exists", req.Name))
+			return
+		}
+		if err != nil {
+			h.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Failed to create column family: %v", err))
+			return
+		}
+
+		h.writeSuccessResponse(w, map[string]string{
+			"status":  "success",
+			"message": fmt.Sprintf("Column family '%s' created successfully", req.Name),
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// /api/cf/{name}/... - Operations scoped to a single column family
+//
+//	PUT    /api/cf/{name}/put
+//	GET    /api/cf/{name}/get/{key}
+//	DELETE /api/cf/{name}/delete
+//	GET    /api/cf/{name}/status
+func (h *Handler) HandleColumnFamily(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(r.URL.Path[len("/api/cf/"):], "/", 3)
+	if len(parts) < 2 || parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	family, operation := parts[0], parts[1]
+
+	switch {
+	case operation == "put" && len(parts) == 2:
+		h.handlePutCF(w, r, family)
+	case operation == "get" && len(parts) == 3:
+		h.handleGetCF(w, r, family, parts[2])
+	case operation == "delete" && len(parts) == 2:
+		h.handleDeleteCF(w, r, family)
+	case operation == "status" && len(parts) == 2:
+		h.handleStatusCF(w, r, family)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) handlePutCF(w http.ResponseWriter, r *http.Request, family string) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, http.StatusBadRequest, "Invalid JSON format")
+		return
+	}
+
+	if req.Key == "" {
+		h.writeErrorResponse(w, http.StatusBadRequest, "Key cannot be empty")
+		return
+	}
+
+	if req.TTLSeconds < 0 {
+		h.writeErrorResponse(w, http.StatusBadRequest, "TTL cannot be negative")
+		return
+	}
+
+	writeOptions, err := parseWriteOptions(r)
+	if err != nil {
+		h.writeErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	ttl := time.Duration(req.TTLSeconds) * time.Second
 
-	if err := h.service.Recovery(); err != nil {
-		h.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Recovery failed: %v", err))
+	err = h.service.PutCFWithOptions(family, []byte(req.Key), []byte(req.Value), ttl, writeOptions)
+	if errors.Is(err, service.ErrColumnFamilyNotFound) {
+		h.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("Column family '%s' not found", family))
+		return
+	}
+	if err != nil {
+		h.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Failed to put: %v", err))
 		return
 	}
 
 	h.writeSuccessResponse(w, map[string]string{
 		"status":  "success",
-		"message": "Recovery completed successfully",
+		"message": fmt.Sprintf("Key '%s' stored successfully in '%s'", req.Key, family),
+	})
+}
+
+func (h *Handler) handleGetCF(w http.ResponseWriter, r *http.Request, family, key string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if key == "" {
+		h.writeErrorResponse(w, http.StatusBadRequest, "Key cannot be empty")
+		return
+	}
+
+	value, err := h.service.GetCF(family, []byte(key))
+	if errors.Is(err, service.ErrColumnFamilyNotFound) {
+		h.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("Column family '%s' not found", family))
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(GetResponse{
+			Key:   key,
+			Value: "",
+			Found: false,
+		})
+		return
+	}
+
+	h.writeSuccessResponse(w, GetResponse{
+		Key:   key,
+		Value: string(value),
+		Found: true,
+	})
+}
+
+func (h *Handler) handleDeleteCF(w http.ResponseWriter, r *http.Request, family string) {
+	if r.Method != http.MethodDelete && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req DeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, http.StatusBadRequest, "Invalid JSON format")
+		return
+	}
+
+	if req.Key == "" {
+		h.writeErrorResponse(w, http.StatusBadRequest, "Key cannot be empty")
+		return
+	}
+
+	writeOptions, err := parseWriteOptions(r)
+	if err != nil {
+		h.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = h.service.DeleteCFWithOptions(family, []byte(req.Key), writeOptions)
+	if errors.Is(err, service.ErrColumnFamilyNotFound) {
+		h.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("Column family '%s' not found", family))
+		return
+	}
+	if err != nil {
+		h.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Failed to delete: %v", err))
+		return
+	}
+
+	h.writeSuccessResponse(w, map[string]string{
+		"status":  "success",
+		"message": fmt.Sprintf("Key '%s' deleted successfully from '%s'", req.Key, family),
+	})
+}
+
+func (h *Handler) handleStatusCF(w http.ResponseWriter, r *http.Request, family string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats, err := h.service.GetColumnFamilyStats(family)
+	if err != nil {
+		h.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("Column family '%s' not found", family))
+		return
+	}
+
+	h.writeSuccessResponse(w, ColumnFamilyStatusResponse{
+		Name:                stats.Name,
+		ActiveMemTableSize:  stats.ActiveMemTableSize,
+		ActiveMemTableBytes: stats.ActiveMemTableBytes,
+		ImmutableCount:      stats.ImmutableCount,
+		SSTableStats:        stats.SSTableStats,
+	})
+}
+
+// GET /api/recovery - Report what was recovered when the service was opened
+// Recovery runs automatically on startup, so this endpoint only reads its results
+func (h *Handler) HandleRecovery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats := h.service.GetRecoveryStats()
+	h.writeSuccessResponse(w, RecoveryResponse{
+		LoadedSSTables:  stats.LoadedSSTables,
+		OrphanedFiles:   stats.OrphanedFiles,
+		ObsoleteWALs:    stats.ObsoleteWALs,
+		ReplayedWALs:    stats.ReplayedWALs,
+		ReplayedEntries: stats.ReplayedEntries,
+		FlushedSSTables: stats.FlushedSSTables,
+		LastSequence:    stats.LastSequence,
+		DurationMillis:  stats.Duration.Milliseconds(),
 	})
 }
 
@@ -200,21 +778,61 @@ func (h *Handler) HandleAPIDoc(w http.ResponseWriter, r *http.Request) {
 		"version": "1.0.0",
 		"endpoints": map[string]interface{}{
 			"PUT /api/put": map[string]string{
-				"description": "Store a key-value pair",
-				"body":        `{"key": "string", "value": "string"}`,
+				"description": "Store a key-value pair (optionally expiring after ttl_seconds; honours If-Match and If-None-Match: *; ?durability=sync|async|none or X-Durability selects durability)",
+				"body":        `{"key": "string", "value": "string", "ttl_seconds": 0}`,
 			},
 			"GET /api/get/{key}": map[string]string{
-				"description": "Retrieve a value by key",
+				"description": "Retrieve a value by key (the ETag header carries its version token)",
+			},
+			"POST /api/cas": map[string]string{
+				"description": "Replace a value only if it equals expected_value (409 on conflict)",
+				"body":        `{"key": "string", "expected_value": "string", "new_value": "string"}`,
+			},
+			"POST /api/put_if_absent": map[string]string{
+				"description": "Store a key-value pair only if the key does not exist (409 on conflict; optionally expiring after ttl_seconds; ?durability=sync|async|none or X-Durability selects durability)",
+				"body":        `{"key": "string", "value": "string", "ttl_seconds": 0}`,
 			},
 			"DELETE /api/delete": map[string]string{
-				"description": "Delete a key",
+				"description": "Delete a key (?durability=sync|async|none or X-Durability selects durability)",
 				"body":        `{"key": "string"}`,
 			},
+			"GET /api/cf": map[string]string{
+				"description": "List column families",
+			},
+			"POST /api/cf": map[string]string{
+				"description": "Create a column family (compaction_strategy: leveled, size_tiered, universal or time_window)",
+				"body":        `{"name": "string", "max_table_size": 0, "write_buffer_size": 0, "compaction_strategy": "leveled", "dynamic_level_bytes": false, "file_picking": "round_robin"}`,
+			},
+			"PUT /api/cf/{name}/put": map[string]string{
+				"description": "Store a key-value pair in a column family (optionally expiring after ttl_seconds; ?durability=sync|async|none or X-Durability selects durability)",
+				"body":        `{"key": "string", "value": "string", "ttl_seconds": 0}`,
+			},
+			"GET /api/cf/{name}/get/{key}": map[string]string{
+				"description": "Retrieve a value by key from a column family",
+			},
+			"DELETE /api/cf/{name}/delete": map[string]string{
+				"description": "Delete a key from a column family (?durability=sync|async|none or X-Durability selects durability)",
+				"body":        `{"key": "string"}`,
+			},
+			"GET /api/cf/{name}/status": map[string]string{
+				"description": "Get statistics of a column family",
+			},
 			"GET /api/status": map[string]string{
 				"description": "Get system status and statistics",
 			},
-			"POST /api/recovery": map[string]string{
-				"description": "Trigger recovery process",
+			"POST /api/compact": map[string]string{
+				"description": "Compact a key range down to the bottom level; without wait it runs in the background and reports progress in /api/status, with wait the response is sent when it finishes (no write timeout applies)",
+				"body":        `{"column_family": "string (optional)", "start": "string (optional)", "end": "string (optional)", "wait": false}`,
+			},
+			"GET /api/admin/rate_limit": map[string]string{
+				"description": "Get the bytes per second flushes and compactions may write (0 = unlimited)",
+			},
+			"PUT /api/admin/rate_limit": map[string]string{
+				"description": "Change the write rate limit at runtime; flushes take priority over compactions",
+				"body":        `{"bytes_per_second": 0}`,
+			},
+			"GET /api/recovery": map[string]string{
+				"description": "Report what was recovered from the data directory on startup",
 			},
 			"GET /health": map[string]string{
 				"description": "Health check endpoint",
@@ -230,6 +848,37 @@ func (h *Handler) HandleAPIDoc(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(apiDoc)
 }
 
+// formatETag formats a version token as a quoted ETag
+func formatETag(version uint64) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// parseWriteOptions reads the durability of a write from the durability query parameter or the
+// X-Durability header: sync (the default) waits for the WAL to reach the disk, async returns once
+// the write reached the operating system and none skips the WAL
+func parseWriteOptions(r *http.Request) (service.WriteOptions, error) {
+	level := r.URL.Query().Get("durability")
+	if level == "" {
+		level = r.Header.Get("X-Durability")
+	}
+
+	switch strings.ToLower(level) {
+	case "", "sync":
+		return service.DefaultWriteOptions(), nil
+	case "async":
+		return service.WriteOptions{}, nil
+	case "none":
+		return service.WriteOptions{DisableWAL: true}, nil
+	default:
+		return service.WriteOptions{}, fmt.Errorf("invalid durability '%s': use sync, async or none", level)
+	}
+}
+
+// parseETag parses an ETag produced by formatETag
+func parseETag(etag string) (uint64, error) {
+	return strconv.ParseUint(strings.Trim(etag, `"`), 10, 64)
+}
+
 // Helper methods for response handling
 func (h *Handler) writeErrorResponse(w http.ResponseWriter, statusCode int, message string) {
 	w.Header().Set("Content-Type", "application/json")
@@ -7,8 +7,11 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Bloom0716/mini-bigtable/internal/model"
 	"github.com/Bloom0716/mini-bigtable/internal/service"
 )
 
@@ -153,6 +156,64 @@ func TestHandler_HandleGet(t *testing.T) {
 	}
 }
 
+func TestHandler_HandlePutDurability(t *testing.T) {
+	handler, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	tests := []struct {
+		name           string
+		query          string
+		header         string
+		requestBody    PutRequest
+		expectedStatus int
+	}{
+		{"Synced by default", "", "", PutRequest{Key: "sync", Value: "1"}, http.StatusOK},
+		{"Async query parameter", "?durability=async", "", PutRequest{Key: "async", Value: "2"}, http.StatusOK},
+		{"No WAL header", "", "none", PutRequest{Key: "none", Value: "3"}, http.StatusOK},
+		{"Async TTL write", "?durability=async", "", PutRequest{Key: "ttl", Value: "4", TTLSeconds: 60}, http.StatusOK},
+		{"Invalid durability", "?durability=eventually", "", PutRequest{Key: "invalid", Value: "5"}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.requestBody)
+			if err != nil {
+				t.Fatalf("Failed to marshal request body: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPut, "/api/put"+tt.query, bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.header != "" {
+				req.Header.Set("X-Durability", tt.header)
+			}
+			rr := httptest.NewRecorder()
+
+			handler.HandlePut(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if rr.Code == http.StatusOK {
+				value, err := handler.service.Get([]byte(tt.requestBody.Key))
+				if err != nil || string(value) != tt.requestBody.Value {
+					t.Errorf("Expected %s, got %s (err %v)", tt.requestBody.Value, value, err)
+				}
+			}
+		})
+	}
+
+	// Deletes accept the same levels
+	req := httptest.NewRequest(http.MethodDelete, "/api/delete?durability=async", bytes.NewBufferString(`{"key": "async"}`))
+	rr := httptest.NewRecorder()
+	handler.HandleDelete(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if _, err := handler.service.Get([]byte("async")); err != model.ErrKeyNotFound {
+		t.Errorf("Expected key to be deleted, got %v", err)
+	}
+}
+
 func TestHandler_HandleDelete(t *testing.T) {
 	handler, cleanup := setupTestHandler(t)
 	defer cleanup()
@@ -235,6 +296,42 @@ func TestHandler_HandleStatus(t *testing.T) {
 	if response.Message == "" {
 		t.Error("Expected message to be non-empty")
 	}
+	if response.WriteStall.Condition != "normal" {
+		t.Errorf("Expected normal write stall condition, got %s", response.WriteStall.Condition)
+	}
+
+	if response.WriteBuffer.BufferSize != service.DefaultOptions().TotalWriteBufferSize {
+		t.Errorf("Expected write buffer size %d, got %d", service.DefaultOptions().TotalWriteBufferSize, response.WriteBuffer.BufferSize)
+	}
+}
+
+func TestHandler_HandleRecovery(t *testing.T) {
+	handler, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/recovery", nil)
+	rr := httptest.NewRecorder()
+	handler.HandleRecovery(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	var response RecoveryResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	// A fresh data directory has nothing to recover
+	if response.ReplayedWALs != 0 || response.LoadedSSTables != 0 {
+		t.Errorf("Expected nothing recovered, got %+v", response)
+	}
+
+	// Recovery runs on startup and cannot be triggered again
+	req = httptest.NewRequest(http.MethodPost, "/api/recovery", nil)
+	rr = httptest.NewRecorder()
+	handler.HandleRecovery(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
 }
 
 func TestHandler_HandleHealth(t *testing.T) {
@@ -269,3 +366,440 @@ func TestHandler_HandleHealth(t *testing.T) {
 		t.Errorf("Expected service=mini-lsm-table, got service=%s", response["service"])
 	}
 }
+
+func TestHandler_HandlePutWithTTL(t *testing.T) {
+	handler, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	tests := []struct {
+		name           string
+		requestBody    PutRequest
+		expectedStatus int
+	}{
+		{
+			name:           "Valid TTL",
+			requestBody:    PutRequest{Key: "session:1", Value: "data", TTLSeconds: 60},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Negative TTL",
+			requestBody:    PutRequest{Key: "session:2", Value: "data", TTLSeconds: -1},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.requestBody)
+			if err != nil {
+				t.Fatalf("Failed to marshal request body: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPut, "/api/put", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			handler.HandlePut(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_HandleConditionalWrites(t *testing.T) {
+	handler, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	putIfAbsent := func(key, value string) int {
+		body, _ := json.Marshal(PutRequest{Key: key, Value: value})
+		req := httptest.NewRequest(http.MethodPost, "/api/put_if_absent", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+		handler.HandlePutIfAbsent(rr, req)
+		return rr.Code
+	}
+
+	if code := putIfAbsent("cond:key", "v1"); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := putIfAbsent("cond:key", "v2"); code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, code)
+	}
+
+	cas := func(expected, newValue string) int {
+		body, _ := json.Marshal(CompareAndSwapRequest{Key: "cond:key", ExpectedValue: expected, NewValue: newValue})
+		req := httptest.NewRequest(http.MethodPost, "/api/cas", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+		handler.HandleCompareAndSwap(rr, req)
+		return rr.Code
+	}
+
+	if code := cas("v1", "v2"); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := cas("v1", "v3"); code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, code)
+	}
+
+	// GET returns an ETag usable with If-Match
+	req := httptest.NewRequest(http.MethodGet, "/api/get/cond:key", nil)
+	rr := httptest.NewRecorder()
+	handler.HandleGet(rr, req)
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected ETag header on GET")
+	}
+
+	putIfMatch := func(etag string) int {
+		body, _ := json.Marshal(PutRequest{Key: "cond:key", Value: "v4"})
+		req := httptest.NewRequest(http.MethodPut, "/api/put", bytes.NewBuffer(body))
+		req.Header.Set("If-Match", etag)
+		rr := httptest.NewRecorder()
+		handler.HandlePut(rr, req)
+		return rr.Code
+	}
+
+	if code := putIfMatch(etag); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := putIfMatch(etag); code != http.StatusConflict {
+		t.Errorf("Expected status %d for stale ETag, got %d", http.StatusConflict, code)
+	}
+}
+
+func TestHandler_HandleConditionalWritesWithOptions(t *testing.T) {
+	handler, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	send := func(path string, request PutRequest, header, value string) int {
+		body, _ := json.Marshal(request)
+		req := httptest.NewRequest(http.MethodPut, path, bytes.NewBuffer(body))
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rr := httptest.NewRecorder()
+		if strings.HasPrefix(path, "/api/put_if_absent") {
+			handler.HandlePutIfAbsent(rr, req)
+		} else {
+			handler.HandlePut(rr, req)
+		}
+		return rr.Code
+	}
+
+	// Durability levels are validated and applied on every conditional path
+	if code := send("/api/put_if_absent?durability=eventually", PutRequest{Key: "absent", Value: "v"}, "", ""); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, code)
+	}
+	if code := send("/api/put_if_absent", PutRequest{Key: "absent", Value: "v", TTLSeconds: -1}, "", ""); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a negative TTL, got %d", http.StatusBadRequest, code)
+	}
+	if code := send("/api/put_if_absent?durability=none", PutRequest{Key: "absent", Value: "v", TTLSeconds: 1}, "", ""); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := send("/api/put?durability=async", PutRequest{Key: "none-match", Value: "v", TTLSeconds: 1}, "If-None-Match", "*"); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	if err := handler.service.Put([]byte("match"), []byte("v1")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	_, version, err := handler.service.GetWithVersion([]byte("match"))
+	if err != nil {
+		t.Fatalf("Failed to get: %v", err)
+	}
+	if code := send("/api/put?durability=none", PutRequest{Key: "match", Value: "v2", TTLSeconds: 1}, "If-Match", formatETag(version)); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	// Every conditional write kept its TTL
+	for _, key := range []string{"absent", "none-match", "match"} {
+		if _, err := handler.service.Get([]byte(key)); err != nil {
+			t.Errorf("Expected %s before it expires, got %v", key, err)
+		}
+	}
+	time.Sleep(1100 * time.Millisecond)
+	for _, key := range []string{"absent", "none-match", "match"} {
+		if _, err := handler.service.Get([]byte(key)); err != model.ErrKeyNotFound {
+			t.Errorf("Expected %s to expire, got %v", key, err)
+		}
+	}
+}
+
+func TestHandler_HandleColumnFamilies(t *testing.T) {
+	handler, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	createFamily := func(req CreateColumnFamilyRequest) int {
+		body, _ := json.Marshal(req)
+		httpReq := httptest.NewRequest(http.MethodPost, "/api/cf", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+		handler.HandleColumnFamilies(rr, httpReq)
+		return rr.Code
+	}
+
+	if code := createFamily(CreateColumnFamilyRequest{Name: "events", CompactionStrategy: "size_tiered"}); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := createFamily(CreateColumnFamilyRequest{Name: "events"}); code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, code)
+	}
+	if code := createFamily(CreateColumnFamilyRequest{Name: "bad", CompactionStrategy: "unknown"}); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, code)
+	}
+
+	// List column families
+	req := httptest.NewRequest(http.MethodGet, "/api/cf", nil)
+	rr := httptest.NewRecorder()
+	handler.HandleColumnFamilies(rr, req)
+	var list ColumnFamilyListResponse
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(list.ColumnFamilies) != 2 || list.ColumnFamilies[1] != "events" {
+		t.Errorf("Unexpected column families: %v", list.ColumnFamilies)
+	}
+
+	// Writes are scoped to the family
+	body, _ := json.Marshal(PutRequest{Key: "event:1", Value: "login"})
+	req = httptest.NewRequest(http.MethodPut, "/api/cf/events/put", bytes.NewBuffer(body))
+	rr = httptest.NewRecorder()
+	handler.HandleColumnFamily(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/cf/events/get/event:1", nil)
+	rr = httptest.NewRecorder()
+	handler.HandleColumnFamily(rr, req)
+	var getResp GetResponse
+	if err := json.NewDecoder(rr.Body).Decode(&getResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if rr.Code != http.StatusOK || getResp.Value != "login" {
+		t.Errorf("Expected login with status %d, got %q with status %d", http.StatusOK, getResp.Value, rr.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/get/event:1", nil)
+	rr = httptest.NewRecorder()
+	handler.HandleGet(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected key to be absent from default family, got status %d", rr.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/cf/events/status", nil)
+	rr = httptest.NewRecorder()
+	handler.HandleColumnFamily(rr, req)
+	var status ColumnFamilyStatusResponse
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if status.ActiveMemTableSize != 1 {
+		t.Errorf("Expected active memtable size 1, got %d", status.ActiveMemTableSize)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/cf/missing/get/key", nil)
+	rr = httptest.NewRecorder()
+	handler.HandleColumnFamily(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for unknown family, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestHandler_HandleColumnFamilyWriteOptions(t *testing.T) {
+	handler, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	if err := handler.service.CreateColumnFamily("sessions", service.ColumnFamilyOptions{CompactionStrategy: model.LeveledCompaction}); err != nil {
+		t.Fatalf("Failed to create column family: %v", err)
+	}
+	send := func(method, path string, body any) int {
+		encoded, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(encoded))
+		rr := httptest.NewRecorder()
+		handler.HandleColumnFamily(rr, req)
+		return rr.Code
+	}
+
+	// TTL and durability are validated and applied as on the default family's routes
+	if code := send(http.MethodPut, "/api/cf/sessions/put?durability=eventually", PutRequest{Key: "session", Value: "v"}); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, code)
+	}
+	if code := send(http.MethodPut, "/api/cf/sessions/put", PutRequest{Key: "session", Value: "v", TTLSeconds: -1}); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a negative TTL, got %d", http.StatusBadRequest, code)
+	}
+	if code := send(http.MethodPut, "/api/cf/sessions/put?durability=async", PutRequest{Key: "session", Value: "v", TTLSeconds: 1}); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := send(http.MethodPut, "/api/cf/sessions/put?durability=none", PutRequest{Key: "user", Value: "v"}); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := send(http.MethodDelete, "/api/cf/sessions/delete?durability=eventually", DeleteRequest{Key: "user"}); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, code)
+	}
+	if code := send(http.MethodDelete, "/api/cf/sessions/delete?durability=async", DeleteRequest{Key: "user"}); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	if _, err := handler.service.GetCF("sessions", []byte("session")); err != nil {
+		t.Errorf("Expected session before it expires, got %v", err)
+	}
+	if _, err := handler.service.GetCF("sessions", []byte("user")); err != model.ErrKeyNotFound {
+		t.Errorf("Expected user to be deleted, got %v", err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := handler.service.GetCF("sessions", []byte("session")); err != model.ErrKeyNotFound {
+		t.Errorf("Expected session to expire, got %v", err)
+	}
+}
+
+func TestHandler_HandleCompact(t *testing.T) {
+	handler, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	for i := 0; i < 25; i++ {
+		body, _ := json.Marshal(PutRequest{Key: string(rune('a' + i)), Value: "value"})
+		rr := httptest.NewRecorder()
+		handler.HandlePut(rr, httptest.NewRequest(http.MethodPut, "/api/put", bytes.NewBuffer(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Put failed with status %d", rr.Code)
+		}
+	}
+
+	tests := []struct {
+		name           string
+		requestBody    CompactRequest
+		expectedStatus int
+	}{
+		{
+			name:           "Wait for range compaction",
+			requestBody:    CompactRequest{Start: "a", End: "m", Wait: true},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Inverted range",
+			requestBody:    CompactRequest{Start: "m", End: "a"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown column family",
+			requestBody:    CompactRequest{ColumnFamily: "missing", Wait: true},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.requestBody)
+			if err != nil {
+				t.Fatalf("Failed to marshal request: %v", err)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.HandleCompact(rr, httptest.NewRequest(http.MethodPost, "/api/compact", bytes.NewBuffer(body)))
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	// Progress of the finished compaction is reported in status
+	rr := httptest.NewRecorder()
+	handler.HandleStatus(rr, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+	var response StatusResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response.ManualCompaction == nil || !response.ManualCompaction.Done {
+		t.Fatalf("Expected finished manual compaction in status, got %+v", response.ManualCompaction)
+	}
+	if response.ManualCompaction.TasksCompleted == 0 || response.ManualCompaction.Start != "a" {
+		t.Errorf("Unexpected manual compaction status: %+v", response.ManualCompaction)
+	}
+	if response.SSTableStats[0] != 0 {
+		t.Errorf("Expected level 0 to be compacted, got %d tables", response.SSTableStats[0])
+	}
+
+	// Without wait the compaction is accepted and runs in the background
+	rr = httptest.NewRecorder()
+	handler.HandleCompact(rr, httptest.NewRequest(http.MethodPost, "/api/compact", nil))
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("Expected status %d, got %d", http.StatusAccepted, rr.Code)
+	}
+}
+
+func TestServer_CompactWaitOutlastsWriteTimeout(t *testing.T) {
+	handler, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	for i := 0; i < 25; i++ {
+		if err := handler.service.Put([]byte(string(rune('a'+i))), []byte("value")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	// Throttled writes make the compaction take longer than the server may spend on a response
+	if err := handler.service.SetWriteRateLimit(4096); err != nil {
+		t.Fatalf("Failed to set rate limit: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(NewServer(handler, "0").server.Handler)
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	start := time.Now()
+	resp, err := http.Post(server.URL+"/api/compact", "application/json", bytes.NewBufferString(`{"wait": true}`))
+	if err != nil {
+		t.Fatalf("Expected a response to the waited compaction, got %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed < server.Config.WriteTimeout {
+		t.Errorf("Expected the compaction to outlast the write timeout, took %v", elapsed)
+	}
+}
+
+func TestHandler_HandleRateLimit(t *testing.T) {
+	handler, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		expectedStatus int
+		expectedLimit  int64
+	}{
+		{name: "Unlimited by default", method: http.MethodGet, expectedStatus: http.StatusOK, expectedLimit: 0},
+		{name: "Set limit", method: http.MethodPut, body: `{"bytes_per_second": 1048576}`, expectedStatus: http.StatusOK, expectedLimit: 1048576},
+		{name: "Read back limit", method: http.MethodGet, expectedStatus: http.StatusOK, expectedLimit: 1048576},
+		{name: "Negative limit", method: http.MethodPut, body: `{"bytes_per_second": -1}`, expectedStatus: http.StatusBadRequest},
+		{name: "Invalid method", method: http.MethodDelete, expectedStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/admin/rate_limit", bytes.NewBufferString(tt.body))
+			rr := httptest.NewRecorder()
+			handler.HandleRateLimit(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var response RateLimitResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if response.BytesPerSecond != tt.expectedLimit {
+				t.Errorf("Expected limit %d, got %d", tt.expectedLimit, response.BytesPerSecond)
+			}
+		})
+	}
+}
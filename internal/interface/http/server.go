@@ -19,8 +19,14 @@ func NewServer(handler *Handler, port string) *Server {
 	// Setup routes with logging middleware
 	mux.HandleFunc("/api/put", loggingMiddleware(handler.HandlePut))
 	mux.HandleFunc("/api/get/", loggingMiddleware(handler.HandleGet))
+	mux.HandleFunc("/api/cas", loggingMiddleware(handler.HandleCompareAndSwap))
+	mux.HandleFunc("/api/put_if_absent", loggingMiddleware(handler.HandlePutIfAbsent))
+	mux.HandleFunc("/api/cf", loggingMiddleware(handler.HandleColumnFamilies))
+	mux.HandleFunc("/api/cf/", loggingMiddleware(handler.HandleColumnFamily))
 	mux.HandleFunc("/api/delete", loggingMiddleware(handler.HandleDelete))
 	mux.HandleFunc("/api/status", loggingMiddleware(handler.HandleStatus))
+	mux.HandleFunc("/api/compact", loggingMiddleware(handler.HandleCompact))
+	mux.HandleFunc("/api/admin/rate_limit", loggingMiddleware(handler.HandleRateLimit))
 	mux.HandleFunc("/api/recovery", loggingMiddleware(handler.HandleRecovery))
 	mux.HandleFunc("/health", loggingMiddleware(handler.HandleHealth))
 	mux.HandleFunc("/", loggingMiddleware(handler.HandleAPIDoc))
@@ -29,7 +35,7 @@ func NewServer(handler *Handler, port string) *Server {
 		Addr:         ":" + port,
 		Handler:      mux,
 		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
+		WriteTimeout: 15 * time.Second, // lifted by POST /api/compact with wait, which lasts as long as the compaction
 		IdleTimeout:  60 * time.Second,
 	}
 
@@ -70,3 +76,8 @@ func (lw *loggingResponseWriter) WriteHeader(code int) {
 	lw.statusCode = code
 	lw.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to change its write deadline
+func (lw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
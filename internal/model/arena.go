@@ -0,0 +1,72 @@
+package model
+
+import "unsafe"
+
+// DefaultArenaBlockSize is the size of the blocks an Arena carves allocations from
+const DefaultArenaBlockSize = 64 * 1024
+
+// minArenaBlockSize keeps blocks of arenas sized for small memtables useful
+const minArenaBlockSize = 1024
+
+// Arena hands out byte slices carved from large blocks, so a memtable's keys and values
+// cost the garbage collector a few large objects instead of two small ones per write
+// Memory is only released as a whole when the arena is no longer referenced; an Arena is not safe for concurrent use
+type Arena struct {
+	blockSize   int
+	current     []byte // unused rest of the current block
+	memoryUsage int    // bytes of all blocks and large allocations
+}
+
+// NewArena creates an arena allocating blocks of the given size
+func NewArena(blockSize int) *Arena {
+	if blockSize < minArenaBlockSize {
+		blockSize = minArenaBlockSize
+	}
+	return &Arena{blockSize: blockSize}
+}
+
+// Allocate returns a zeroed slice of n bytes; its capacity is n, so appending to it never overwrites other allocations
+func (a *Arena) Allocate(n int) []byte {
+	// Slicing the nil block of a fresh arena would turn an empty value into nil
+	if n == 0 {
+		return []byte{}
+	}
+
+	// Large allocations get their own slice instead of wasting the rest of the current block
+	if n > a.blockSize/4 {
+		a.memoryUsage += n
+		return make([]byte, n)
+	}
+
+	if n > len(a.current) {
+		a.current = make([]byte, a.blockSize)
+		a.memoryUsage += a.blockSize
+	}
+	allocation := a.current[:n:n]
+	a.current = a.current[n:]
+	return allocation
+}
+
+// Copy returns a copy of data allocated from the arena; nil stays nil
+func (a *Arena) Copy(data []byte) []byte {
+	if data == nil {
+		return nil
+	}
+	allocation := a.Allocate(len(data))
+	copy(allocation, data)
+	return allocation
+}
+
+// arenaString returns a string sharing the memory of an arena allocation, without copying it
+// Only valid because arena allocations are never modified once written
+func arenaString(allocation []byte) string {
+	if len(allocation) == 0 {
+		return ""
+	}
+	return unsafe.String(&allocation[0], len(allocation))
+}
+
+// MemoryUsage returns the number of bytes the arena has reserved
+func (a *Arena) MemoryUsage() int {
+	return a.memoryUsage
+}
@@ -0,0 +1,60 @@
+package model
+
+import "testing"
+
+func TestArenaAllocate(t *testing.T) {
+	arena := NewArena(1024)
+
+	first := arena.Allocate(100)
+	second := arena.Allocate(100)
+	if len(first) != 100 || cap(first) != 100 {
+		t.Errorf("Expected len and cap 100, got %d and %d", len(first), cap(first))
+	}
+	if arena.MemoryUsage() != 1024 {
+		t.Errorf("Expected both allocations to share one block, got usage %d", arena.MemoryUsage())
+	}
+
+	// Appending to an allocation must not overwrite the next one
+	second[0] = 'x'
+	_ = append(first, 'y')
+	if second[0] != 'x' {
+		t.Error("Append overwrote a neighbouring allocation")
+	}
+
+	// Large allocations get their own slice
+	arena.Allocate(600)
+	if arena.MemoryUsage() != 1024+600 {
+		t.Errorf("Expected usage %d, got %d", 1024+600, arena.MemoryUsage())
+	}
+
+	// An allocation that does not fit the rest of the block starts a new one
+	arena.Allocate(250)
+	arena.Allocate(250)
+	arena.Allocate(250)
+	arena.Allocate(250)
+	if arena.MemoryUsage() != 2*1024+600 {
+		t.Errorf("Expected a second block, got usage %d", arena.MemoryUsage())
+	}
+}
+
+func TestArenaCopy(t *testing.T) {
+	arena := NewArena(DefaultArenaBlockSize)
+
+	data := []byte("value")
+	copied := arena.Copy(data)
+	data[0] = 'X'
+	if string(copied) != "value" {
+		t.Errorf("Expected copy to be independent of the source, got %s", copied)
+	}
+
+	if arena.Copy(nil) != nil {
+		t.Error("Expected nil to stay nil")
+	}
+	if empty := arena.Copy([]byte{}); empty == nil || len(empty) != 0 {
+		t.Error("Expected an empty, non-nil slice")
+	}
+	// Also before the arena has allocated its first block
+	if empty := NewArena(DefaultArenaBlockSize).Copy([]byte{}); empty == nil || len(empty) != 0 {
+		t.Error("Expected an empty, non-nil slice from a fresh arena")
+	}
+}
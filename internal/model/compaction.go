@@ -1,27 +1,171 @@
 package model
 
 import (
+	"bytes"
 	"fmt"
+	"math"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// compactionFileCounter disambiguates the names of SSTables written by concurrent compactions
+var compactionFileCounter uint64
+
 // CompactionStrategy defines the compaction strategy
 type CompactionStrategy int
 
 const (
 	SizeTieredCompaction CompactionStrategy = iota
 	LeveledCompaction
+	UniversalCompaction
+	TimeWindowCompaction
 )
 
+// String returns the name of the compaction strategy
+func (cs CompactionStrategy) String() string {
+	switch cs {
+	case SizeTieredCompaction:
+		return "size_tiered"
+	case LeveledCompaction:
+		return "leveled"
+	case UniversalCompaction:
+		return "universal"
+	case TimeWindowCompaction:
+		return "time_window"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(cs))
+	}
+}
+
+// ParseCompactionStrategy parses a compaction strategy name as returned by String
+func ParseCompactionStrategy(name string) (CompactionStrategy, error) {
+	switch name {
+	case "size_tiered":
+		return SizeTieredCompaction, nil
+	case "leveled":
+		return LeveledCompaction, nil
+	case "universal":
+		return UniversalCompaction, nil
+	case "time_window":
+		return TimeWindowCompaction, nil
+	default:
+		return 0, fmt.Errorf("unknown compaction strategy %q", name)
+	}
+}
+
+// FilePickingPolicy decides which table of a level leveled compaction moves down
+type FilePickingPolicy int
+
+const (
+	RoundRobinPicking     FilePickingPolicy = iota // cycle through the key space using a per-level compaction pointer
+	MostTombstonesPicking                          // the table holding the most tombstones
+)
+
+// String returns the name of the file picking policy
+func (fp FilePickingPolicy) String() string {
+	switch fp {
+	case RoundRobinPicking:
+		return "round_robin"
+	case MostTombstonesPicking:
+		return "most_tombstones"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(fp))
+	}
+}
+
+// ParseFilePickingPolicy parses a file picking policy name as returned by String
+func ParseFilePickingPolicy(name string) (FilePickingPolicy, error) {
+	switch name {
+	case "round_robin":
+		return RoundRobinPicking, nil
+	case "most_tombstones":
+		return MostTombstonesPicking, nil
+	default:
+		return 0, fmt.Errorf("unknown file picking policy %q", name)
+	}
+}
+
+// SizeTieredOptions configures size-tiered compaction
+type SizeTieredOptions struct {
+	BucketLow      float64 // a table joins a bucket if its size is at least BucketLow * the bucket's average size
+	BucketHigh     float64 // ... and at most BucketHigh * the bucket's average size
+	MinThreshold   int     // minimum number of tables in a bucket before it is compacted
+	MaxThreshold   int     // maximum number of tables compacted at once
+	MinSSTableSize uint64  // tables smaller than this all share one bucket
+}
+
+// DefaultSizeTieredOptions returns the default size-tiered compaction options
+func DefaultSizeTieredOptions() SizeTieredOptions {
+	return SizeTieredOptions{
+		BucketLow:      0.5,
+		BucketHigh:     1.5,
+		MinThreshold:   4,
+		MaxThreshold:   32,
+		MinSSTableSize: 1024 * 1024, // 1MB
+	}
+}
+
+// UniversalOptions configures universal compaction
+type UniversalOptions struct {
+	SizeRatio                   int // percentage of slack when deciding whether a run is similar in size to the runs before it
+	MinMergeWidth               int // minimum number of sorted runs merged by a size-ratio compaction
+	MaxMergeWidth               int // maximum number of sorted runs merged by a size-ratio compaction
+	MaxSizeAmplificationPercent int // full merge once the newer runs exceed this percentage of the oldest run
+	FileNumCompactionTrigger    int // number of sorted runs before compaction starts
+}
+
+// DefaultUniversalOptions returns the default universal compaction options
+func DefaultUniversalOptions() UniversalOptions {
+	return UniversalOptions{
+		SizeRatio:                   1,
+		MinMergeWidth:               2,
+		MaxMergeWidth:               32,
+		MaxSizeAmplificationPercent: 200,
+		FileNumCompactionTrigger:    4,
+	}
+}
+
+// TimeWindowOptions configures time-window compaction
+type TimeWindowOptions struct {
+	WindowSize   time.Duration // width of a write-time window
+	MinThreshold int           // minimum number of tables before the newest window is compacted
+	MaxThreshold int           // maximum number of tables compacted at once
+}
+
+// DefaultTimeWindowOptions returns the default time-window compaction options
+func DefaultTimeWindowOptions() TimeWindowOptions {
+	return TimeWindowOptions{
+		WindowSize:   time.Hour,
+		MinThreshold: 4,
+		MaxThreshold: 32,
+	}
+}
+
 // CompactionManager manages compaction operations
 type CompactionManager struct {
 	strategy          CompactionStrategy
 	maxSizeLevel0     uint64
 	sizeMultiplier    float64
 	maxSSTablesLevel0 int
+	numLevels         int
+	dynamicLevelBytes bool
+	filePicking       FilePickingPolicy
+	compactPointers   map[int][]byte // per level: largest key of the table most recently picked by round robin
+	runningTasks      []*CompactionTask
+	sizeTiered        SizeTieredOptions
+	universal         UniversalOptions
+	timeWindow        TimeWindowOptions
+	mergeOperatorMu   sync.RWMutex // SetMergeOperator may run while compactions execute
+	mergeOperator     MergeOperator
+	maxSubcompactions int
+	rateLimiter       *RateLimiter // throttles compaction output writes; nil means unlimited
 }
 
+// minBlocksPerSubcompaction is the smallest number of input index blocks worth a subcompaction of its own
+const minBlocksPerSubcompaction = 2
+
 // NewCompactionManager creates a new compaction manager
 func NewCompactionManager(strategy CompactionStrategy) *CompactionManager {
 	return &CompactionManager{
@@ -29,15 +173,96 @@ func NewCompactionManager(strategy CompactionStrategy) *CompactionManager {
 		maxSizeLevel0:     10 * 1024 * 1024, // 10MB
 		sizeMultiplier:    10.0,
 		maxSSTablesLevel0: 4,
+		numLevels:         7,
+		compactPointers:   make(map[int][]byte),
+		sizeTiered:        DefaultSizeTieredOptions(),
+		universal:         DefaultUniversalOptions(),
+		timeWindow:        DefaultTimeWindowOptions(),
+		maxSubcompactions: 4,
 	}
 }
 
+// SetDynamicLevelBytes switches leveled compaction to level targets derived from the size of the last level
+// Instead of growing a fixed budget downwards, the last level's actual size is divided by sizeMultiplier
+// upwards, and level 0 compacts straight into the first level whose target reaches the base budget
+func (cm *CompactionManager) SetDynamicLevelBytes(enabled bool) {
+	cm.dynamicLevelBytes = enabled
+}
+
+// SetFilePickingPolicy sets how leveled compaction picks the table to move down from a level
+func (cm *CompactionManager) SetFilePickingPolicy(policy FilePickingPolicy) {
+	cm.filePicking = policy
+}
+
+// CompactPointers returns a copy of the round-robin compaction pointer of each level
+func (cm *CompactionManager) CompactPointers() map[int][]byte {
+	pointers := make(map[int][]byte, len(cm.compactPointers))
+	for level, key := range cm.compactPointers {
+		pointers[level] = key
+	}
+	return pointers
+}
+
+// SetCompactPointers restores round-robin compaction pointers, e.g. from the manifest
+func (cm *CompactionManager) SetCompactPointers(pointers map[int][]byte) {
+	cm.compactPointers = make(map[int][]byte, len(pointers))
+	for level, key := range pointers {
+		cm.compactPointers[level] = key
+	}
+}
+
+// SetSizeTieredOptions configures bucketing for size-tiered compaction
+func (cm *CompactionManager) SetSizeTieredOptions(options SizeTieredOptions) {
+	cm.sizeTiered = options
+}
+
+// SetUniversalOptions configures triggers for universal compaction
+func (cm *CompactionManager) SetUniversalOptions(options UniversalOptions) {
+	cm.universal = options
+}
+
+// SetTimeWindowOptions configures windows for time-window compaction
+func (cm *CompactionManager) SetTimeWindowOptions(options TimeWindowOptions) {
+	cm.timeWindow = options
+}
+
+// SetMergeOperator sets the operator used to combine merge operands during compaction
+func (cm *CompactionManager) SetMergeOperator(operator MergeOperator) {
+	cm.mergeOperatorMu.Lock()
+	defer cm.mergeOperatorMu.Unlock()
+	cm.mergeOperator = operator
+}
+
+// currentMergeOperator returns the operator set by SetMergeOperator
+func (cm *CompactionManager) currentMergeOperator() MergeOperator {
+	cm.mergeOperatorMu.RLock()
+	defer cm.mergeOperatorMu.RUnlock()
+	return cm.mergeOperator
+}
+
+// SetMaxSubcompactions sets how many key ranges a single compaction may be split into and run in parallel
+// One disables subcompactions
+func (cm *CompactionManager) SetMaxSubcompactions(n int) {
+	if n < 1 {
+		n = 1
+	}
+	cm.maxSubcompactions = n
+}
+
+// SetRateLimiter throttles the SSTable writes of compactions at low priority
+func (cm *CompactionManager) SetRateLimiter(limiter *RateLimiter) {
+	cm.rateLimiter = limiter
+}
+
 // CompactionTask represents a compaction operation
 type CompactionTask struct {
 	InputSSTables  []*SSTable
 	OutputLevel    int
 	CompactionType CompactionType
 	EstimatedSize  uint64
+	// RetainTombstones keeps the newest tombstone or expired version of each key, for compactions
+	// that leave older tables holding the same keys untouched
+	RetainTombstones bool
 }
 
 // CompactionType defines the type of compaction
@@ -46,6 +271,8 @@ type CompactionType int
 const (
 	MinorCompaction CompactionType = iota // MemTable to SSTable
 	MajorCompaction                       // SSTable to SSTable merge
+	DropCompaction                        // Fully expired SSTables removed without being rewritten
+	TrivialMove                           // SSTable reassigned to the output level without being rewritten
 )
 
 // ShouldCompact determines if compaction is needed
@@ -55,37 +282,49 @@ func (cm *CompactionManager) ShouldCompact(sstablesByLevel map[int][]*SSTable) b
 		return cm.shouldCompactSizeTiered(sstablesByLevel)
 	case LeveledCompaction:
 		return cm.shouldCompactLeveled(sstablesByLevel)
+	case UniversalCompaction:
+		return cm.selectUniversalCompaction(sstablesByLevel) != nil
+	case TimeWindowCompaction:
+		return cm.selectTimeWindowCompaction(sstablesByLevel) != nil
 	default:
 		return false
 	}
 }
 
-// shouldCompactSizeTiered checks if size-tiered compaction is needed
+// shouldCompactSizeTiered checks if any bucket of similar-sized SSTables is ready for compaction
 func (cm *CompactionManager) shouldCompactSizeTiered(sstablesByLevel map[int][]*SSTable) bool {
-	// Check if Level 0 has too many SSTables
-	level0Tables := sstablesByLevel[0]
-	return len(level0Tables) >= cm.maxSSTablesLevel0
+	return cm.selectSizeTieredBucket(sstablesByLevel) != nil
 }
 
-// shouldCompactLeveled checks if leveled compaction is needed
+// shouldCompactLeveled checks if any level's compaction score reaches 1
 func (cm *CompactionManager) shouldCompactLeveled(sstablesByLevel map[int][]*SSTable) bool {
-	// Check each level for size violations
-	for level, tables := range sstablesByLevel {
-		if level == 0 {
-			// Level 0 is special - check number of SSTables
-			if len(tables) >= cm.maxSSTablesLevel0 {
-				return true
-			}
-		} else {
-			// For other levels, check total size
-			totalSize := cm.calculateTotalSize(tables)
-			maxSize := cm.maxSizeForLevel(level)
-			if totalSize > maxSize {
-				return true
-			}
+	levels, _ := cm.rankLevels(sstablesByLevel)
+	return len(levels) > 0
+}
+
+// PendingCompactionBytes estimates how many bytes compaction has to rewrite to bring the tree back in shape
+// For leveled compaction this is level 0 once it reaches its trigger plus every level's excess over its target;
+// tiered strategies report the size of the next task they would run
+func (cm *CompactionManager) PendingCompactionBytes(sstablesByLevel map[int][]*SSTable) uint64 {
+	if cm.strategy != LeveledCompaction {
+		if task := cm.SelectCompactionTask(sstablesByLevel); task != nil {
+			return task.EstimatedSize
 		}
+		return 0
 	}
-	return false
+
+	var pending uint64
+	if len(sstablesByLevel[0]) >= cm.maxSSTablesLevel0 {
+		pending += cm.calculateTotalSize(sstablesByLevel[0])
+	}
+	targets, _ := cm.LevelTargets(sstablesByLevel)
+	for level := 1; level < cm.numLevels; level++ {
+		size := cm.calculateTotalSize(sstablesByLevel[level])
+		if size > targets[level] {
+			pending += size - targets[level]
+		}
+	}
+	return pending
 }
 
 // calculateTotalSize calculates the total size of SSTables
@@ -117,113 +356,554 @@ func (cm *CompactionManager) SelectCompactionTask(sstablesByLevel map[int][]*SST
 		return cm.selectSizeTieredCompaction(sstablesByLevel)
 	case LeveledCompaction:
 		return cm.selectLeveledCompaction(sstablesByLevel)
+	case UniversalCompaction:
+		return cm.selectUniversalCompaction(sstablesByLevel)
+	case TimeWindowCompaction:
+		return cm.selectTimeWindowCompaction(sstablesByLevel)
 	default:
 		return nil
 	}
 }
 
-// selectSizeTieredCompaction selects SSTables for size-tiered compaction
+// selectSizeTieredCompaction merges the SSTables of one bucket of similar-sized tables
+// Size-tiered compaction does not use levels for placement: the output stays in level 0
 func (cm *CompactionManager) selectSizeTieredCompaction(sstablesByLevel map[int][]*SSTable) *CompactionTask {
-	// Find the level with most SSTables
-	var maxLevel int
-	var maxCount int
+	bucket := cm.selectSizeTieredBucket(sstablesByLevel)
+	if bucket == nil {
+		return nil
+	}
 
-	for level, tables := range sstablesByLevel {
-		if len(tables) > maxCount {
-			maxCount = len(tables)
-			maxLevel = level
+	return &CompactionTask{
+		InputSSTables:    bucket,
+		OutputLevel:      0,
+		CompactionType:   MajorCompaction,
+		EstimatedSize:    cm.calculateTotalSize(bucket),
+		RetainTombstones: len(bucket) < countTables(sstablesByLevel),
+	}
+}
+
+// selectSizeTieredBucket returns the bucket to compact next, or nil if no bucket reaches MinThreshold
+// Among eligible buckets the one with the smallest average size is chosen, as it is the cheapest to merge
+func (cm *CompactionManager) selectSizeTieredBucket(sstablesByLevel map[int][]*SSTable) []*SSTable {
+	var selected []*SSTable
+	var selectedAvg uint64
+
+	for _, bucket := range cm.sizeTieredBuckets(sstablesByLevel) {
+		if len(bucket) < cm.sizeTiered.MinThreshold {
+			continue
+		}
+		if len(bucket) > cm.sizeTiered.MaxThreshold {
+			bucket = bucket[:cm.sizeTiered.MaxThreshold] // smallest tables first
+		}
+
+		avg := cm.calculateTotalSize(bucket) / uint64(len(bucket))
+		if selected == nil || avg < selectedAvg {
+			selected = bucket
+			selectedAvg = avg
 		}
 	}
 
-	if maxCount < cm.maxSSTablesLevel0 {
+	return selected
+}
+
+// sizeTieredBuckets groups all SSTables not being compacted into buckets of similar size, each sorted by size ascending
+func (cm *CompactionManager) sizeTieredBuckets(sstablesByLevel map[int][]*SSTable) [][]*SSTable {
+	tables := idleTables(sstablesByLevel)
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].metadata.FileSize < tables[j].metadata.FileSize
+	})
+
+	var buckets [][]*SSTable
+	var averages []uint64
+
+	for _, table := range tables {
+		size := table.metadata.FileSize
+		placed := false
+
+		for i, avg := range averages {
+			similar := float64(size) >= float64(avg)*cm.sizeTiered.BucketLow &&
+				float64(size) <= float64(avg)*cm.sizeTiered.BucketHigh
+			small := size < cm.sizeTiered.MinSSTableSize && avg < cm.sizeTiered.MinSSTableSize
+			if similar || small {
+				buckets[i] = append(buckets[i], table)
+				averages[i] = cm.calculateTotalSize(buckets[i]) / uint64(len(buckets[i]))
+				placed = true
+				break
+			}
+		}
+
+		if !placed {
+			buckets = append(buckets, []*SSTable{table})
+			averages = append(averages, size)
+		}
+	}
+
+	return buckets
+}
+
+// countTables returns the total number of SSTables across all levels
+func countTables(sstablesByLevel map[int][]*SSTable) int {
+	count := 0
+	for _, tables := range sstablesByLevel {
+		count += len(tables)
+	}
+	return count
+}
+
+// selectUniversalCompaction picks adjacent sorted runs to merge
+// Every SSTable is one sorted run and runs are ordered by age; a compaction always merges runs that are
+// adjacent in age, so the output stays in level 0 and takes their place
+func (cm *CompactionManager) selectUniversalCompaction(sstablesByLevel map[int][]*SSTable) *CompactionTask {
+	runs := sortedRunsByAge(sstablesByLevel)
+	if len(runs) < cm.universal.FileNumCompactionTrigger || len(runs) < 2 {
+		return nil
+	}
+
+	// Merged runs must be adjacent in age, so universal compaction runs one task at a time
+	if len(idleTables(sstablesByLevel)) < len(runs) {
 		return nil
 	}
 
-	tables := sstablesByLevel[maxLevel]
-	estimatedSize := cm.calculateTotalSize(tables)
+	// Space amplification: everything newer than the oldest run is potentially garbage
+	oldest := runs[len(runs)-1]
+	newerSize := cm.calculateTotalSize(runs[:len(runs)-1])
+	if oldest.metadata.FileSize > 0 &&
+		newerSize*100 > oldest.metadata.FileSize*uint64(cm.universal.MaxSizeAmplificationPercent) {
+		return cm.newUniversalTask(runs, 0, len(runs))
+	}
+
+	// Size ratio: starting from the newest run, keep adding older runs while each is not much
+	// larger than everything picked so far
+	for start := 0; start < len(runs)-1; start++ {
+		accumulated := runs[start].metadata.FileSize
+		end := start + 1
+		for end < len(runs) && end-start < cm.universal.MaxMergeWidth {
+			next := runs[end].metadata.FileSize
+			if next*100 > accumulated*uint64(100+cm.universal.SizeRatio) {
+				break
+			}
+			accumulated += next
+			end++
+		}
+		if end-start >= cm.universal.MinMergeWidth {
+			return cm.newUniversalTask(runs, start, end)
+		}
+	}
+
+	// Still too many runs: merge the newest ones to get back under the trigger
+	count := len(runs) - cm.universal.FileNumCompactionTrigger + 1
+	if count < 2 {
+		count = 2
+	}
+	return cm.newUniversalTask(runs, 0, count)
+}
 
+// newUniversalTask creates a task merging the sorted runs runs[start:end]
+func (cm *CompactionManager) newUniversalTask(runs []*SSTable, start, end int) *CompactionTask {
+	inputs := append([]*SSTable{}, runs[start:end]...)
 	return &CompactionTask{
-		InputSSTables:  tables,
-		OutputLevel:    maxLevel + 1,
+		InputSSTables:  inputs,
+		OutputLevel:    0,
 		CompactionType: MajorCompaction,
-		EstimatedSize:  estimatedSize,
+		EstimatedSize:  cm.calculateTotalSize(inputs),
+		// Tombstones can only be dropped when the oldest run takes part
+		RetainTombstones: end < len(runs),
 	}
 }
 
-// selectLeveledCompaction selects SSTables for leveled compaction
-func (cm *CompactionManager) selectLeveledCompaction(sstablesByLevel map[int][]*SSTable) *CompactionTask {
-	// Check Level 0 first
-	level0Tables := sstablesByLevel[0]
-	if len(level0Tables) >= cm.maxSSTablesLevel0 {
-		// Compact all Level 0 tables with overlapping Level 1 tables
-		level1Tables := cm.findOverlappingTables(level0Tables, sstablesByLevel[1])
+// sortedRunsByAge returns all SSTables ordered from newest to oldest by their sequence numbers
+func sortedRunsByAge(sstablesByLevel map[int][]*SSTable) []*SSTable {
+	runs := make([]*SSTable, 0)
+	for _, tables := range sstablesByLevel {
+		runs = append(runs, tables...)
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].metadata.MaxSequence > runs[j].metadata.MaxSequence
+	})
+	return runs
+}
 
-		allTables := append(level0Tables, level1Tables...)
-		estimatedSize := cm.calculateTotalSize(allTables)
+// selectTimeWindowCompaction drops fully expired SSTables, or else merges the SSTables of one write-time window
+// Tables are never merged across windows and all of them stay in level 0
+func (cm *CompactionManager) selectTimeWindowCompaction(sstablesByLevel map[int][]*SSTable) *CompactionTask {
+	tables := make([]*SSTable, 0)
+	for _, levelTables := range sstablesByLevel {
+		tables = append(tables, levelTables...)
+	}
+	candidates := idleTables(sstablesByLevel)
 
+	// Fully expired tables are dropped whole, unless their expired entries still shadow older versions elsewhere
+	now := time.Now()
+	expired := make([]*SSTable, 0)
+	for _, table := range candidates {
+		if isFullyExpiredAt(table, now) && !cm.hasOlderOverlap([]*SSTable{table}, tables) {
+			expired = append(expired, table)
+		}
+	}
+	if len(expired) > 0 {
 		return &CompactionTask{
-			InputSSTables:  allTables,
-			OutputLevel:    1,
-			CompactionType: MajorCompaction,
-			EstimatedSize:  estimatedSize,
+			InputSSTables:  expired,
+			OutputLevel:    0,
+			CompactionType: DropCompaction,
+		}
+	}
+
+	// Group tables by the window of their newest write
+	var newestWindow int64
+	for i, table := range tables {
+		if window := cm.timeWindowOf(table); i == 0 || window > newestWindow {
+			newestWindow = window
 		}
 	}
+	windows := make(map[int64][]*SSTable)
+	for _, table := range candidates {
+		window := cm.timeWindowOf(table)
+		windows[window] = append(windows[window], table)
+	}
 
-	// Check other levels
-	for level := 1; level < 10; level++ { // Arbitrary max level
-		tables := sstablesByLevel[level]
-		if len(tables) == 0 {
+	starts := make([]int64, 0, len(windows))
+	for start := range windows {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] > starts[j] })
+
+	// The newest window still receives flushes, so it waits for MinThreshold tables;
+	// older windows are compacted down to a single table
+	for _, start := range starts {
+		windowTables := windows[start]
+		threshold := 2
+		if start == newestWindow {
+			threshold = cm.timeWindow.MinThreshold
+		}
+		if len(windowTables) < threshold {
 			continue
 		}
 
-		totalSize := cm.calculateTotalSize(tables)
-		maxSize := cm.maxSizeForLevel(level)
+		sort.Slice(windowTables, func(i, j int) bool {
+			return windowTables[i].metadata.MaxSequence > windowTables[j].metadata.MaxSequence
+		})
+		if len(windowTables) > cm.timeWindow.MaxThreshold {
+			windowTables = windowTables[:cm.timeWindow.MaxThreshold]
+		}
 
-		if totalSize > maxSize {
-			// Select oldest table for compaction
-			sort.Slice(tables, func(i, j int) bool {
-				return tables[i].metadata.CreatedAt.Before(tables[j].metadata.CreatedAt)
-			})
+		return &CompactionTask{
+			InputSSTables:    windowTables,
+			OutputLevel:      0,
+			CompactionType:   MajorCompaction,
+			EstimatedSize:    cm.calculateTotalSize(windowTables),
+			RetainTombstones: cm.hasOlderOverlap(windowTables, tables),
+		}
+	}
+
+	return nil
+}
 
-			selectedTable := []*SSTable{tables[0]}
-			nextLevelTables := cm.findOverlappingTables(selectedTable, sstablesByLevel[level+1])
+// timeWindowOf returns the start of the write-time window of the table's newest entry, in Unix nanoseconds
+func (cm *CompactionManager) timeWindowOf(table *SSTable) int64 {
+	return table.metadata.MaxTimestamp.Truncate(cm.timeWindow.WindowSize).UnixNano()
+}
 
-			allTables := append(selectedTable, nextLevelTables...)
-			estimatedSize := cm.calculateTotalSize(allTables)
+// hasOlderOverlap reports whether any table outside inputs may hold older versions of keys in inputs
+func (cm *CompactionManager) hasOlderOverlap(inputs, tables []*SSTable) bool {
+	isInput := make(map[*SSTable]bool, len(inputs))
+	var maxSequence uint64
+	for _, table := range inputs {
+		isInput[table] = true
+		if table.metadata.MaxSequence > maxSequence {
+			maxSequence = table.metadata.MaxSequence
+		}
+	}
 
-			return &CompactionTask{
-				InputSSTables:  allTables,
-				OutputLevel:    level + 1,
-				CompactionType: MajorCompaction,
-				EstimatedSize:  estimatedSize,
+	for _, table := range tables {
+		if isInput[table] || table.metadata.MinSequence > maxSequence {
+			continue
+		}
+		for _, input := range inputs {
+			if cm.keyRangesOverlap(input.metadata.MinKey, input.metadata.MaxKey, table.metadata.MinKey, table.metadata.MaxKey) {
+				return true
 			}
 		}
 	}
+	return false
+}
+
+// isFullyExpiredAt reports whether every entry of the table is past its TTL at the given time
+func isFullyExpiredAt(table *SSTable, now time.Time) bool {
+	expiresAt := table.metadata.MaxExpiresAt
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// LevelTargets returns the target size of each level and the level that level 0 compacts into
+func (cm *CompactionManager) LevelTargets(sstablesByLevel map[int][]*SSTable) (map[int]uint64, int) {
+	if cm.dynamicLevelBytes {
+		return cm.DynamicLevelTargets(sstablesByLevel)
+	}
+
+	targets := make(map[int]uint64)
+	for level := 1; level < cm.numLevels; level++ {
+		targets[level] = cm.maxSizeForLevel(level)
+	}
+	return targets, 1
+}
+
+// DynamicLevelTargets returns the target size of each level and the level that level 0 compacts into
+// Levels above the base level have a target of 0 and are expected to stay empty
+func (cm *CompactionManager) DynamicLevelTargets(sstablesByLevel map[int][]*SSTable) (map[int]uint64, int) {
+	lastLevel := cm.numLevels - 1
+	targets := make(map[int]uint64)
+
+	lastSize := cm.calculateTotalSize(sstablesByLevel[lastLevel])
+	if lastSize < cm.maxSizeLevel0 {
+		lastSize = cm.maxSizeLevel0
+	}
+	targets[lastLevel] = lastSize
+
+	baseLevel := lastLevel
+	for level := lastLevel - 1; level >= 1; level-- {
+		target := uint64(float64(targets[level+1]) / cm.sizeMultiplier)
+		if target < cm.maxSizeLevel0 {
+			break
+		}
+		targets[level] = target
+		baseLevel = level
+	}
+
+	return targets, baseLevel
+}
+
+// LevelScores returns the compaction score of every level that has tables
+// Level 0 is scored by its table count, other levels by their size relative to their target;
+// data left above the base level scores +Inf, as it always needs to move down
+func (cm *CompactionManager) LevelScores(sstablesByLevel map[int][]*SSTable) map[int]float64 {
+	targets, _ := cm.LevelTargets(sstablesByLevel)
 
+	scores := make(map[int]float64)
+	if len(sstablesByLevel[0]) > 0 {
+		scores[0] = float64(len(sstablesByLevel[0])) / float64(cm.maxSSTablesLevel0)
+	}
+	for level := 1; level < cm.numLevels; level++ {
+		size := cm.calculateTotalSize(sstablesByLevel[level])
+		if size == 0 {
+			continue
+		}
+		if target := targets[level]; target > 0 {
+			scores[level] = float64(size) / float64(target)
+		} else {
+			scores[level] = math.Inf(1)
+		}
+	}
+	return scores
+}
+
+// rankLevels returns the levels whose compaction score reaches 1, highest score first, together with the base level
+func (cm *CompactionManager) rankLevels(sstablesByLevel map[int][]*SSTable) ([]int, int) {
+	_, baseLevel := cm.LevelTargets(sstablesByLevel)
+	scores := cm.LevelScores(sstablesByLevel)
+
+	// The last level has no level below it to compact into
+	levels := make([]int, 0)
+	for level := 0; level < cm.numLevels-1; level++ {
+		if score, ok := scores[level]; ok && score >= 1 {
+			levels = append(levels, level)
+		}
+	}
+
+	// Ties go to the upper level
+	sort.SliceStable(levels, func(i, j int) bool {
+		return scores[levels[i]] > scores[levels[j]]
+	})
+	return levels, baseLevel
+}
+
+// selectLeveledCompaction compacts the level with the highest score into the level below it
+// Levels whose candidate tables conflict with running compactions are skipped in favour of the next best level
+func (cm *CompactionManager) selectLeveledCompaction(sstablesByLevel map[int][]*SSTable) *CompactionTask {
+	levels, baseLevel := cm.rankLevels(sstablesByLevel)
+	for _, level := range levels {
+		if task := cm.buildLeveledTask(level, baseLevel, sstablesByLevel); task != nil {
+			return task
+		}
+	}
 	return nil
 }
 
-// findOverlappingTables finds SSTables that overlap with the given tables
-func (cm *CompactionManager) findOverlappingTables(inputTables, candidateTables []*SSTable) []*SSTable {
-	if len(inputTables) == 0 || len(candidateTables) == 0 {
-		return []*SSTable{}
+// buildLeveledTask creates the task compacting level into the level below it, or returns nil on conflicts
+func (cm *CompactionManager) buildLeveledTask(level, baseLevel int, sstablesByLevel map[int][]*SSTable) *CompactionTask {
+	var inputs []*SSTable
+	outputLevel := level + 1
+	if level < baseLevel {
+		// Level 0 and any leftovers above the base level move into the base level as a whole
+		inputs = append([]*SSTable{}, sstablesByLevel[level]...)
+		outputLevel = baseLevel
+	} else {
+		picked := cm.pickFile(level, sstablesByLevel[level])
+		if picked == nil {
+			return nil
+		}
+		inputs = []*SSTable{picked}
 	}
 
-	// Find min and max keys from input tables
+	overlapping := cm.findOverlappingTables(inputs, sstablesByLevel[outputLevel])
+	inputs = append(inputs, overlapping...)
+
+	task := &CompactionTask{
+		InputSSTables:    inputs,
+		OutputLevel:      outputLevel,
+		CompactionType:   MajorCompaction,
+		EstimatedSize:    cm.calculateTotalSize(inputs),
+		RetainTombstones: cm.overlapsDeeperLevels(inputs, sstablesByLevel, outputLevel),
+	}
+	// A single table that overlaps nothing in the output level keeps its contents as they are,
+	// so it is moved down by updating its level instead of being read and rewritten
+	if len(inputs) == 1 && len(overlapping) == 0 {
+		task.CompactionType = TrivialMove
+		task.EstimatedSize = 0
+	}
+	if cm.conflictsWithRunning(task) {
+		return nil
+	}
+
+	if level >= baseLevel {
+		cm.compactPointers[level] = inputs[0].metadata.MaxKey
+	}
+	return task
+}
+
+// pickFile chooses the table of a level to compact down according to the file picking policy
+// Tables being compacted are never picked; returns nil if all of them are
+func (cm *CompactionManager) pickFile(level int, tables []*SSTable) *SSTable {
+	candidates := make([]*SSTable, 0, len(tables))
+	for _, table := range tables {
+		if !table.beingCompacted {
+			candidates = append(candidates, table)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	if cm.filePicking == MostTombstonesPicking {
+		picked := candidates[0]
+		for _, table := range candidates[1:] {
+			if table.metadata.TombstoneCount > picked.metadata.TombstoneCount {
+				picked = table
+			}
+		}
+		return picked
+	}
+
+	// Round robin: the first table starting after the key where the last compaction of this level ended
+	sort.Slice(candidates, func(i, j int) bool {
+		return compareKeys(candidates[i].metadata.MinKey, candidates[j].metadata.MinKey) < 0
+	})
+
+	if pointer, ok := cm.compactPointers[level]; ok {
+		for _, table := range candidates {
+			if compareKeys(table.metadata.MinKey, pointer) > 0 {
+				return table
+			}
+		}
+	}
+	return candidates[0] // wrap around to the start of the key space
+}
+
+// overlapsDeeperLevels reports whether any level below outputLevel holds keys in the range of inputs
+func (cm *CompactionManager) overlapsDeeperLevels(inputs []*SSTable, sstablesByLevel map[int][]*SSTable, outputLevel int) bool {
+	for level, tables := range sstablesByLevel {
+		if level > outputLevel && len(cm.findOverlappingTables(inputs, tables)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// StartCompaction marks the task's input tables as being compacted, so concurrently selected tasks avoid them
+// Callers must serialize StartCompaction, FinishCompaction and SelectCompactionTask
+func (cm *CompactionManager) StartCompaction(task *CompactionTask) {
+	for _, table := range task.InputSSTables {
+		table.beingCompacted = true
+	}
+	cm.runningTasks = append(cm.runningTasks, task)
+}
+
+// FinishCompaction releases the task's input tables once its results are installed or abandoned
+func (cm *CompactionManager) FinishCompaction(task *CompactionTask) {
+	for _, table := range task.InputSSTables {
+		table.beingCompacted = false
+	}
+	for i, running := range cm.runningTasks {
+		if running == task {
+			cm.runningTasks = append(cm.runningTasks[:i:i], cm.runningTasks[i+1:]...)
+			break
+		}
+	}
+}
+
+// RunningCompactions returns the number of tasks started and not yet finished
+func (cm *CompactionManager) RunningCompactions() int {
+	return len(cm.runningTasks)
+}
+
+// conflictsWithRunning reports whether a task uses a table that is being compacted, or would write
+// into a key range of a level (other than level 0) that a running task is also writing into
+func (cm *CompactionManager) conflictsWithRunning(task *CompactionTask) bool {
+	for _, table := range task.InputSSTables {
+		if table.beingCompacted {
+			return true
+		}
+	}
+
+	if task.OutputLevel == 0 {
+		return false // level 0 tables may overlap; reads order them by sequence number
+	}
+	minKey, maxKey := keyRange(task.InputSSTables)
+	for _, running := range cm.runningTasks {
+		if running.OutputLevel != task.OutputLevel {
+			continue
+		}
+		runningMin, runningMax := keyRange(running.InputSSTables)
+		if cm.keyRangesOverlap(minKey, maxKey, runningMin, runningMax) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyRange returns the smallest and largest key covered by the tables
+func keyRange(tables []*SSTable) ([]byte, []byte) {
 	var minKey, maxKey []byte
-	for i, table := range inputTables {
-		if i == 0 {
+	for i, table := range tables {
+		if i == 0 || compareKeys(table.metadata.MinKey, minKey) < 0 {
 			minKey = table.metadata.MinKey
+		}
+		if i == 0 || compareKeys(table.metadata.MaxKey, maxKey) > 0 {
 			maxKey = table.metadata.MaxKey
-		} else {
-			if compareKeys(table.metadata.MinKey, minKey) < 0 {
-				minKey = table.metadata.MinKey
-			}
-			if compareKeys(table.metadata.MaxKey, maxKey) > 0 {
-				maxKey = table.metadata.MaxKey
+		}
+	}
+	return minKey, maxKey
+}
+
+// idleTables returns all tables that are not being compacted
+func idleTables(sstablesByLevel map[int][]*SSTable) []*SSTable {
+	tables := make([]*SSTable, 0)
+	for _, levelTables := range sstablesByLevel {
+		for _, table := range levelTables {
+			if !table.beingCompacted {
+				tables = append(tables, table)
 			}
 		}
 	}
+	return tables
+}
+
+// findOverlappingTables finds SSTables that overlap with the given tables
+func (cm *CompactionManager) findOverlappingTables(inputTables, candidateTables []*SSTable) []*SSTable {
+	if len(inputTables) == 0 || len(candidateTables) == 0 {
+		return []*SSTable{}
+	}
+
+	// Find min and max keys from input tables
+	minKey, maxKey := keyRange(inputTables)
 
 	// Find overlapping tables
 	var overlapping []*SSTable
@@ -243,37 +923,109 @@ func (cm *CompactionManager) keyRangesOverlap(min1, max1, min2, max2 []byte) boo
 	return compareKeys(max1, min2) >= 0 && compareKeys(max2, min1) >= 0
 }
 
-// compareKeys compares two keys
+// compareKeys compares two keys in the same byte-wise order used to sort entries
 func compareKeys(key1, key2 []byte) int {
-	if len(key1) < len(key2) {
-		return -1
+	return bytes.Compare(key1, key2)
+}
+
+// ExecuteCompaction executes a compaction task
+func (cm *CompactionManager) ExecuteCompaction(task *CompactionTask, outputDir string) ([]*SSTable, error) {
+	if len(task.InputSSTables) == 0 {
+		return nil, fmt.Errorf("no input SSTables for compaction")
 	}
-	if len(key1) > len(key2) {
-		return 1
+
+	// Dropped and moved tables are not rewritten: the caller only updates the table registry
+	if task.CompactionType == DropCompaction || task.CompactionType == TrivialMove {
+		return []*SSTable{}, nil
+	}
+
+	// Large compactions into a level are split into disjoint key ranges that run on separate goroutines;
+	// level 0 outputs stay in one table because size-tiered and universal compaction treat each table as a run
+	bounds := [][]byte{nil, nil}
+	if task.OutputLevel > 0 {
+		bounds = cm.subcompactionBoundaries(task.InputSSTables)
 	}
+	numSubcompactions := len(bounds) - 1
 
-	for i := 0; i < len(key1); i++ {
-		if key1[i] < key2[i] {
-			return -1
+	outputs := make([]*SSTable, numSubcompactions)
+	errs := make([]error, numSubcompactions)
+	var wg sync.WaitGroup
+	for i := 0; i < numSubcompactions; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			outputs[i], errs[i] = cm.runSubcompaction(task, bounds[i], bounds[i+1], outputDir)
+		}(i)
+	}
+	wg.Wait()
+
+	// Outputs are in key order; ranges without surviving entries produce no table
+	outputTables := make([]*SSTable, 0, numSubcompactions)
+	var firstErr error
+	for i, output := range outputs {
+		if errs[i] != nil && firstErr == nil {
+			firstErr = errs[i]
 		}
-		if key1[i] > key2[i] {
-			return 1
+		if output != nil {
+			outputTables = append(outputTables, output)
 		}
 	}
-	return 0
+	if firstErr != nil {
+		// The task is abandoned as a whole, so tables built by the other subcompactions are discarded
+		for _, output := range outputTables {
+			output.Remove()
+		}
+		return nil, firstErr
+	}
+
+	return outputTables, nil
 }
 
-// ExecuteCompaction executes a compaction task
-func (cm *CompactionManager) ExecuteCompaction(task *CompactionTask, outputDir string) ([]*SSTable, error) {
-	if len(task.InputSSTables) == 0 {
-		return nil, fmt.Errorf("no input SSTables for compaction")
+// subcompactionBoundaries splits the key space of the inputs at block index keys
+// It returns n+1 bounds for n ranges [bounds[i], bounds[i+1]); the first and last bound are nil (unbounded)
+func (cm *CompactionManager) subcompactionBoundaries(inputs []*SSTable) [][]byte {
+	keys := make([][]byte, 0)
+	for _, table := range inputs {
+		if table.metadata.BlockIndex == nil {
+			continue
+		}
+		for _, indexEntry := range table.metadata.BlockIndex.GetEntries() {
+			keys = append(keys, indexEntry.Key)
+		}
+	}
+
+	numSubcompactions := len(keys) / minBlocksPerSubcompaction
+	if numSubcompactions > cm.maxSubcompactions {
+		numSubcompactions = cm.maxSubcompactions
+	}
+	if numSubcompactions <= 1 {
+		return [][]byte{nil, nil}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+
+	// Pick evenly spaced block keys; the smallest one would only produce an empty first range
+	bounds := [][]byte{nil}
+	for i := 1; i < numSubcompactions; i++ {
+		key := keys[i*len(keys)/numSubcompactions]
+		if bytes.Equal(key, keys[0]) || bytes.Equal(key, bounds[len(bounds)-1]) {
+			continue
+		}
+		bounds = append(bounds, key)
 	}
+	return append(bounds, nil)
+}
 
-	// Collect all entries from input SSTables
+// runSubcompaction merges the input entries with start <= key < end into one output table
+// It returns a nil table if no entries survive
+func (cm *CompactionManager) runSubcompaction(task *CompactionTask, start, end []byte, outputDir string) (*SSTable, error) {
+	// Collect the range's entries from input SSTables
 	allEntries := make([]*Entry, 0)
 
 	for _, sstable := range task.InputSSTables {
-		entries, err := sstable.GetAllEntries()
+		entries, err := sstable.GetEntriesInRange(start, end)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read entries from SSTable: %w", err)
 		}
@@ -290,31 +1042,107 @@ func (cm *CompactionManager) ExecuteCompaction(task *CompactionTask, outputDir s
 		return cmp < 0
 	})
 
-	// Remove duplicates and tombstones
-	compactedEntries := cm.removeDuplicatesAndTombstones(allEntries)
+	// Collapse merge operands into the newest version of each key
+	mergedEntries, err := cm.applyMergeOperands(allEntries, task.RetainTombstones)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply merge operands: %w", err)
+	}
+
+	// Remove duplicates and tombstones; tombstones must survive while older tables may still hold the key
+	var compactedEntries []*Entry
+	if task.RetainTombstones {
+		compactedEntries = cm.removeDuplicates(mergedEntries)
+	} else {
+		compactedEntries = cm.removeDuplicatesAndTombstones(mergedEntries)
+	}
 
-	// Build new SSTables
 	if len(compactedEntries) == 0 {
-		return []*SSTable{}, nil
+		return nil, nil
 	}
 
-	// Split into multiple SSTables if necessary (simple implementation: one SSTable)
+	// Build the range's SSTable
 	builder := NewSSTableBuilder(task.OutputLevel, uint32(len(compactedEntries)))
+	if cm.rateLimiter != nil {
+		builder.SetRateLimiter(cm.rateLimiter, IOPriorityLow)
+	}
 
 	for _, entry := range compactedEntries {
 		builder.AddEntry(entry)
 	}
 
-	filename := fmt.Sprintf("sstable_level_%d_%d.sst", task.OutputLevel, time.Now().UnixNano())
+	// Compactions may run in parallel, so the timestamp alone does not make the name unique
+	filename := fmt.Sprintf("sstable_level_%d_%d_%d.sst", task.OutputLevel, time.Now().UnixNano(), atomic.AddUint64(&compactionFileCounter, 1))
 	newSSTable, err := builder.Build(outputDir, filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build compacted SSTable: %w", err)
 	}
 
-	return []*SSTable{newSSTable}, nil
+	return newSSTable, nil
 }
 
-// removeDuplicatesAndTombstones removes duplicate keys and handles tombstones
+// applyMergeOperands replaces each key whose newest version is a merge entry with a single entry
+// Operands are applied to an older value or tombstone when one is among the inputs; otherwise they are
+// stacked into one merge entry, since the base value may live in a level that is not being compacted
+// Operands that resolve to no value leave a tombstone if retainTombstones is set, as older tables may hold the key
+// Entries must be sorted by key, then by timestamp (newest first)
+func (cm *CompactionManager) applyMergeOperands(entries []*Entry, retainTombstones bool) ([]*Entry, error) {
+	result := make([]*Entry, 0, len(entries))
+	operator := cm.currentMergeOperator()
+
+	for i := 0; i < len(entries); {
+		// Find the end of this key's versions
+		j := i + 1
+		for j < len(entries) && entries[j].Compare(entries[i]) == 0 {
+			j++
+		}
+		versions := entries[i:j]
+		i = j
+
+		newest := versions[0]
+		if !newest.IsMerge() {
+			result = append(result, versions...)
+			continue
+		}
+
+		resolver := NewValueResolver(newest.Key(), operator)
+		stacked := newest
+		foundBase := false
+		for k, version := range versions {
+			if resolver.Add(version) {
+				foundBase = true
+				break
+			}
+			if k > 0 {
+				combined, err := stackMergeEntries(version, stacked)
+				if err != nil {
+					return nil, err
+				}
+				stacked = combined
+			}
+		}
+
+		if !foundBase {
+			result = append(result, stacked)
+			continue
+		}
+
+		value, err := resolver.Value()
+		if err == ErrKeyNotFound {
+			if retainTombstones {
+				result = append(result, newResolvedTombstone(newest))
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, newResolvedEntry(newest, value, resolver.ExpiresAt()))
+	}
+
+	return result, nil
+}
+
+// removeDuplicatesAndTombstones removes duplicate keys and handles tombstones and expired entries
 func (cm *CompactionManager) removeDuplicatesAndTombstones(entries []*Entry) []*Entry {
 	if len(entries) == 0 {
 		return entries
@@ -332,8 +1160,8 @@ func (cm *CompactionManager) removeDuplicatesAndTombstones(entries []*Entry) []*
 		}
 		seenKeys[keyStr] = true
 
-		// Skip tombstones (deleted entries)
-		if entry.IsDeleted() {
+		// Skip tombstones (deleted entries) and entries whose TTL has passed
+		if entry.IsDeleted() || entry.IsExpired() {
 			continue
 		}
 
@@ -342,3 +1170,18 @@ func (cm *CompactionManager) removeDuplicatesAndTombstones(entries []*Entry) []*
 
 	return result
 }
+
+// removeDuplicates keeps only the newest version of each key, including tombstones and expired entries
+func (cm *CompactionManager) removeDuplicates(entries []*Entry) []*Entry {
+	result := make([]*Entry, 0, len(entries))
+
+	for i, entry := range entries {
+		// Entries are sorted by key, then timestamp, so the first version of each key is the newest
+		if i > 0 && entry.Compare(entries[i-1]) == 0 {
+			continue
+		}
+		result = append(result, entry)
+	}
+
+	return result
+}
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"bytes"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
@@ -276,3 +278,874 @@ func TestRemoveDuplicatesAndTombstones(t *testing.T) {
 		}
 	}
 }
+
+func TestCompactionDropsExpiredEntries(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "compaction_ttl_test")
+	defer os.RemoveAll(tmpDir)
+
+	cm := NewCompactionManager(LeveledCompaction)
+
+	builder := NewSSTableBuilder(0, 3)
+	builder.AddEntry(NewPutEntryWithTTL([]byte("expired"), []byte("old_session"), time.Millisecond))
+	builder.AddEntry(NewPutEntryWithTTL([]byte("live"), []byte("new_session"), time.Hour))
+	builder.AddEntry(NewPutEntry([]byte("plain"), []byte("value")))
+
+	sst, err := builder.Build(tmpDir, "ttl_input.sst")
+	if err != nil {
+		t.Fatalf("Failed to build SSTable: %v", err)
+	}
+
+	// Let the short TTL pass
+	time.Sleep(5 * time.Millisecond)
+
+	task := &CompactionTask{
+		InputSSTables:  []*SSTable{sst},
+		OutputLevel:    1,
+		CompactionType: MajorCompaction,
+	}
+
+	outputTables, err := cm.ExecuteCompaction(task, tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to execute compaction: %v", err)
+	}
+
+	if len(outputTables) != 1 {
+		t.Fatalf("Expected 1 output table, got %d", len(outputTables))
+	}
+
+	if _, err := outputTables[0].Get([]byte("expired")); err != ErrKeyNotFound {
+		t.Errorf("Expected expired entry to be dropped, got %v", err)
+	}
+
+	for _, key := range []string{"live", "plain"} {
+		if _, err := outputTables[0].Get([]byte(key)); err != nil {
+			t.Errorf("Expected %s to survive compaction: %v", key, err)
+		}
+	}
+}
+
+func TestCompactionAppliesMergeOperands(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "compaction_merge_test")
+	defer os.RemoveAll(tmpDir)
+
+	cm := NewCompactionManager(LeveledCompaction)
+	cm.SetMergeOperator(NewInt64AddOperator())
+
+	older := NewSSTableBuilder(1, 2)
+	base := NewPutEntryWithTTL([]byte("counter"), []byte("10"), time.Hour)
+	older.AddEntry(base)
+	sst1, err := older.Build(tmpDir, "merge_input1.sst")
+	if err != nil {
+		t.Fatalf("Failed to build first SSTable: %v", err)
+	}
+
+	time.Sleep(1 * time.Millisecond)
+	newer := NewSSTableBuilder(0, 2)
+	newer.AddEntry(NewMergeEntry([]byte("counter"), []byte("5")))
+	newer.AddEntry(NewMergeEntry([]byte("pending"), []byte("3")))
+	sst2, err := newer.Build(tmpDir, "merge_input2.sst")
+	if err != nil {
+		t.Fatalf("Failed to build second SSTable: %v", err)
+	}
+
+	task := &CompactionTask{
+		InputSSTables:  []*SSTable{sst1, sst2},
+		OutputLevel:    1,
+		CompactionType: MajorCompaction,
+	}
+
+	outputTables, err := cm.ExecuteCompaction(task, tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to execute compaction: %v", err)
+	}
+
+	// The operand with a base value is resolved into a put
+	entry, err := outputTables[0].Get([]byte("counter"))
+	if err != nil {
+		t.Fatalf("Failed to get counter: %v", err)
+	}
+	if entry.IsMerge() || string(entry.Value()) != "15" {
+		t.Errorf("Expected resolved value 15, got %s (merge=%v)", entry.Value(), entry.IsMerge())
+	}
+	// and expires with its base value
+	if !entry.ExpiresAt().Equal(base.ExpiresAt()) {
+		t.Errorf("Expected expiry %v, got %v", base.ExpiresAt(), entry.ExpiresAt())
+	}
+
+	// The operand without a base value stays a merge entry
+	entry, err = outputTables[0].Get([]byte("pending"))
+	if err != nil {
+		t.Fatalf("Failed to get pending: %v", err)
+	}
+	if !entry.IsMerge() {
+		t.Error("Expected operand without base value to remain a merge entry")
+	}
+}
+
+// clearOperator resolves every merge to no value, deleting the key
+type clearOperator struct{}
+
+func (clearOperator) Name() string { return "clear" }
+
+func (clearOperator) FullMerge(key, existingValue []byte, operands [][]byte) ([]byte, error) {
+	return nil, nil
+}
+
+func TestCompactionRetainsMergedTombstones(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "compaction_merge_tombstone_test")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	cm := NewCompactionManager(LeveledCompaction)
+	cm.SetMergeOperator(clearOperator{})
+
+	build := func(level int, filename string, entries ...*Entry) *SSTable {
+		builder := NewSSTableBuilder(level, uint32(len(entries)))
+		for _, entry := range entries {
+			builder.AddEntry(entry)
+		}
+		table, err := builder.Build(tmpDir, filename)
+		if err != nil {
+			t.Fatalf("Failed to build SSTable: %v", err)
+		}
+		return table
+	}
+	withSequence := func(entry *Entry, sequence uint64) *Entry {
+		entry.SetSequence(sequence)
+		return entry
+	}
+
+	// Level 2 still holds an old value of the key, below the level 0 to level 1 compaction
+	deeper := build(2, "merge_tombstone_deeper.sst", withSequence(NewPutEntry([]byte("key"), []byte("ancient")), 1))
+	level0 := build(0, "merge_tombstone_l0.sst",
+		withSequence(NewPutEntry([]byte("key"), []byte("old")), 2),
+		withSequence(NewMergeEntry([]byte("key"), []byte("clear")), 3))
+	levels := map[int][]*SSTable{0: {level0}, 2: {deeper}}
+
+	inputs := []*SSTable{level0}
+	task := &CompactionTask{
+		InputSSTables:    inputs,
+		OutputLevel:      1,
+		CompactionType:   MajorCompaction,
+		RetainTombstones: cm.overlapsDeeperLevels(inputs, levels, 1),
+	}
+	if !task.RetainTombstones {
+		t.Fatal("Expected tombstones to be retained above level 2")
+	}
+	outputs, err := cm.ExecuteCompaction(task, tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to execute compaction: %v", err)
+	}
+	if len(outputs) != 1 {
+		t.Fatalf("Expected one output table, got %d", len(outputs))
+	}
+	entry, err := outputs[0].Get([]byte("key"))
+	if err != nil || !entry.IsDeleted() || entry.Sequence() != 3 {
+		t.Fatalf("Expected a tombstone with the merge's sequence number, got %v (err %v)", entry, err)
+	}
+
+	// The tombstone keeps the deeper value from coming back
+	version := NewVersion(NewMemTable(10), nil, map[int][]*SSTable{1: outputs, 2: {deeper}}, cm.mergeOperator)
+	defer version.Unref()
+	if value, err := version.Lookup([]byte("key")).Value(); err != ErrKeyNotFound {
+		t.Errorf("Expected the key to stay deleted, got %q (err %v)", value, err)
+	}
+}
+
+func TestSizeTieredCompactionBuckets(t *testing.T) {
+	cm := NewCompactionManager(SizeTieredCompaction)
+	cm.SetSizeTieredOptions(SizeTieredOptions{
+		BucketLow:      0.5,
+		BucketHigh:     1.5,
+		MinThreshold:   3,
+		MaxThreshold:   4,
+		MinSSTableSize: 100,
+	})
+
+	newTable := func(size uint64) *SSTable {
+		return &SSTable{metadata: &SSTableMetadata{Level: 0, FileSize: size, CreatedAt: time.Now()}}
+	}
+
+	// Two tiers of two tables each: no bucket reaches the threshold
+	sstablesByLevel := map[int][]*SSTable{
+		0: {newTable(1000), newTable(1100), newTable(10000), newTable(11000)},
+	}
+	if cm.ShouldCompact(sstablesByLevel) {
+		t.Error("Expected no compaction with buckets below the threshold")
+	}
+
+	// A third large table completes the large bucket
+	sstablesByLevel[0] = append(sstablesByLevel[0], newTable(9000))
+	task := cm.SelectCompactionTask(sstablesByLevel)
+	if task == nil {
+		t.Fatal("Expected compaction task to be selected")
+	}
+	if len(task.InputSSTables) != 3 {
+		t.Errorf("Expected 3 input tables, got %d", len(task.InputSSTables))
+	}
+	for _, table := range task.InputSSTables {
+		if table.metadata.FileSize < 9000 {
+			t.Errorf("Table of size %d should not be in the large bucket", table.metadata.FileSize)
+		}
+	}
+	if task.OutputLevel != 0 {
+		t.Errorf("Expected output level 0, got %d", task.OutputLevel)
+	}
+	if !task.RetainTombstones {
+		t.Error("Expected tombstones to be retained when other tables are not compacted")
+	}
+
+	// Tiny tables share a bucket regardless of their size ratio, and the cheapest bucket wins
+	sstablesByLevel[0] = append(sstablesByLevel[0], newTable(1), newTable(10), newTable(90), newTable(50), newTable(20))
+	task = cm.SelectCompactionTask(sstablesByLevel)
+	if task == nil {
+		t.Fatal("Expected compaction task to be selected")
+	}
+	if len(task.InputSSTables) != 4 {
+		t.Fatalf("Expected bucket to be capped at 4 tables, got %d", len(task.InputSSTables))
+	}
+	for _, table := range task.InputSSTables {
+		if table.metadata.FileSize >= 100 {
+			t.Errorf("Table of size %d should not be in the small bucket", table.metadata.FileSize)
+		}
+	}
+}
+
+func TestCompactionRetainsTombstones(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "compaction_retain_test")
+	defer os.RemoveAll(tmpDir)
+
+	cm := NewCompactionManager(SizeTieredCompaction)
+
+	builder := NewSSTableBuilder(0, 2)
+	builder.AddEntry(NewPutEntry([]byte("live"), []byte("value")))
+	builder.AddEntry(NewDeleteEntry([]byte("deleted")))
+	table, err := builder.Build(tmpDir, "input.sst")
+	if err != nil {
+		t.Fatalf("Failed to build SSTable: %v", err)
+	}
+
+	task := &CompactionTask{
+		InputSSTables:    []*SSTable{table},
+		OutputLevel:      0,
+		CompactionType:   MajorCompaction,
+		RetainTombstones: true,
+	}
+	output, err := cm.ExecuteCompaction(task, tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to execute compaction: %v", err)
+	}
+
+	entry, err := output[0].Get([]byte("deleted"))
+	if err != nil || entry == nil || !entry.IsDeleted() {
+		t.Errorf("Expected tombstone to be retained, got %v (err %v)", entry, err)
+	}
+}
+
+func TestUniversalCompactionSelection(t *testing.T) {
+	cm := NewCompactionManager(UniversalCompaction)
+	cm.SetUniversalOptions(UniversalOptions{
+		SizeRatio:                   1,
+		MinMergeWidth:               2,
+		MaxMergeWidth:               10,
+		MaxSizeAmplificationPercent: 200,
+		FileNumCompactionTrigger:    3,
+	})
+
+	// Sorted runs are identified by their sequence ranges, listed here newest first
+	newRun := func(size, maxSequence uint64) *SSTable {
+		return &SSTable{metadata: &SSTableMetadata{Level: 0, FileSize: size, MaxSequence: maxSequence, CreatedAt: time.Now()}}
+	}
+
+	// Below the trigger
+	sstablesByLevel := map[int][]*SSTable{0: {newRun(100, 30), newRun(10000, 10)}}
+	if cm.ShouldCompact(sstablesByLevel) {
+		t.Error("Expected no compaction below the file number trigger")
+	}
+
+	// Size ratio: the two newest runs are similar in size, the oldest is much larger
+	sstablesByLevel[0] = append(sstablesByLevel[0], newRun(100, 40))
+	task := cm.SelectCompactionTask(sstablesByLevel)
+	if task == nil {
+		t.Fatal("Expected compaction task to be selected")
+	}
+	if len(task.InputSSTables) != 2 {
+		t.Fatalf("Expected 2 input runs, got %d", len(task.InputSSTables))
+	}
+	for _, table := range task.InputSSTables {
+		if table.metadata.FileSize != 100 {
+			t.Errorf("Unexpected run of size %d in size ratio compaction", table.metadata.FileSize)
+		}
+	}
+	if !task.RetainTombstones {
+		t.Error("Expected tombstones to be retained when the oldest run is not compacted")
+	}
+
+	// Space amplification: newer runs exceed 200% of the oldest run, so everything is merged
+	sstablesByLevel[0] = []*SSTable{newRun(150, 40), newRun(500, 30), newRun(100, 10)}
+	task = cm.SelectCompactionTask(sstablesByLevel)
+	if task == nil {
+		t.Fatal("Expected compaction task to be selected")
+	}
+	if len(task.InputSSTables) != 3 {
+		t.Errorf("Expected a full merge of 3 runs, got %d", len(task.InputSSTables))
+	}
+	if task.RetainTombstones {
+		t.Error("Expected tombstones to be dropped by a full merge")
+	}
+	if task.OutputLevel != 0 {
+		t.Errorf("Expected output level 0, got %d", task.OutputLevel)
+	}
+}
+
+func TestTimeWindowCompactionSelection(t *testing.T) {
+	cm := NewCompactionManager(TimeWindowCompaction)
+	cm.SetTimeWindowOptions(TimeWindowOptions{
+		WindowSize:   time.Hour,
+		MinThreshold: 3,
+		MaxThreshold: 10,
+	})
+
+	now := time.Now().Truncate(time.Hour)
+	newTable := func(minKey, maxKey string, writtenAt time.Time, minSequence, maxSequence uint64) *SSTable {
+		return &SSTable{metadata: &SSTableMetadata{
+			Level:        0,
+			MinKey:       []byte(minKey),
+			MaxKey:       []byte(maxKey),
+			MinSequence:  minSequence,
+			MaxSequence:  maxSequence,
+			MinTimestamp: writtenAt,
+			MaxTimestamp: writtenAt,
+			FileSize:     1024,
+			CreatedAt:    time.Now(),
+		}}
+	}
+
+	// Two tables in an old window and two in the newest window
+	old1 := newTable("a", "c", now.Add(-2*time.Hour+time.Minute), 1, 10)
+	old2 := newTable("a", "c", now.Add(-2*time.Hour+2*time.Minute), 11, 20)
+	new1 := newTable("a", "c", now.Add(time.Minute), 21, 30)
+	new2 := newTable("a", "c", now.Add(2*time.Minute), 31, 40)
+	sstablesByLevel := map[int][]*SSTable{0: {old1, old2, new1, new2}}
+
+	// The old window is compacted on its own; the newest window waits for MinThreshold tables
+	task := cm.SelectCompactionTask(sstablesByLevel)
+	if task == nil {
+		t.Fatal("Expected compaction task to be selected")
+	}
+	if len(task.InputSSTables) != 2 {
+		t.Fatalf("Expected 2 input tables, got %d", len(task.InputSSTables))
+	}
+	for _, table := range task.InputSSTables {
+		if table != old1 && table != old2 {
+			t.Error("Expected only tables of the old window to be compacted")
+		}
+	}
+	if task.RetainTombstones {
+		t.Error("Expected tombstones to be dropped when no older table overlaps")
+	}
+
+	sstablesByLevel[0] = []*SSTable{new1, new2}
+	if cm.ShouldCompact(sstablesByLevel) {
+		t.Error("Expected the newest window to wait for MinThreshold tables")
+	}
+}
+
+func TestTimeWindowCompactionDropsExpiredTables(t *testing.T) {
+	cm := NewCompactionManager(TimeWindowCompaction)
+
+	newTable := func(minKey, maxKey string, minSequence, maxSequence uint64, maxExpiresAt time.Time) *SSTable {
+		return &SSTable{metadata: &SSTableMetadata{
+			Level:        0,
+			MinKey:       []byte(minKey),
+			MaxKey:       []byte(maxKey),
+			MinSequence:  minSequence,
+			MaxSequence:  maxSequence,
+			MaxTimestamp: time.Now(),
+			MaxExpiresAt: maxExpiresAt,
+			FileSize:     1024,
+			CreatedAt:    time.Now(),
+		}}
+	}
+
+	expired := newTable("a", "c", 11, 20, time.Now().Add(-time.Minute))
+	live := newTable("x", "z", 21, 30, time.Now().Add(time.Hour))
+	noTTL := newTable("m", "n", 1, 10, time.Time{})
+	sstablesByLevel := map[int][]*SSTable{0: {expired, live, noTTL}}
+
+	task := cm.SelectCompactionTask(sstablesByLevel)
+	if task == nil {
+		t.Fatal("Expected a drop task for the expired table")
+	}
+	if task.CompactionType != DropCompaction {
+		t.Errorf("Expected DropCompaction, got %v", task.CompactionType)
+	}
+	if len(task.InputSSTables) != 1 || task.InputSSTables[0] != expired {
+		t.Fatalf("Expected only the expired table to be dropped, got %d tables", len(task.InputSSTables))
+	}
+
+	output, err := cm.ExecuteCompaction(task, os.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to execute drop: %v", err)
+	}
+	if len(output) != 0 {
+		t.Errorf("Expected dropped tables not to be rewritten, got %d outputs", len(output))
+	}
+
+	// An older table with overlapping keys would resurface if the expired table were dropped
+	older := newTable("b", "d", 1, 10, time.Time{})
+	sstablesByLevel[0] = []*SSTable{expired, older}
+	if task := cm.SelectCompactionTask(sstablesByLevel); task != nil && task.CompactionType == DropCompaction {
+		t.Error("Expected expired table shadowing older data not to be dropped")
+	}
+}
+
+func TestDynamicLevelTargets(t *testing.T) {
+	cm := NewCompactionManager(LeveledCompaction)
+	cm.SetDynamicLevelBytes(true)
+
+	const mb = 1024 * 1024
+	newTable := func(level int, size uint64, minKey, maxKey string) *SSTable {
+		return &SSTable{metadata: &SSTableMetadata{
+			Level:     level,
+			MinKey:    []byte(minKey),
+			MaxKey:    []byte(maxKey),
+			FileSize:  size,
+			CreatedAt: time.Now(),
+		}}
+	}
+
+	// A small database: level 0 compacts straight into the last level
+	sstablesByLevel := map[int][]*SSTable{}
+	targets, baseLevel := cm.DynamicLevelTargets(sstablesByLevel)
+	if baseLevel != 6 {
+		t.Errorf("Expected base level 6 for an empty database, got %d", baseLevel)
+	}
+	if targets[6] != 10*mb {
+		t.Errorf("Expected last level target of 10MB, got %d", targets[6])
+	}
+
+	// A 1GB last level: targets shrink by 10x upwards until they reach the 10MB base budget
+	sstablesByLevel[6] = []*SSTable{newTable(6, 1000*mb, "a", "z")}
+	targets, baseLevel = cm.DynamicLevelTargets(sstablesByLevel)
+	if baseLevel != 4 {
+		t.Errorf("Expected base level 4, got %d", baseLevel)
+	}
+	if targets[5] != 100*mb || targets[4] != 10*mb || targets[3] != 0 {
+		t.Errorf("Unexpected targets: %v", targets)
+	}
+
+	// Level 0 is compacted into the base level
+	for i := 0; i < 4; i++ {
+		sstablesByLevel[0] = append(sstablesByLevel[0], newTable(0, mb, "a", "b"))
+	}
+	task := cm.SelectCompactionTask(sstablesByLevel)
+	if task == nil {
+		t.Fatal("Expected compaction task to be selected")
+	}
+	if task.OutputLevel != 4 {
+		t.Errorf("Expected output level 4, got %d", task.OutputLevel)
+	}
+	if !task.RetainTombstones {
+		t.Error("Expected tombstones to be retained above the last level")
+	}
+
+	// The level with the highest size/target ratio wins over level 0
+	sstablesByLevel[5] = []*SSTable{newTable(5, 500*mb, "a", "z")} // score 5 vs level 0 score 1
+	task = cm.SelectCompactionTask(sstablesByLevel)
+	if task == nil {
+		t.Fatal("Expected compaction task to be selected")
+	}
+	if task.OutputLevel != 6 {
+		t.Errorf("Expected level 5 to be compacted into level 6, got output level %d", task.OutputLevel)
+	}
+	if task.RetainTombstones {
+		t.Error("Expected tombstones to be dropped when compacting into the last level")
+	}
+}
+
+func TestLeveledCompactionPicksHighestScore(t *testing.T) {
+	cm := NewCompactionManager(LeveledCompaction)
+
+	const mb = 1024 * 1024
+	newTable := func(level int, size uint64, minKey, maxKey string) *SSTable {
+		return &SSTable{metadata: &SSTableMetadata{
+			Level:     level,
+			MinKey:    []byte(minKey),
+			MaxKey:    []byte(maxKey),
+			FileSize:  size,
+			CreatedAt: time.Now(),
+		}}
+	}
+
+	// Level 1 is slightly over its 10MB target, level 2 is three times over its 100MB target
+	sstablesByLevel := map[int][]*SSTable{
+		1: {newTable(1, 11*mb, "a", "m")},
+		2: {newTable(2, 150*mb, "a", "m"), newTable(2, 150*mb, "n", "z")},
+	}
+
+	scores := cm.LevelScores(sstablesByLevel)
+	if scores[1] <= 1 || scores[2] != 3 {
+		t.Errorf("Unexpected scores: %v", scores)
+	}
+
+	task := cm.SelectCompactionTask(sstablesByLevel)
+	if task == nil {
+		t.Fatal("Expected compaction task to be selected")
+	}
+	if task.OutputLevel != 3 {
+		t.Errorf("Expected level 2 to be compacted into level 3, got output level %d", task.OutputLevel)
+	}
+}
+
+func TestLeveledCompactionFilePicking(t *testing.T) {
+	newTable := func(minKey, maxKey string, tombstones uint32) *SSTable {
+		return &SSTable{metadata: &SSTableMetadata{
+			Level:          1,
+			MinKey:         []byte(minKey),
+			MaxKey:         []byte(maxKey),
+			FileSize:       5 * 1024 * 1024,
+			TombstoneCount: tombstones,
+			CreatedAt:      time.Now(),
+		}}
+	}
+
+	tables := []*SSTable{newTable("g", "l", 5), newTable("a", "f", 0), newTable("m", "z", 1)}
+	sstablesByLevel := map[int][]*SSTable{1: tables}
+
+	// Round robin walks the key space and wraps around
+	cm := NewCompactionManager(LeveledCompaction)
+	expected := []string{"a", "g", "m", "a"}
+	for i, minKey := range expected {
+		task := cm.SelectCompactionTask(sstablesByLevel)
+		if task == nil {
+			t.Fatal("Expected compaction task to be selected")
+		}
+		if picked := string(task.InputSSTables[0].metadata.MinKey); picked != minKey {
+			t.Errorf("Pick %d: expected table starting at %s, got %s", i, minKey, picked)
+		}
+	}
+
+	// The pointer survives a restart of the manager
+	restored := NewCompactionManager(LeveledCompaction)
+	restored.SetCompactPointers(map[int][]byte{1: []byte("f")})
+	task := restored.SelectCompactionTask(sstablesByLevel)
+	if picked := string(task.InputSSTables[0].metadata.MinKey); picked != "g" {
+		t.Errorf("Expected restored pointer to pick table starting at g, got %s", picked)
+	}
+	if pointer := string(restored.CompactPointers()[1]); pointer != "l" {
+		t.Errorf("Expected pointer to advance to l, got %s", pointer)
+	}
+
+	// Most tombstones picks the table with the most deletes
+	cm = NewCompactionManager(LeveledCompaction)
+	cm.SetFilePickingPolicy(MostTombstonesPicking)
+	task = cm.SelectCompactionTask(sstablesByLevel)
+	if picked := task.InputSSTables[0]; picked.metadata.TombstoneCount != 5 {
+		t.Errorf("Expected table with 5 tombstones, got %d", picked.metadata.TombstoneCount)
+	}
+}
+
+func TestCompactionManagerRunsNonOverlappingTasks(t *testing.T) {
+	newTable := func(minKey, maxKey string) *SSTable {
+		return &SSTable{metadata: &SSTableMetadata{
+			Level:     1,
+			MinKey:    []byte(minKey),
+			MaxKey:    []byte(maxKey),
+			FileSize:  5 * 1024 * 1024,
+			CreatedAt: time.Now(),
+		}}
+	}
+
+	// Level 1 is 15MB against a 10MB target, so each of its tables is a candidate
+	sstablesByLevel := map[int][]*SSTable{
+		1: {newTable("a", "f"), newTable("g", "l"), newTable("m", "z")},
+	}
+	cm := NewCompactionManager(LeveledCompaction)
+
+	first := cm.SelectCompactionTask(sstablesByLevel)
+	if first == nil {
+		t.Fatal("Expected compaction task to be selected")
+	}
+	cm.StartCompaction(first)
+	if !first.InputSSTables[0].BeingCompacted() {
+		t.Error("Expected input table to be marked as being compacted")
+	}
+
+	second := cm.SelectCompactionTask(sstablesByLevel)
+	if second == nil {
+		t.Fatal("Expected a second, non-overlapping task to be selected")
+	}
+	if second.InputSSTables[0] == first.InputSSTables[0] {
+		t.Error("Expected the second task to use a different table")
+	}
+	cm.StartCompaction(second)
+	if cm.RunningCompactions() != 2 {
+		t.Errorf("Expected 2 running compactions, got %d", cm.RunningCompactions())
+	}
+
+	cm.FinishCompaction(first)
+	if first.InputSSTables[0].BeingCompacted() {
+		t.Error("Expected input table to be released")
+	}
+	if cm.RunningCompactions() != 1 {
+		t.Errorf("Expected 1 running compaction, got %d", cm.RunningCompactions())
+	}
+}
+
+func TestSubcompactions(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "subcompaction_test")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	// Two overlapping tables of 300 entries each span three index blocks apiece
+	buildTable := func(name, valuePrefix string) *SSTable {
+		builder := NewSSTableBuilder(0, 300)
+		for i := 0; i < 300; i++ {
+			key := []byte(fmt.Sprintf("key%04d", i*2))
+			builder.AddEntry(NewPutEntry(key, []byte(fmt.Sprintf("%s%d", valuePrefix, i))))
+		}
+		table, err := builder.Build(tmpDir, name)
+		if err != nil {
+			t.Fatalf("Failed to build %s: %v", name, err)
+		}
+		return table
+	}
+	older := buildTable("older.sst", "old")
+	time.Sleep(1 * time.Millisecond)
+	newer := buildTable("newer.sst", "new")
+
+	cm := NewCompactionManager(LeveledCompaction)
+	cm.SetMaxSubcompactions(3)
+	task := &CompactionTask{
+		InputSSTables:  []*SSTable{older, newer},
+		OutputLevel:    1,
+		CompactionType: MajorCompaction,
+	}
+
+	outputTables, err := cm.ExecuteCompaction(task, tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to execute compaction: %v", err)
+	}
+	if len(outputTables) != 3 {
+		t.Fatalf("Expected 3 output tables, got %d", len(outputTables))
+	}
+
+	// Outputs cover disjoint key ranges in order and keep the newest version of every key
+	total := 0
+	for i, table := range outputTables {
+		if i > 0 && bytes.Compare(outputTables[i-1].metadata.MaxKey, table.metadata.MinKey) >= 0 {
+			t.Errorf("Output %d overlaps the previous output", i)
+		}
+		entries, err := table.GetAllEntries()
+		if err != nil {
+			t.Fatalf("Failed to read output %d: %v", i, err)
+		}
+		for _, entry := range entries {
+			if !bytes.HasPrefix(entry.Value(), []byte("new")) {
+				t.Errorf("Expected newest value for %s, got %s", entry.Key(), entry.Value())
+			}
+		}
+		total += len(entries)
+	}
+	if total != 300 {
+		t.Errorf("Expected 300 entries, got %d", total)
+	}
+
+	// Level 0 outputs are never split
+	task.OutputLevel = 0
+	outputTables, err = cm.ExecuteCompaction(task, tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to execute compaction: %v", err)
+	}
+	if len(outputTables) != 1 {
+		t.Errorf("Expected 1 output table for level 0, got %d", len(outputTables))
+	}
+}
+
+func TestLeveledCompactionTrivialMove(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "trivial_move_test")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	builder := NewSSTableBuilder(1, 2)
+	builder.AddEntry(NewPutEntry([]byte("a"), []byte("1")))
+	builder.AddEntry(NewDeleteEntry([]byte("f")))
+	moved, err := builder.Build(tmpDir, "moved.sst")
+	if err != nil {
+		t.Fatalf("Failed to build SSTable: %v", err)
+	}
+	moved.metadata.FileSize = 11 * 1024 * 1024 // over the 10MB target of level 1
+	fileSize := moved.metadata.FileSize
+
+	newTable := func(level int, minKey, maxKey string) *SSTable {
+		return &SSTable{metadata: &SSTableMetadata{
+			Level:     level,
+			MinKey:    []byte(minKey),
+			MaxKey:    []byte(maxKey),
+			FileSize:  1024,
+			CreatedAt: time.Now(),
+		}}
+	}
+	sstablesByLevel := map[int][]*SSTable{
+		1: {moved},
+		2: {newTable(2, "m", "z")},
+	}
+
+	cm := NewCompactionManager(LeveledCompaction)
+	task := cm.SelectCompactionTask(sstablesByLevel)
+	if task == nil {
+		t.Fatal("Expected compaction task to be selected")
+	}
+	if task.CompactionType != TrivialMove || task.OutputLevel != 2 || len(task.InputSSTables) != 1 {
+		t.Fatalf("Expected trivial move of one table into level 2, got type %d into level %d with %d inputs",
+			task.CompactionType, task.OutputLevel, len(task.InputSSTables))
+	}
+
+	// Nothing is read or written
+	outputTables, err := cm.ExecuteCompaction(task, tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to execute compaction: %v", err)
+	}
+	if len(outputTables) != 0 {
+		t.Errorf("Expected no output tables, got %d", len(outputTables))
+	}
+
+	edit := NewCompactionEdit(task, outputTables)
+	if len(edit.DeletedTables) != 0 || len(edit.MovedTables) != 1 || edit.MoveLevel != 2 {
+		t.Errorf("Unexpected version edit: %+v", edit)
+	}
+	moved.MoveToLevel(edit.MoveLevel)
+	if moved.metadata.Level != 2 || moved.metadata.FileSize != fileSize {
+		t.Errorf("Expected table metadata to change level only, got level %d", moved.metadata.Level)
+	}
+	if entry, err := moved.Get([]byte("f")); err != nil || !entry.IsDeleted() {
+		t.Errorf("Expected moved table to keep its tombstone, got %v", err)
+	}
+
+	// A table overlapping the output level is merged as usual
+	sstablesByLevel = map[int][]*SSTable{
+		1: {newTable(1, "a", "n")},
+		2: {newTable(2, "m", "z")},
+	}
+	sstablesByLevel[1][0].metadata.FileSize = 11 * 1024 * 1024
+	task = NewCompactionManager(LeveledCompaction).SelectCompactionTask(sstablesByLevel)
+	if task == nil || task.CompactionType != MajorCompaction || len(task.InputSSTables) != 2 {
+		t.Errorf("Expected major compaction of overlapping tables, got %+v", task)
+	}
+}
+
+func TestManualCompactionTasks(t *testing.T) {
+	newTable := func(level int, minKey, maxKey string) *SSTable {
+		return &SSTable{metadata: &SSTableMetadata{
+			Level:     level,
+			MinKey:    []byte(minKey),
+			MaxKey:    []byte(maxKey),
+			FileSize:  1024,
+			CreatedAt: time.Now(),
+		}}
+	}
+	sstablesByLevel := map[int][]*SSTable{
+		0: {newTable(0, "b", "c")},
+		1: {newTable(1, "a", "b"), newTable(1, "x", "z")},
+		2: {newTable(2, "a", "z")},
+	}
+	cm := NewCompactionManager(LeveledCompaction)
+
+	// A running compaction of a table in range makes the manual compaction wait
+	running := &CompactionTask{InputSSTables: []*SSTable{sstablesByLevel[1][0]}, OutputLevel: 2}
+	cm.StartCompaction(running)
+	mc := NewManualCompaction([]byte("a"), []byte("c"))
+	if task, busy := cm.NextManualTask(mc, sstablesByLevel); task != nil || !busy {
+		t.Fatalf("Expected manual compaction to wait for running compaction, got %+v", task)
+	}
+	cm.FinishCompaction(running)
+
+	// Level 0 moves into level 1 together with the overlapping table; x-z is out of range
+	task, busy := cm.NextManualTask(mc, sstablesByLevel)
+	if task == nil || busy {
+		t.Fatal("Expected first manual task")
+	}
+	if task.OutputLevel != 1 || len(task.InputSSTables) != 2 || task.CompactionType != MajorCompaction {
+		t.Errorf("Unexpected first task: output level %d, %d inputs", task.OutputLevel, len(task.InputSSTables))
+	}
+	mc.Complete(task)
+
+	// Level 1 then moves into the bottom level, which ends the compaction
+	task, _ = cm.NextManualTask(mc, sstablesByLevel)
+	if task == nil || task.OutputLevel != 2 || len(task.InputSSTables) != 2 || task.RetainTombstones {
+		t.Fatalf("Unexpected second task: %+v", task)
+	}
+	mc.Complete(task)
+
+	if task, _ = cm.NextManualTask(mc, sstablesByLevel); task != nil {
+		t.Errorf("Expected manual compaction to be done, got %+v", task)
+	}
+	progress := mc.Progress()
+	if !progress.Done || progress.TasksCompleted != 2 || progress.BytesCompacted != 4*1024 {
+		t.Errorf("Unexpected progress: %+v", progress)
+	}
+}
+
+func TestUniversalManualCompactionMergesAdjacentRuns(t *testing.T) {
+	newRun := func(minKey, maxKey string, sequence uint64) *SSTable {
+		return &SSTable{metadata: &SSTableMetadata{
+			MinKey:      []byte(minKey),
+			MaxKey:      []byte(maxKey),
+			MinSequence: sequence,
+			MaxSequence: sequence,
+			FileSize:    1024,
+			CreatedAt:   time.Now(),
+		}}
+	}
+	// Newest to oldest: only the newest and the third run hold keys in a-c
+	newest, middle, older, oldest := newRun("a", "b", 4), newRun("x", "z", 3), newRun("b", "c", 2), newRun("m", "n", 1)
+	sstablesByLevel := map[int][]*SSTable{0: {oldest, older, middle, newest}}
+	cm := NewCompactionManager(UniversalCompaction)
+
+	// The run between them is merged too, so the output takes their place in age order
+	task, busy := cm.NextManualTask(NewManualCompaction([]byte("a"), []byte("c")), sstablesByLevel)
+	if task == nil || busy {
+		t.Fatal("Expected a manual task")
+	}
+	if len(task.InputSSTables) != 3 || task.InputSSTables[0] != newest || task.InputSSTables[1] != middle || task.InputSSTables[2] != older {
+		t.Errorf("Expected the three newest runs, got %d inputs", len(task.InputSSTables))
+	}
+	if !task.RetainTombstones {
+		t.Error("Expected tombstones to be retained above the oldest run")
+	}
+}
+
+func TestPendingCompactionBytes(t *testing.T) {
+	const mb = 1024 * 1024
+	newTable := func(level int, size uint64) *SSTable {
+		return &SSTable{metadata: &SSTableMetadata{
+			Level:     level,
+			MinKey:    []byte("a"),
+			MaxKey:    []byte("z"),
+			FileSize:  size,
+			CreatedAt: time.Now(),
+		}}
+	}
+
+	cm := NewCompactionManager(LeveledCompaction)
+	sstablesByLevel := map[int][]*SSTable{
+		0: {newTable(0, mb), newTable(0, mb), newTable(0, mb)},
+		1: {newTable(1, 15*mb)},
+	}
+	// Level 0 is below its trigger; level 1 is 5MB over its 10MB target
+	if pending := cm.PendingCompactionBytes(sstablesByLevel); pending != 5*mb {
+		t.Errorf("Expected 5MB pending, got %d", pending)
+	}
+
+	sstablesByLevel[0] = append(sstablesByLevel[0], newTable(0, mb))
+	if pending := cm.PendingCompactionBytes(sstablesByLevel); pending != 9*mb {
+		t.Errorf("Expected 9MB pending, got %d", pending)
+	}
+
+	// Tiered strategies report the size of their next task
+	universal := NewCompactionManager(UniversalCompaction)
+	if pending := universal.PendingCompactionBytes(map[int][]*SSTable{0: {newTable(0, mb)}}); pending != 0 {
+		t.Errorf("Expected nothing pending for a single run, got %d", pending)
+	}
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"bytes"
+	"fmt"
 	"time"
 )
 
@@ -11,6 +12,7 @@ type EntryType uint8
 const (
 	EntryTypePut EntryType = iota
 	EntryTypeDelete
+	EntryTypeMerge
 )
 
 // Entry represents a key-value entry in the LSM-tree
@@ -20,6 +22,10 @@ type Entry struct {
 	value     []byte
 	entryType EntryType
 	timestamp time.Time
+	expiresAt time.Time // zero value means the entry never expires
+	seq       uint64    // sequence number assigned on write; zero if never written through the service
+	familyID  uint32    // column family the entry belongs to (only persisted in the WAL)
+	older     *Entry    // in a memtable: the version of the key this entry replaced, if any
 }
 
 // NewPutEntry creates a new PUT entry
@@ -32,6 +38,13 @@ func NewPutEntry(key, value []byte) *Entry {
 	}
 }
 
+// NewPutEntryWithTTL creates a new PUT entry that expires after the given TTL
+func NewPutEntryWithTTL(key, value []byte, ttl time.Duration) *Entry {
+	entry := NewPutEntry(key, value)
+	entry.expiresAt = entry.timestamp.Add(ttl)
+	return entry
+}
+
 // NewDeleteEntry creates a new DELETE entry (tombstone)
 func NewDeleteEntry(key []byte) *Entry {
 	return &Entry{
@@ -42,6 +55,23 @@ func NewDeleteEntry(key []byte) *Entry {
 	}
 }
 
+// NewMergeEntry creates a new MERGE entry carrying a single merge operand
+func NewMergeEntry(key, operand []byte) *Entry {
+	return &Entry{
+		key:       key,
+		value:     encodeOperands([][]byte{operand}),
+		entryType: EntryTypeMerge,
+		timestamp: time.Now(),
+	}
+}
+
+// copyTo copies the entry into dst, allocating its key and value from the arena
+func (e *Entry) copyTo(dst *Entry, arena *Arena) {
+	*dst = *e
+	dst.key = arena.Copy(e.key)
+	dst.value = arena.Copy(e.value)
+}
+
 // Key returns the key of the entry
 func (e *Entry) Key() []byte {
 	return e.key
@@ -62,11 +92,64 @@ func (e *Entry) Timestamp() time.Time {
 	return e.timestamp
 }
 
+// Sequence returns the sequence number of the entry
+func (e *Entry) Sequence() uint64 {
+	return e.seq
+}
+
+// SetSequence assigns the sequence number of the entry
+func (e *Entry) SetSequence(seq uint64) {
+	e.seq = seq
+}
+
+// ColumnFamilyID returns the ID of the column family the entry is written to
+func (e *Entry) ColumnFamilyID() uint32 {
+	return e.familyID
+}
+
+// SetColumnFamilyID assigns the column family the entry is written to
+func (e *Entry) SetColumnFamilyID(id uint32) {
+	e.familyID = id
+}
+
+// ExpiresAt returns the expiry time of the entry (zero if it never expires)
+func (e *Entry) ExpiresAt() time.Time {
+	return e.expiresAt
+}
+
+// HasTTL returns true if the entry has an expiry time
+func (e *Entry) HasTTL() bool {
+	return !e.expiresAt.IsZero()
+}
+
+// IsExpired returns true if the entry has an expiry time that has already passed
+func (e *Entry) IsExpired() bool {
+	return e.IsExpiredAt(time.Now())
+}
+
+// IsExpiredAt returns true if the entry is expired at the given point in time
+func (e *Entry) IsExpiredAt(now time.Time) bool {
+	return e.HasTTL() && !now.Before(e.expiresAt)
+}
+
 // IsDeleted returns true if this entry is a delete marker
 func (e *Entry) IsDeleted() bool {
 	return e.entryType == EntryTypeDelete
 }
 
+// IsMerge returns true if this entry holds merge operands instead of a value
+func (e *Entry) IsMerge() bool {
+	return e.entryType == EntryTypeMerge
+}
+
+// Operands returns the merge operands of a MERGE entry (oldest first)
+func (e *Entry) Operands() ([][]byte, error) {
+	if !e.IsMerge() {
+		return nil, fmt.Errorf("entry is not a merge entry")
+	}
+	return decodeOperands(e.value)
+}
+
 // Compare compares this entry with another entry by key
 // Returns -1 if this entry's key is less than other's key,
 // 0 if they are equal, and 1 if this entry's key is greater
@@ -75,6 +158,69 @@ func (e *Entry) Compare(other *Entry) int {
 }
 
 // IsNewerThan returns true if this entry is newer than the other entry
+// Sequence numbers are used when both entries have one, falling back to timestamps
 func (e *Entry) IsNewerThan(other *Entry) bool {
+	if e.seq != 0 && other.seq != 0 {
+		return e.seq > other.seq
+	}
 	return e.timestamp.After(other.timestamp)
 }
+
+// expiryToUnixNano encodes an expiry time for on-disk formats (0 means no expiry)
+func expiryToUnixNano(expiresAt time.Time) int64 {
+	if expiresAt.IsZero() {
+		return 0
+	}
+	return expiresAt.UnixNano()
+}
+
+// expiryFromUnixNano decodes an expiry time written by expiryToUnixNano
+func expiryFromUnixNano(nano int64) time.Time {
+	if nano == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nano)
+}
+
+// stackMergeEntries combines an older MERGE entry with a newer one into a single MERGE entry
+func stackMergeEntries(older, newer *Entry) (*Entry, error) {
+	olderOperands, err := older.Operands()
+	if err != nil {
+		return nil, err
+	}
+	newerOperands, err := newer.Operands()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Entry{
+		key:       newer.key,
+		value:     encodeOperands(append(olderOperands, newerOperands...)),
+		entryType: EntryTypeMerge,
+		timestamp: newer.timestamp,
+		seq:       newer.seq,
+	}, nil
+}
+
+// newResolvedTombstone creates a tombstone for merge operands up to newest that resolved to no value
+func newResolvedTombstone(newest *Entry) *Entry {
+	return &Entry{
+		key:       newest.key,
+		entryType: EntryTypeDelete,
+		timestamp: newest.timestamp,
+		seq:       newest.seq,
+	}
+}
+
+// newResolvedEntry creates a PUT entry holding the result of applying merge operands up to newest;
+// it expires when the base value the operands were applied to does
+func newResolvedEntry(newest *Entry, value []byte, expiresAt time.Time) *Entry {
+	return &Entry{
+		key:       newest.key,
+		value:     value,
+		entryType: EntryTypePut,
+		timestamp: newest.timestamp,
+		expiresAt: expiresAt,
+		seq:       newest.seq,
+	}
+}
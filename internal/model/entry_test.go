@@ -83,3 +83,47 @@ func TestEntryIsNewerThan(t *testing.T) {
 		t.Error("Expected entry1 to not be newer than entry2")
 	}
 }
+
+func TestNewPutEntryWithTTL(t *testing.T) {
+	entry := NewPutEntryWithTTL([]byte("key"), []byte("value"), time.Minute)
+
+	if !entry.HasTTL() {
+		t.Fatal("Expected entry to have a TTL")
+	}
+
+	if !entry.ExpiresAt().Equal(entry.Timestamp().Add(time.Minute)) {
+		t.Errorf("Expected expiry %v, got %v", entry.Timestamp().Add(time.Minute), entry.ExpiresAt())
+	}
+
+	if entry.IsExpired() {
+		t.Error("Expected entry to not be expired yet")
+	}
+
+	if !entry.IsExpiredAt(entry.Timestamp().Add(2 * time.Minute)) {
+		t.Error("Expected entry to be expired after its TTL")
+	}
+
+	// Entries without a TTL never expire
+	plain := NewPutEntry([]byte("key"), []byte("value"))
+	if plain.HasTTL() || plain.IsExpiredAt(time.Now().Add(24*time.Hour)) {
+		t.Error("Expected entry without TTL to never expire")
+	}
+}
+
+func TestEntryIsNewerThanSequence(t *testing.T) {
+	// Sequence numbers take precedence over timestamps
+	entry1 := NewPutEntry([]byte("key"), []byte("value1"))
+	time.Sleep(1 * time.Millisecond)
+	entry2 := NewPutEntry([]byte("key"), []byte("value2"))
+
+	entry1.SetSequence(2)
+	entry2.SetSequence(1)
+
+	if !entry1.IsNewerThan(entry2) {
+		t.Error("Expected entry with higher sequence number to be newer")
+	}
+
+	if entry2.IsNewerThan(entry1) {
+		t.Error("Expected entry with lower sequence number to not be newer")
+	}
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// LockFileName is the name of the lock file inside the data directory
+const LockFileName = "LOCK"
+
+// ErrDirectoryLocked is returned when another process already uses the data directory
+var ErrDirectoryLocked = errors.New("data directory is locked by another process")
+
+// DirectoryLock keeps other processes from opening a data directory while one is using it
+// The lock is held on the open LOCK file, so the operating system drops it when the process exits, even after a crash
+type DirectoryLock struct {
+	file *os.File
+}
+
+// LockDirectory creates the directory if needed and takes its lock; returns ErrDirectoryLocked if it is held
+func LockDirectory(dir string) (*DirectoryLock, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, LockFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &DirectoryLock{file: file}, nil
+}
+
+// Release gives up the lock; the LOCK file itself stays for the next process
+func (l *DirectoryLock) Release() error {
+	if err := unlockFile(l.file); err != nil {
+		l.file.Close()
+		return fmt.Errorf("failed to unlock data directory: %w", err)
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package model
+
+import "os"
+
+// lockFile is a no-op where flock is not available; the data directory is then not protected
+func lockFile(file *os.File) error {
+	return nil
+}
+
+// unlockFile is a no-op where flock is not available
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockDirectory(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lock_test")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	lock, err := LockDirectory(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to lock directory: %v", err)
+	}
+
+	// A second holder is turned away, even within the same process
+	if _, err := LockDirectory(tmpDir); err != ErrDirectoryLocked {
+		t.Errorf("Expected ErrDirectoryLocked, got %v", err)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Failed to release lock: %v", err)
+	}
+	lock, err = LockDirectory(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to lock released directory: %v", err)
+	}
+	lock.Release()
+}
@@ -0,0 +1,164 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ManifestFileName is the name of the manifest file inside the data directory
+const ManifestFileName = "MANIFEST"
+
+// TempFileSuffix marks a file still being written; it is renamed to its final name once complete
+const TempFileSuffix = ".tmp"
+
+// ColumnFamilyDescriptor describes a column family recorded in the manifest
+type ColumnFamilyDescriptor struct {
+	ID                 uint32 `json:"id"`
+	Name               string `json:"name"`
+	MaxTableSize       int    `json:"max_table_size"`
+	WriteBufferSize    int    `json:"write_buffer_size,omitempty"`
+	CompactionStrategy string `json:"compaction_strategy"`
+	DynamicLevelBytes  bool   `json:"dynamic_level_bytes,omitempty"`
+	FilePicking        string `json:"file_picking,omitempty"`
+}
+
+// SSTableDescriptor describes a live SSTable recorded in the manifest
+type SSTableDescriptor struct {
+	ColumnFamilyID uint32 `json:"column_family_id"`
+	Level          int    `json:"level"`
+	FileName       string `json:"file_name"`
+}
+
+// Manifest records the persistent layout of the store
+// It is rewritten as a whole on every change
+type Manifest struct {
+	path               string
+	NextColumnFamilyID uint32                   `json:"next_column_family_id"`
+	ColumnFamilies     []ColumnFamilyDescriptor `json:"column_families"`
+	// CompactPointers holds the round-robin compaction pointer of each level, per column family ID
+	CompactPointers map[uint32]map[int][]byte `json:"compact_pointers,omitempty"`
+	// SSTables lists the live tables of all column families; files not listed are not part of the store
+	SSTables []SSTableDescriptor `json:"sstables,omitempty"`
+	// LogNumber is the oldest WAL file that may hold entries missing from the SSTables; older ones are obsolete
+	LogNumber int `json:"log_number,omitempty"`
+	// NextSSTableNumber numbers the next flushed SSTable, so a restarted store does not reuse a live table's name
+	NextSSTableNumber int `json:"next_sstable_number,omitempty"`
+	// FlushedSequences holds the newest sequence number flushed to an SSTable, per column family ID;
+	// a WAL file still needed for newer entries may also hold these, which recovery must not apply twice
+	FlushedSequences map[uint32]uint64 `json:"flushed_sequences,omitempty"`
+}
+
+// LoadManifest reads the manifest from the data directory, returning an empty manifest if none exists
+func LoadManifest(dir string) (*Manifest, error) {
+	path := filepath.Join(dir, ManifestFileName)
+	manifest := &Manifest{
+		path:               path,
+		NextColumnFamilyID: 1, // ID 0 is reserved for the default column family
+		ColumnFamilies:     make([]ColumnFamilyDescriptor, 0),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return manifest, nil
+		}
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// AddColumnFamily assigns the next ID to a new column family and records it
+func (m *Manifest) AddColumnFamily(descriptor ColumnFamilyDescriptor) ColumnFamilyDescriptor {
+	descriptor.ID = m.NextColumnFamilyID
+	m.NextColumnFamilyID++
+	m.ColumnFamilies = append(m.ColumnFamilies, descriptor)
+	return descriptor
+}
+
+// SetCompactPointers records the round-robin compaction pointers of a column family
+func (m *Manifest) SetCompactPointers(familyID uint32, pointers map[int][]byte) {
+	if m.CompactPointers == nil {
+		m.CompactPointers = make(map[uint32]map[int][]byte)
+	}
+	m.CompactPointers[familyID] = pointers
+}
+
+// SetFlushedSequence records the newest sequence number of a column family that is in its SSTables
+func (m *Manifest) SetFlushedSequence(familyID uint32, sequence uint64) {
+	if m.FlushedSequences == nil {
+		m.FlushedSequences = make(map[uint32]uint64)
+	}
+	m.FlushedSequences[familyID] = sequence
+}
+
+// SetSSTables records the live tables of a column family, replacing the ones recorded before
+func (m *Manifest) SetSSTables(familyID uint32, levels map[int][]*SSTable) {
+	tables := make([]SSTableDescriptor, 0, len(m.SSTables))
+	for _, table := range m.SSTables {
+		if table.ColumnFamilyID != familyID {
+			tables = append(tables, table)
+		}
+	}
+
+	levelNumbers := make([]int, 0, len(levels))
+	for level := range levels {
+		levelNumbers = append(levelNumbers, level)
+	}
+	sort.Ints(levelNumbers)
+	for _, level := range levelNumbers {
+		for _, table := range levels[level] {
+			tables = append(tables, SSTableDescriptor{
+				ColumnFamilyID: familyID,
+				Level:          level,
+				FileName:       table.Metadata().FileName,
+			})
+		}
+	}
+	m.SSTables = tables
+}
+
+// Save atomically replaces the manifest file on disk
+func (m *Manifest) Save() error {
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a half-written manifest
+	tmpPath := m.path + TempFileSuffix
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create manifest file: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync manifest: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close manifest: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, m.path); err != nil {
+		return fmt.Errorf("failed to install manifest: %w", err)
+	}
+	if err := syncDir(filepath.Dir(m.path)); err != nil {
+		return fmt.Errorf("failed to sync manifest directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestSaveAndLoad(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "test_manifest")
+	defer os.RemoveAll(tmpDir)
+
+	manifest, err := LoadManifest(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to load empty manifest: %v", err)
+	}
+	if len(manifest.ColumnFamilies) != 0 {
+		t.Fatalf("Expected no column families, got %d", len(manifest.ColumnFamilies))
+	}
+
+	users := manifest.AddColumnFamily(ColumnFamilyDescriptor{Name: "users", MaxTableSize: 100, CompactionStrategy: LeveledCompaction.String(), DynamicLevelBytes: true})
+	events := manifest.AddColumnFamily(ColumnFamilyDescriptor{Name: "events", MaxTableSize: 50, CompactionStrategy: SizeTieredCompaction.String()})
+	if users.ID == 0 || events.ID == users.ID {
+		t.Errorf("Expected distinct non-zero IDs, got %d and %d", users.ID, events.ID)
+	}
+
+	manifest.SetCompactPointers(users.ID, map[int][]byte{1: []byte("user:42")})
+	manifest.SetFlushedSequence(users.ID, 42)
+
+	if err := manifest.Save(); err != nil {
+		t.Fatalf("Failed to save manifest: %v", err)
+	}
+
+	loaded, err := LoadManifest(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if len(loaded.ColumnFamilies) != 2 {
+		t.Fatalf("Expected 2 column families, got %d", len(loaded.ColumnFamilies))
+	}
+	if loaded.ColumnFamilies[0] != users {
+		t.Errorf("Expected %+v, got %+v", users, loaded.ColumnFamilies[0])
+	}
+	if loaded.ColumnFamilies[1] != events {
+		t.Errorf("Expected %+v, got %+v", events, loaded.ColumnFamilies[1])
+	}
+
+	if pointer := string(loaded.CompactPointers[users.ID][1]); pointer != "user:42" {
+		t.Errorf("Expected compact pointer user:42, got %q", pointer)
+	}
+
+	if sequence := loaded.FlushedSequences[users.ID]; sequence != 42 {
+		t.Errorf("Expected flushed sequence 42, got %d", sequence)
+	}
+
+	strategy, err := ParseCompactionStrategy(loaded.ColumnFamilies[1].CompactionStrategy)
+	if err != nil || strategy != SizeTieredCompaction {
+		t.Errorf("Expected size tiered strategy, got %v (err %v)", strategy, err)
+	}
+
+	// IDs are never reused
+	next := loaded.AddColumnFamily(ColumnFamilyDescriptor{Name: "metrics", MaxTableSize: 10, CompactionStrategy: LeveledCompaction.String()})
+	if next.ID <= events.ID {
+		t.Errorf("Expected ID greater than %d, got %d", events.ID, next.ID)
+	}
+}
+
+func TestManifestSSTables(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "test_manifest_sstables")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	build := func(level int, filename string) *SSTable {
+		builder := NewSSTableBuilder(level, 1)
+		builder.AddEntry(NewPutEntry([]byte(filename), []byte("value")))
+		sst, err := builder.Build(tmpDir, filename)
+		if err != nil {
+			t.Fatalf("Failed to build SSTable: %v", err)
+		}
+		return sst
+	}
+
+	manifest, err := LoadManifest(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to load empty manifest: %v", err)
+	}
+	manifest.SetSSTables(0, map[int][]*SSTable{1: {build(1, "b.sst")}, 0: {build(0, "a.sst")}})
+	manifest.SetSSTables(1, map[int][]*SSTable{0: {build(0, "c.sst")}})
+	// Recording a family again replaces only its own tables
+	manifest.SetSSTables(0, map[int][]*SSTable{1: {build(1, "d.sst")}})
+	manifest.LogNumber = 3
+	manifest.NextSSTableNumber = 7
+	if err := manifest.Save(); err != nil {
+		t.Fatalf("Failed to save manifest: %v", err)
+	}
+
+	loaded, err := LoadManifest(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	expected := []SSTableDescriptor{
+		{ColumnFamilyID: 1, Level: 0, FileName: "c.sst"},
+		{ColumnFamilyID: 0, Level: 1, FileName: "d.sst"},
+	}
+	if len(loaded.SSTables) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, loaded.SSTables)
+	}
+	for i := range expected {
+		if loaded.SSTables[i] != expected[i] {
+			t.Errorf("Table %d: expected %+v, got %+v", i, expected[i], loaded.SSTables[i])
+		}
+	}
+	if loaded.LogNumber != 3 || loaded.NextSSTableNumber != 7 {
+		t.Errorf("Expected log number 3 and next SSTable number 7, got %d and %d", loaded.LogNumber, loaded.NextSSTableNumber)
+	}
+}
@@ -0,0 +1,223 @@
+package model
+
+import (
+	"bytes"
+	"time"
+)
+
+// ManualCompaction compacts the tables overlapping a key range level by level down to the bottom level
+// Its tasks are produced one at a time by CompactionManager.NextManualTask
+type ManualCompaction struct {
+	start          []byte // nil means unbounded
+	end            []byte // nil means unbounded; inclusive
+	level          int    // next level to compact
+	done           bool
+	tasksCompleted int
+	bytesCompacted uint64
+	startedAt      time.Time
+	finishedAt     time.Time
+	err            error
+}
+
+// ManualCompactionProgress is a snapshot of a manual compaction's state
+type ManualCompactionProgress struct {
+	Start          []byte
+	End            []byte
+	Level          int  // level currently being compacted
+	Done           bool // all tasks finished, or the compaction failed
+	TasksCompleted int
+	BytesCompacted uint64
+	StartedAt      time.Time
+	FinishedAt     time.Time
+	Err            error
+}
+
+// NewManualCompaction creates a manual compaction of the keys in [start, end]; nil bounds are unbounded
+func NewManualCompaction(start, end []byte) *ManualCompaction {
+	return &ManualCompaction{
+		start:     start,
+		end:       end,
+		startedAt: time.Now(),
+	}
+}
+
+// Complete records a finished task of the compaction
+func (mc *ManualCompaction) Complete(task *CompactionTask) {
+	mc.tasksCompleted++
+	mc.bytesCompacted += task.EstimatedSize
+	if mc.done {
+		mc.finishedAt = time.Now()
+	}
+}
+
+// Fail stops the compaction with an error
+func (mc *ManualCompaction) Fail(err error) {
+	mc.done = true
+	mc.err = err
+	mc.finishedAt = time.Now()
+}
+
+// Progress returns a snapshot of the compaction's state
+func (mc *ManualCompaction) Progress() ManualCompactionProgress {
+	return ManualCompactionProgress{
+		Start:          mc.start,
+		End:            mc.end,
+		Level:          mc.level,
+		Done:           !mc.finishedAt.IsZero(),
+		TasksCompleted: mc.tasksCompleted,
+		BytesCompacted: mc.bytesCompacted,
+		StartedAt:      mc.startedAt,
+		FinishedAt:     mc.finishedAt,
+		Err:            mc.err,
+	}
+}
+
+// overlaps reports whether a table holds keys in the compaction's range
+func (mc *ManualCompaction) overlaps(table *SSTable) bool {
+	if mc.start != nil && bytes.Compare(table.metadata.MaxKey, mc.start) < 0 {
+		return false
+	}
+	if mc.end != nil && bytes.Compare(table.metadata.MinKey, mc.end) > 0 {
+		return false
+	}
+	return true
+}
+
+// NextManualTask returns the next task of a manual compaction, or nil once it is done
+// busy is true if the next task would conflict with a running compaction; the caller retries after
+// running compactions finish. Inputs are always rewritten so tombstones are purged where possible
+func (cm *CompactionManager) NextManualTask(mc *ManualCompaction, sstablesByLevel map[int][]*SSTable) (task *CompactionTask, busy bool) {
+	for !mc.done {
+		bottom := deepestLevel(sstablesByLevel)
+		if mc.level > bottom {
+			mc.done = true
+			break
+		}
+
+		var outputLevel int
+		inputs := make([]*SSTable, 0)
+		if cm.strategy == LeveledCompaction {
+			_, baseLevel := cm.LevelTargets(sstablesByLevel)
+			if mc.level < baseLevel {
+				// Tables above the base level may overlap each other, so they are compacted as a whole
+				inputs = append(inputs, sstablesByLevel[mc.level]...)
+			} else {
+				inputs = append(inputs, mc.tablesInRange(sstablesByLevel[mc.level])...)
+			}
+			if len(inputs) == 0 {
+				mc.level++
+				continue
+			}
+
+			switch {
+			case mc.level < baseLevel:
+				outputLevel = baseLevel
+			case mc.level == bottom:
+				outputLevel = mc.level // the bottom level is rewritten in place to drop tombstones
+			default:
+				outputLevel = mc.level + 1
+			}
+			if outputLevel != mc.level {
+				inputs = appendMissing(inputs, cm.findOverlappingTables(inputs, sstablesByLevel[outputLevel]))
+				inputs = appendMissing(inputs, mc.tablesInRange(sstablesByLevel[outputLevel]))
+			}
+		} else {
+			// Tiered strategies keep all tables in level 0 as sorted runs; the range is merged into one run
+			if cm.strategy == UniversalCompaction {
+				inputs = append(inputs, mc.adjacentRunsInRange(sstablesByLevel)...)
+			} else {
+				inputs = append(inputs, mc.tablesInRange(sstablesByLevel[0])...)
+			}
+			if len(inputs) == 0 {
+				mc.done = true
+				break
+			}
+			outputLevel = 0
+		}
+
+		task = &CompactionTask{
+			InputSSTables:    inputs,
+			OutputLevel:      outputLevel,
+			CompactionType:   MajorCompaction,
+			EstimatedSize:    cm.calculateTotalSize(inputs),
+			RetainTombstones: cm.overlapsDeeperLevels(inputs, sstablesByLevel, outputLevel),
+		}
+		if outputLevel == 0 {
+			// Level 0 tables left out may hold older versions of the inputs' keys
+			task.RetainTombstones = len(inputs) < countTables(sstablesByLevel)
+		}
+		if cm.conflictsWithRunning(task) {
+			return nil, true
+		}
+
+		// Nothing below the output level is left to compact
+		if outputLevel == 0 || outputLevel == mc.level || outputLevel >= bottom {
+			mc.done = true
+		}
+		mc.level = outputLevel
+		return task, false
+	}
+
+	if mc.finishedAt.IsZero() {
+		mc.finishedAt = time.Now()
+	}
+	return nil, false
+}
+
+// tablesInRange returns the tables holding keys in the compaction's range
+func (mc *ManualCompaction) tablesInRange(tables []*SSTable) []*SSTable {
+	inRange := make([]*SSTable, 0)
+	for _, table := range tables {
+		if mc.overlaps(table) {
+			inRange = append(inRange, table)
+		}
+	}
+	return inRange
+}
+
+// adjacentRunsInRange returns the sorted runs from the newest to the oldest one holding keys in the range,
+// with the runs between them, so the merged run stays adjacent in age to its neighbours
+func (mc *ManualCompaction) adjacentRunsInRange(sstablesByLevel map[int][]*SSTable) []*SSTable {
+	runs := sortedRunsByAge(sstablesByLevel)
+	first, last := -1, -1
+	for i, run := range runs {
+		if mc.overlaps(run) {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return nil
+	}
+	return runs[first : last+1]
+}
+
+// deepestLevel returns the deepest level holding any table, or -1 if there are no tables
+func deepestLevel(sstablesByLevel map[int][]*SSTable) int {
+	deepest := -1
+	for level, tables := range sstablesByLevel {
+		if len(tables) > 0 && level > deepest {
+			deepest = level
+		}
+	}
+	return deepest
+}
+
+// appendMissing appends the tables not already in the slice
+func appendMissing(tables, more []*SSTable) []*SSTable {
+	for _, table := range more {
+		found := false
+		for _, existing := range tables {
+			if existing == table {
+				found = true
+				break
+			}
+		}
+		if !found {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"errors"
 	"sync"
+	"time"
 )
 
 var (
@@ -10,14 +11,38 @@ var (
 	ErrTableFull   = errors.New("memtable is full")
 )
 
+// memTableEntryOverhead approximates the memory an entry costs beyond its key and value:
+// the Entry itself and its map slot, including the key's copy as map key
+const memTableEntryOverhead = 128
+
+// Entries are allocated in slabs that grow from minEntrySlabSize to maxEntrySlabSize entries,
+// so small memtables stay small and large ones cost one allocation per slab instead of one per write
+const (
+	minEntrySlabSize = 16
+	maxEntrySlabSize = 1024
+)
+
 // MemTable represents an in-memory table that stores entries
 // This is an aggregate root in DDD terms
+// It is full once it holds maxSize keys or its approximate memory usage would exceed maxBytes
 type MemTable struct {
-	mu       sync.RWMutex
-	entries  map[string]*Entry // Using map for simplicity; in production, use skip list
-	maxSize  int
-	size     int
-	readOnly bool
+	mu          sync.RWMutex
+	entries     map[string]*Entry // Using map for simplicity; in production, use skip list
+	maxSize     int               // maximum number of keys; zero means no limit
+	size        int
+	maxBytes    int // maximum approximate memory usage; zero means no limit
+	memoryUsage int // keys, values and per-entry overhead of every write, including overwritten ones
+	arena       *Arena
+	entrySlab   []Entry // unused rest of the current entry slab
+	slabSize    int
+	readOnly    bool
+	released    bool
+	operator    MergeOperator
+	// writeBufferManager, if set, accounts the memtable's memory against the service-wide budget
+	writeBufferManager *WriteBufferManager
+	// logNumber is the WAL file that was current when the memtable became active;
+	// that WAL and all newer ones may hold its entries
+	logNumber int
 }
 
 // NewMemTable creates a new MemTable with the specified maximum size
@@ -26,12 +51,48 @@ func NewMemTable(maxSize int) *MemTable {
 		entries:  make(map[string]*Entry),
 		maxSize:  maxSize,
 		size:     0,
+		arena:    NewArena(DefaultArenaBlockSize),
 		readOnly: false,
 	}
 }
 
+// SetMaxBytes limits the approximate memory usage of the memtable; zero means no limit
+// Must be called before the first write, as it also sizes the arena's blocks to the limit
+func (mt *MemTable) SetMaxBytes(maxBytes int) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	mt.maxBytes = maxBytes
+	if maxBytes > 0 && maxBytes/8 < DefaultArenaBlockSize {
+		mt.arena = NewArena(maxBytes / 8)
+	}
+}
+
+// SetWriteBufferManager accounts the memtable's memory against a service-wide budget
+// Must be called before the first write
+func (mt *MemTable) SetWriteBufferManager(manager *WriteBufferManager) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.writeBufferManager = manager
+}
+
 // Put adds or updates an entry in the MemTable
 func (mt *MemTable) Put(key, value []byte) error {
+	return mt.PutEntry(NewPutEntry(key, value))
+}
+
+// PutWithTTL adds or updates an entry that expires after the given TTL
+func (mt *MemTable) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	return mt.PutEntry(NewPutEntryWithTTL(key, value, ttl))
+}
+
+// Delete marks an entry as deleted by adding a tombstone
+func (mt *MemTable) Delete(key []byte) error {
+	return mt.PutEntry(NewDeleteEntry(key))
+}
+
+// PutEntry inserts a pre-built entry (e.g. one replayed from the WAL) into the MemTable
+func (mt *MemTable) PutEntry(entry *Entry) error {
 	mt.mu.Lock()
 	defer mt.mu.Unlock()
 
@@ -39,47 +100,94 @@ func (mt *MemTable) Put(key, value []byte) error {
 		return errors.New("memtable is read-only")
 	}
 
-	keyStr := string(key)
-	entry := NewPutEntry(key, value)
+	// Looking up with a converted []byte does not allocate
+	existing, exists := mt.entries[string(entry.Key())]
 
 	// Check if we're adding a new key and if we have space
-	if _, exists := mt.entries[keyStr]; !exists && mt.size >= mt.maxSize {
+	if !exists && mt.maxSize > 0 && mt.size >= mt.maxSize {
+		return ErrTableFull
+	}
+
+	// Every write costs memory, even an overwrite; an empty memtable accepts any entry
+	entrySize := len(entry.Key()) + len(entry.Value()) + memTableEntryOverhead
+	if mt.maxBytes > 0 && mt.memoryUsage > 0 && mt.memoryUsage+entrySize > mt.maxBytes {
 		return ErrTableFull
 	}
 
+	// Keep the key and value in the arena; this also stops the caller's slices from aliasing the memtable,
+	// and lets the caller's entry stay on its stack
+	stored := mt.allocEntry()
+	entry.copyTo(stored, mt.arena)
+	// The map key shares the key's arena memory instead of copying it into a new string
+	key := arenaString(stored.key)
+
 	// If key doesn't exist, increment size
-	if _, exists := mt.entries[keyStr]; !exists {
+	if !exists {
 		mt.size++
 	}
 
-	mt.entries[keyStr] = entry
+	if stored.IsMerge() && exists {
+		merged, err := mt.mergeInto(existing, stored)
+		if err != nil {
+			return err
+		}
+		stored = merged
+	}
+
+	// The replaced version stays reachable for readers that must not see this write yet
+	stored.older = existing
+	mt.entries[key] = stored
+	mt.memoryUsage += entrySize
+	if mt.writeBufferManager != nil {
+		mt.writeBufferManager.reserve(int64(entrySize))
+	}
 	return nil
 }
 
-// Delete marks an entry as deleted by adding a tombstone
-func (mt *MemTable) Delete(key []byte) error {
+// allocEntry returns an entry from the current slab, starting a new one when it is used up (caller must hold mt.mu)
+func (mt *MemTable) allocEntry() *Entry {
+	if len(mt.entrySlab) == 0 {
+		mt.slabSize = max(minEntrySlabSize, min(mt.slabSize*2, maxEntrySlabSize))
+		mt.entrySlab = make([]Entry, mt.slabSize)
+	}
+	entry := &mt.entrySlab[0]
+	mt.entrySlab = mt.entrySlab[1:]
+	return entry
+}
+
+// Merge adds a merge operand for the key
+func (mt *MemTable) Merge(key, operand []byte) error {
+	return mt.PutEntry(NewMergeEntry(key, operand))
+}
+
+// SetMergeOperator sets the operator used to combine merge operands with values in this MemTable
+func (mt *MemTable) SetMergeOperator(operator MergeOperator) {
 	mt.mu.Lock()
 	defer mt.mu.Unlock()
+	mt.operator = operator
+}
 
-	if mt.readOnly {
-		return errors.New("memtable is read-only")
+// mergeInto combines a new merge entry with the existing entry for the same key
+// Operands are stacked on top of other operands, and applied eagerly when the base value is known
+func (mt *MemTable) mergeInto(existing, entry *Entry) (*Entry, error) {
+	if existing.IsMerge() {
+		return stackMergeEntries(existing, entry)
 	}
 
-	keyStr := string(key)
-	entry := NewDeleteEntry(key)
+	resolver := NewValueResolver(entry.Key(), mt.operator)
+	resolver.Add(entry)
+	resolver.Add(existing)
 
-	// Check if we're adding a new key and if we have space
-	if _, exists := mt.entries[keyStr]; !exists && mt.size >= mt.maxSize {
-		return ErrTableFull
+	value, err := resolver.Value()
+	if err == ErrKeyNotFound {
+		tombstone := NewDeleteEntry(entry.Key())
+		tombstone.SetSequence(entry.Sequence())
+		return tombstone, nil
 	}
-
-	// If key doesn't exist, increment size
-	if _, exists := mt.entries[keyStr]; !exists {
-		mt.size++
+	if err != nil {
+		return nil, err
 	}
-
-	mt.entries[keyStr] = entry
-	return nil
+	return newResolvedEntry(entry, value, resolver.ExpiresAt()), nil
 }
 
 // Get retrieves an entry from the MemTable
@@ -95,6 +203,20 @@ func (mt *MemTable) Get(key []byte) (*Entry, error) {
 	return entry, nil
 }
 
+// GetAt retrieves the newest version of the key with a sequence number of at most sequence
+// Entries without a sequence number are always visible
+func (mt *MemTable) GetAt(key []byte, sequence uint64) (*Entry, error) {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+
+	for entry := mt.entries[string(key)]; entry != nil; entry = entry.older {
+		if entry.seq <= sequence {
+			return entry, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
 // Size returns the current number of entries in the MemTable
 func (mt *MemTable) Size() int {
 	mt.mu.RLock()
@@ -102,20 +224,47 @@ func (mt *MemTable) Size() int {
 	return mt.size
 }
 
+// MemoryUsage returns the approximate number of bytes the MemTable holds
+func (mt *MemTable) MemoryUsage() int {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+	return mt.memoryUsage
+}
+
 // IsFull returns true if the MemTable has reached its maximum capacity
 func (mt *MemTable) IsFull() bool {
 	mt.mu.RLock()
 	defer mt.mu.RUnlock()
-	return mt.size >= mt.maxSize
+	return (mt.maxSize > 0 && mt.size >= mt.maxSize) || (mt.maxBytes > 0 && mt.memoryUsage >= mt.maxBytes)
 }
 
 // SetReadOnly marks the MemTable as read-only (used during flushing)
 func (mt *MemTable) SetReadOnly() {
 	mt.mu.Lock()
 	defer mt.mu.Unlock()
+
+	if !mt.readOnly && mt.writeBufferManager != nil {
+		mt.writeBufferManager.markImmutable(int64(mt.memoryUsage))
+	}
 	mt.readOnly = true
 }
 
+// Release returns the memory of a flushed MemTable to its write buffer manager
+// Readers still holding the MemTable can use it until they drop it
+func (mt *MemTable) Release() {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	if mt.released || mt.writeBufferManager == nil {
+		return
+	}
+	if !mt.readOnly {
+		mt.writeBufferManager.markImmutable(int64(mt.memoryUsage))
+	}
+	mt.writeBufferManager.free(int64(mt.memoryUsage))
+	mt.released = true
+}
+
 // GetAllEntries returns all entries in the MemTable (used for flushing to disk)
 func (mt *MemTable) GetAllEntries() []*Entry {
 	mt.mu.RLock()
@@ -129,6 +278,20 @@ func (mt *MemTable) GetAllEntries() []*Entry {
 	return entries
 }
 
+// SetLogNumber records the WAL file that was current when the memtable became active
+func (mt *MemTable) SetLogNumber(number int) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.logNumber = number
+}
+
+// LogNumber returns the oldest WAL file that may hold the memtable's entries
+func (mt *MemTable) LogNumber() int {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+	return mt.logNumber
+}
+
 // IsReadOnly returns true if the MemTable is read-only
 func (mt *MemTable) IsReadOnly() bool {
 	mt.mu.RLock()
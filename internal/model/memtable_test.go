@@ -1,7 +1,9 @@
 package model
 
 import (
+	"fmt"
 	"testing"
+	"time"
 )
 
 func TestMemTablePutAndGet(t *testing.T) {
@@ -180,3 +182,207 @@ func TestMemTableGetAllEntries(t *testing.T) {
 		}
 	}
 }
+
+func TestMemTableGetAt(t *testing.T) {
+	mt := NewMemTable(10)
+
+	for i, value := range []string{"v1", "v2", "v3"} {
+		entry := NewPutEntry([]byte("key"), []byte(value))
+		entry.SetSequence(uint64(10 * (i + 1)))
+		if err := mt.PutEntry(entry); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	// Overwritten versions stay visible to readers at an older sequence number
+	for sequence, expected := range map[uint64]string{10: "v1", 25: "v2", 30: "v3", 100: "v3"} {
+		entry, err := mt.GetAt([]byte("key"), sequence)
+		if err != nil || string(entry.Value()) != expected {
+			t.Errorf("Sequence %d: expected %s, got %v (err %v)", sequence, expected, entry, err)
+		}
+	}
+	if _, err := mt.GetAt([]byte("key"), 5); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound before the first write, got %v", err)
+	}
+}
+
+func TestMemTablePutWithTTL(t *testing.T) {
+	mt := NewMemTable(10)
+
+	if err := mt.PutWithTTL([]byte("session"), []byte("data"), time.Hour); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	entry, err := mt.Get([]byte("session"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if !entry.HasTTL() {
+		t.Error("Expected entry to carry its TTL")
+	}
+
+	if entry.IsExpired() {
+		t.Error("Expected entry to not be expired yet")
+	}
+}
+
+func TestMemTableMerge(t *testing.T) {
+	mt := NewMemTable(10)
+	mt.SetMergeOperator(NewInt64AddOperator())
+
+	// Operands without a base value are stacked
+	mt.Merge([]byte("counter"), []byte("1"))
+	mt.Merge([]byte("counter"), []byte("2"))
+
+	entry, err := mt.Get([]byte("counter"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !entry.IsMerge() {
+		t.Fatal("Expected stacked merge entry")
+	}
+	operands, err := entry.Operands()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(operands) != 2 || string(operands[0]) != "1" || string(operands[1]) != "2" {
+		t.Errorf("Expected operands [1 2], got %q", operands)
+	}
+
+	// Operands on top of a value in the same memtable are applied eagerly
+	mt.Put([]byte("total"), []byte("10"))
+	mt.Merge([]byte("total"), []byte("5"))
+
+	entry, err = mt.Get([]byte("total"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if entry.IsMerge() || string(entry.Value()) != "15" {
+		t.Errorf("Expected resolved value 15, got %s (merge=%v)", entry.Value(), entry.IsMerge())
+	}
+
+	if mt.Size() != 2 {
+		t.Errorf("Expected size 2, got %d", mt.Size())
+	}
+}
+
+func TestMemTableByteCapacity(t *testing.T) {
+	mt := NewMemTable(0)
+	mt.SetMaxBytes(1000)
+
+	// The first entry is always accepted, however large
+	if err := mt.Put([]byte("huge"), make([]byte, 2000)); err != nil {
+		t.Fatalf("Expected an empty memtable to accept any entry, got %v", err)
+	}
+	if !mt.IsFull() {
+		t.Error("Expected memtable to be full")
+	}
+	if err := mt.Put([]byte("small"), []byte("value")); err != ErrTableFull {
+		t.Errorf("Expected ErrTableFull, got %v", err)
+	}
+
+	mt = NewMemTable(0)
+	mt.SetMaxBytes(1000)
+	entrySize := len("keyN") + len("value") + memTableEntryOverhead
+	count := 0
+	for ; ; count++ {
+		err := mt.Put([]byte(fmt.Sprintf("key%d", count)), []byte("value"))
+		if err == ErrTableFull {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if count != 1000/entrySize {
+		t.Errorf("Expected %d entries to fit, got %d", 1000/entrySize, count)
+	}
+	if mt.MemoryUsage() != count*entrySize {
+		t.Errorf("Expected memory usage %d, got %d", count*entrySize, mt.MemoryUsage())
+	}
+
+	// Overwrites use memory as well
+	mt = NewMemTable(0)
+	mt.Put([]byte("key"), []byte("value"))
+	mt.Put([]byte("key"), []byte("value"))
+	if mt.Size() != 1 || mt.MemoryUsage() != 2*(len("key")+len("value")+memTableEntryOverhead) {
+		t.Errorf("Expected 1 key using two writes' memory, got %d keys and %d bytes", mt.Size(), mt.MemoryUsage())
+	}
+}
+
+func TestMemTableCopiesIntoArena(t *testing.T) {
+	mt := NewMemTable(10)
+
+	key := []byte("key")
+	value := []byte("value")
+	mt.Put(key, value)
+
+	// Reusing the caller's buffers must not change the stored entry
+	key[0] = 'X'
+	value[0] = 'X'
+	entry, err := mt.Get([]byte("key"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(entry.Value()) != "value" {
+		t.Errorf("Expected value, got %s", entry.Value())
+	}
+}
+
+func TestMemTablePutAllocations(t *testing.T) {
+	keys := make([][]byte, 2000)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key%08d", i))
+	}
+	value := make([]byte, 100)
+
+	mt := NewMemTable(0)
+	i := 0
+	// Entry slabs, arena blocks and map growth are shared by many writes
+	allocs := testing.AllocsPerRun(1000, func() {
+		mt.Put(keys[i], value)
+		i++
+	})
+	if allocs >= 1 {
+		t.Errorf("Expected less than one allocation per Put, got %.2f", allocs)
+	}
+}
+
+func BenchmarkMemTablePut(b *testing.B) {
+	keys := make([][]byte, 1024)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key%08d", i))
+	}
+	value := make([]byte, 100)
+
+	mt := NewMemTable(0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%len(keys) == 0 && i > 0 {
+			// Start over with a fresh memtable so every Put inserts a new key
+			mt = NewMemTable(0)
+		}
+		if err := mt.Put(keys[i%len(keys)], value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMemTableGet(b *testing.B) {
+	keys := make([][]byte, 1024)
+	mt := NewMemTable(0)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key%08d", i))
+		mt.Put(keys[i], make([]byte, 100))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := mt.Get(keys[i%len(keys)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrNoMergeOperator = errors.New("no merge operator configured")
+)
+
+// MergeOperator combines merge operands with the existing value of a key
+// Operands are always passed oldest first
+type MergeOperator interface {
+	// Name returns the name of the operator
+	Name() string
+
+	// FullMerge applies operands on top of existingValue (nil if the key has no live value)
+	FullMerge(key, existingValue []byte, operands [][]byte) ([]byte, error)
+}
+
+// Int64AddOperator treats values as decimal int64 counters and adds operands to them
+type Int64AddOperator struct{}
+
+// NewInt64AddOperator creates a new int64 add merge operator
+func NewInt64AddOperator() *Int64AddOperator {
+	return &Int64AddOperator{}
+}
+
+// Name returns the name of the operator
+func (op *Int64AddOperator) Name() string {
+	return "int64add"
+}
+
+// FullMerge adds all operands to the existing counter (missing counters start at 0)
+func (op *Int64AddOperator) FullMerge(key, existingValue []byte, operands [][]byte) ([]byte, error) {
+	var sum int64
+	if existingValue != nil {
+		value, err := parseInt64(existingValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid existing value for key %s: %w", key, err)
+		}
+		sum = value
+	}
+
+	for _, operand := range operands {
+		delta, err := parseInt64(operand)
+		if err != nil {
+			return nil, fmt.Errorf("invalid operand for key %s: %w", key, err)
+		}
+		sum += delta
+	}
+
+	return []byte(strconv.FormatInt(sum, 10)), nil
+}
+
+// StringAppendOperator appends operands to the existing value separated by a delimiter
+type StringAppendOperator struct {
+	delimiter []byte
+}
+
+// NewStringAppendOperator creates a new string append merge operator
+func NewStringAppendOperator(delimiter string) *StringAppendOperator {
+	return &StringAppendOperator{
+		delimiter: []byte(delimiter),
+	}
+}
+
+// Name returns the name of the operator
+func (op *StringAppendOperator) Name() string {
+	return "stringappend"
+}
+
+// FullMerge appends all operands to the existing value
+func (op *StringAppendOperator) FullMerge(key, existingValue []byte, operands [][]byte) ([]byte, error) {
+	parts := make([][]byte, 0, len(operands)+1)
+	if existingValue != nil {
+		parts = append(parts, existingValue)
+	}
+	parts = append(parts, operands...)
+
+	return bytes.Join(parts, op.delimiter), nil
+}
+
+// MaxOperator treats values as decimal int64 numbers and keeps the largest one
+type MaxOperator struct{}
+
+// NewMaxOperator creates a new max merge operator
+func NewMaxOperator() *MaxOperator {
+	return &MaxOperator{}
+}
+
+// Name returns the name of the operator
+func (op *MaxOperator) Name() string {
+	return "max"
+}
+
+// FullMerge returns the largest of the existing value and all operands
+func (op *MaxOperator) FullMerge(key, existingValue []byte, operands [][]byte) ([]byte, error) {
+	var max int64
+	found := false
+
+	candidates := operands
+	if existingValue != nil {
+		candidates = append([][]byte{existingValue}, operands...)
+	}
+
+	for _, candidate := range candidates {
+		value, err := parseInt64(candidate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for key %s: %w", key, err)
+		}
+		if !found || value > max {
+			max = value
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return []byte(strconv.FormatInt(max, 10)), nil
+}
+
+// parseInt64 parses a decimal int64 value
+func parseInt64(value []byte) (int64, error) {
+	return strconv.ParseInt(string(bytes.TrimSpace(value)), 10, 64)
+}
+
+// ValueResolver folds the versions of a single key, visited from newest to oldest,
+// into the value a reader should see
+type ValueResolver struct {
+	key       []byte
+	operator  MergeOperator
+	operands  [][]byte
+	base      []byte
+	hasBase   bool
+	expiresAt time.Time
+	version   uint64
+	visited   bool
+	done      bool
+	err       error
+}
+
+// NewValueResolver creates a resolver for the given key
+func NewValueResolver(key []byte, operator MergeOperator) *ValueResolver {
+	return &ValueResolver{
+		key:      key,
+		operator: operator,
+	}
+}
+
+// Add visits the next older version of the key and returns true once no older versions are needed
+func (r *ValueResolver) Add(entry *Entry) bool {
+	if r.done {
+		return true
+	}
+
+	// The newest version determines the version token of the resolved value
+	if !r.visited {
+		r.version = entry.Sequence()
+		r.visited = true
+	}
+
+	switch {
+	case entry.IsMerge():
+		operands, err := entry.Operands()
+		if err != nil {
+			r.err = err
+			r.done = true
+			return true
+		}
+		// Older operands are applied first
+		r.operands = append(operands, r.operands...)
+		return false
+	case entry.IsDeleted() || entry.IsExpired():
+		r.done = true
+	default:
+		r.base = entry.Value()
+		r.hasBase = true
+		r.expiresAt = entry.ExpiresAt()
+		r.done = true
+	}
+	return true
+}
+
+// Value returns the resolved value, or ErrKeyNotFound if the key has no live value
+func (r *ValueResolver) Value() ([]byte, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if len(r.operands) == 0 {
+		if !r.hasBase {
+			return nil, ErrKeyNotFound
+		}
+		return r.base, nil
+	}
+
+	if r.operator == nil {
+		return nil, ErrNoMergeOperator
+	}
+
+	value, err := r.operator.FullMerge(r.key, r.base, r.operands)
+	if err != nil {
+		return nil, fmt.Errorf("merge operator %s failed: %w", r.operator.Name(), err)
+	}
+	if value == nil {
+		return nil, ErrKeyNotFound
+	}
+	return value, nil
+}
+
+// ExpiresAt returns when the base value expires, so a value merged onto it expires with it;
+// zero if there is no base value or it never expires
+func (r *ValueResolver) ExpiresAt() time.Time {
+	return r.expiresAt
+}
+
+// Version returns the sequence number of the newest version visited
+func (r *ValueResolver) Version() uint64 {
+	return r.version
+}
+
+// encodeOperands serializes merge operands as [count]([len][operand])*
+func encodeOperands(operands [][]byte) []byte {
+	size := 4
+	for _, operand := range operands {
+		size += 4 + len(operand)
+	}
+
+	buf := make([]byte, size)
+	binary.LittleEndian.PutUint32(buf, uint32(len(operands)))
+	offset := 4
+	for _, operand := range operands {
+		binary.LittleEndian.PutUint32(buf[offset:], uint32(len(operand)))
+		offset += 4
+		offset += copy(buf[offset:], operand)
+	}
+
+	return buf
+}
+
+// decodeOperands deserializes merge operands written by encodeOperands
+func decodeOperands(data []byte) ([][]byte, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("merge operand list too short")
+	}
+
+	count := binary.LittleEndian.Uint32(data)
+	offset := 4
+	operands := make([][]byte, 0, count)
+	for i := uint32(0); i < count; i++ {
+		if len(data)-offset < 4 {
+			return nil, fmt.Errorf("truncated merge operand length")
+		}
+		length := int(binary.LittleEndian.Uint32(data[offset:]))
+		offset += 4
+
+		if len(data)-offset < length {
+			return nil, fmt.Errorf("truncated merge operand")
+		}
+		operands = append(operands, data[offset:offset+length])
+		offset += length
+	}
+
+	return operands, nil
+}
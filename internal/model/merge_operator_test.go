@@ -0,0 +1,135 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestInt64AddOperator(t *testing.T) {
+	op := NewInt64AddOperator()
+
+	value, err := op.FullMerge([]byte("counter"), []byte("10"), [][]byte{[]byte("5"), []byte("-3")})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(value) != "12" {
+		t.Errorf("Expected 12, got %s", value)
+	}
+
+	// Missing counters start at zero
+	value, err = op.FullMerge([]byte("counter"), nil, [][]byte{[]byte("7")})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(value) != "7" {
+		t.Errorf("Expected 7, got %s", value)
+	}
+
+	if _, err := op.FullMerge([]byte("counter"), []byte("abc"), [][]byte{[]byte("1")}); err == nil {
+		t.Error("Expected error for non-numeric existing value")
+	}
+}
+
+func TestStringAppendOperator(t *testing.T) {
+	op := NewStringAppendOperator(",")
+
+	value, err := op.FullMerge([]byte("list"), []byte("a"), [][]byte{[]byte("b"), []byte("c")})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(value) != "a,b,c" {
+		t.Errorf("Expected a,b,c, got %s", value)
+	}
+
+	value, err = op.FullMerge([]byte("list"), nil, [][]byte{[]byte("b")})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(value) != "b" {
+		t.Errorf("Expected b, got %s", value)
+	}
+}
+
+func TestMaxOperator(t *testing.T) {
+	op := NewMaxOperator()
+
+	value, err := op.FullMerge([]byte("max"), []byte("9"), [][]byte{[]byte("10"), []byte("3")})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(value) != "10" {
+		t.Errorf("Expected 10 (numeric max), got %s", value)
+	}
+}
+
+func TestValueResolver(t *testing.T) {
+	op := NewInt64AddOperator()
+	key := []byte("counter")
+
+	// Operands on top of a base value, visited newest first
+	resolver := NewValueResolver(key, op)
+	if resolver.Add(NewMergeEntry(key, []byte("2"))) {
+		t.Fatal("Expected resolver to need older versions after a merge entry")
+	}
+	if resolver.Add(NewMergeEntry(key, []byte("3"))) {
+		t.Fatal("Expected resolver to need older versions after a merge entry")
+	}
+	if !resolver.Add(NewPutEntry(key, []byte("10"))) {
+		t.Fatal("Expected resolver to stop at a put entry")
+	}
+
+	value, err := resolver.Value()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(value) != "15" {
+		t.Errorf("Expected 15, got %s", value)
+	}
+
+	// A tombstone resets the base value
+	resolver = NewValueResolver(key, op)
+	resolver.Add(NewMergeEntry(key, []byte("4")))
+	resolver.Add(NewDeleteEntry(key))
+	value, err = resolver.Value()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(value) != "4" {
+		t.Errorf("Expected 4, got %s", value)
+	}
+
+	// Only a tombstone means the key is not found
+	resolver = NewValueResolver(key, op)
+	resolver.Add(NewDeleteEntry(key))
+	if _, err := resolver.Value(); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+
+	// Operands cannot be resolved without an operator
+	resolver = NewValueResolver(key, nil)
+	resolver.Add(NewMergeEntry(key, []byte("1")))
+	if _, err := resolver.Value(); err != ErrNoMergeOperator {
+		t.Errorf("Expected ErrNoMergeOperator, got %v", err)
+	}
+}
+
+func TestEncodeDecodeOperands(t *testing.T) {
+	operands := [][]byte{[]byte("a"), []byte(""), []byte("hello")}
+
+	decoded, err := decodeOperands(encodeOperands(operands))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(decoded) != len(operands) {
+		t.Fatalf("Expected %d operands, got %d", len(operands), len(decoded))
+	}
+	for i := range operands {
+		if string(decoded[i]) != string(operands[i]) {
+			t.Errorf("Operand %d: expected %s, got %s", i, operands[i], decoded[i])
+		}
+	}
+
+	if _, err := decodeOperands([]byte{1, 0, 0, 0, 5}); err == nil {
+		t.Error("Expected error for truncated operand list")
+	}
+}
@@ -0,0 +1,132 @@
+package model
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// IOPriority orders requests competing for a RateLimiter
+type IOPriority int
+
+const (
+	IOPriorityLow  IOPriority = iota // compaction writes
+	IOPriorityHigh                   // flush writes, which free memtables that foreground writes wait for
+)
+
+// rateLimiterRefillInterval is how long a request waits before checking for new tokens
+const rateLimiterRefillInterval = 10 * time.Millisecond
+
+// rateLimiterBurst is the share of a second's budget that may accumulate while nothing is written
+const rateLimiterBurst = 0.1
+
+// RateLimiter is a token bucket limiting the bytes per second written to SSTables
+// High priority requests are served before low priority ones; a limit of zero disables limiting
+type RateLimiter struct {
+	mu             sync.Mutex
+	bytesPerSecond int64
+	available      float64 // tokens (bytes) that may be written right away
+	lastRefill     time.Time
+	highWaiting    int // high priority requests waiting for tokens
+}
+
+// NewRateLimiter creates a rate limiter allowing bytesPerSecond bytes per second; zero means unlimited
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	return &RateLimiter{
+		bytesPerSecond: bytesPerSecond,
+		lastRefill:     time.Now(),
+	}
+}
+
+// BytesPerSecond returns the current limit; zero means unlimited
+func (rl *RateLimiter) BytesPerSecond() int64 {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.bytesPerSecond
+}
+
+// SetBytesPerSecond changes the limit at runtime; zero disables limiting
+// Waiting requests continue at the new rate
+func (rl *RateLimiter) SetBytesPerSecond(bytesPerSecond int64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill(time.Now())
+	rl.bytesPerSecond = bytesPerSecond
+	if burst := rl.burst(); rl.available > burst {
+		rl.available = burst
+	}
+}
+
+// Request blocks until the given number of bytes may be written
+// Large requests are granted in parts as tokens become available
+func (rl *RateLimiter) Request(bytes int, priority IOPriority) {
+	if bytes <= 0 {
+		return
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if priority == IOPriorityHigh {
+		rl.highWaiting++
+		defer func() { rl.highWaiting-- }()
+	}
+
+	remaining := float64(bytes)
+	for {
+		if rl.bytesPerSecond <= 0 {
+			return
+		}
+
+		rl.refill(time.Now())
+		if rl.available > 0 && (priority == IOPriorityHigh || rl.highWaiting == 0) {
+			grant := remaining
+			if grant > rl.available {
+				grant = rl.available
+			}
+			rl.available -= grant
+			remaining -= grant
+			if remaining <= 0 {
+				return
+			}
+		}
+
+		// Wait for more tokens without holding the lock
+		rl.mu.Unlock()
+		time.Sleep(rateLimiterRefillInterval)
+		rl.mu.Lock()
+	}
+}
+
+// refill adds the tokens accumulated since the last refill (caller must hold rl.mu)
+func (rl *RateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(rl.lastRefill).Seconds()
+	rl.lastRefill = now
+	if rl.bytesPerSecond <= 0 {
+		return
+	}
+
+	rl.available += elapsed * float64(rl.bytesPerSecond)
+	if burst := rl.burst(); rl.available > burst {
+		rl.available = burst
+	}
+}
+
+// burst returns the maximum number of tokens that may accumulate (caller must hold rl.mu)
+func (rl *RateLimiter) burst() float64 {
+	return float64(rl.bytesPerSecond) * rateLimiterBurst
+}
+
+// rateLimitedWriter passes writes through a RateLimiter
+type rateLimitedWriter struct {
+	writer   io.Writer
+	limiter  *RateLimiter
+	priority IOPriority
+}
+
+// Write waits for tokens, then writes p to the underlying writer
+func (w *rateLimitedWriter) Write(p []byte) (int, error) {
+	w.limiter.Request(len(p), w.priority)
+	return w.writer.Write(p)
+}
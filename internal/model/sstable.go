@@ -2,32 +2,42 @@ package model
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"sync/atomic"
 	"time"
 )
 
 // SSTableMetadata contains metadata about an SSTable
 type SSTableMetadata struct {
-	Level       int
-	FileName    string
-	MinKey      []byte
-	MaxKey      []byte
-	EntryCount  uint32
-	FileSize    uint64
-	CreatedAt   time.Time
-	BloomFilter *BloomFilter
-	BlockIndex  *BlockIndex
+	Level          int
+	FileName       string
+	MinKey         []byte
+	MaxKey         []byte
+	EntryCount     uint32
+	MinSequence    uint64    // smallest sequence number of any entry
+	MaxSequence    uint64    // largest sequence number of any entry
+	MinTimestamp   time.Time // oldest write time of any entry
+	MaxTimestamp   time.Time // newest write time of any entry
+	MaxExpiresAt   time.Time // latest expiry of any entry; zero if some entry never expires
+	TombstoneCount uint32    // number of delete markers
+	FileSize       uint64
+	CreatedAt      time.Time
+	BloomFilter    *BloomFilter
+	BlockIndex     *BlockIndex
 }
 
 // SSTable represents an immutable sorted string table on disk
 type SSTable struct {
-	metadata *SSTableMetadata
-	filePath string
+	metadata       *SSTableMetadata
+	filePath       string
+	beingCompacted bool         // set while a running compaction task uses the table as input
+	refs           atomic.Int32 // versions referencing the table; the file is deleted when the last one is released
 }
 
 // SSTableBuilder builds SSTables from entries
@@ -37,6 +47,8 @@ type SSTableBuilder struct {
 	blockIndex  *BlockIndex
 	level       int
 	blockSize   int
+	rateLimiter *RateLimiter
+	ioPriority  IOPriority
 }
 
 // NewSSTableBuilder creates a new SSTable builder
@@ -51,6 +63,12 @@ func NewSSTableBuilder(level int, estimatedEntries uint32) *SSTableBuilder {
 	}
 }
 
+// SetRateLimiter throttles the builder's file writes through the limiter at the given priority
+func (builder *SSTableBuilder) SetRateLimiter(limiter *RateLimiter, priority IOPriority) {
+	builder.rateLimiter = limiter
+	builder.ioPriority = priority
+}
+
 // AddEntry adds an entry to the builder
 func (builder *SSTableBuilder) AddEntry(entry *Entry) {
 	builder.entries = append(builder.entries, entry)
@@ -73,14 +91,27 @@ func (builder *SSTableBuilder) Build(dir, filename string) (*SSTable, error) {
 		return nil, fmt.Errorf("failed to create directory: %w", err)
 	}
 
+	// Write to a temporary file that is renamed into place once synced, so a crash never leaves
+	// a partial table under an SSTable name
 	filePath := filepath.Join(dir, filename)
-	file, err := os.Create(filePath)
+	tmpPath := filePath + TempFileSuffix
+	file, err := os.Create(tmpPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create SSTable file: %w", err)
 	}
-	defer file.Close()
+	installed := false
+	defer func() {
+		file.Close()
+		if !installed {
+			os.Remove(tmpPath)
+		}
+	}()
 
-	writer := bufio.NewWriter(file)
+	var output io.Writer = file
+	if builder.rateLimiter != nil {
+		output = &rateLimitedWriter{writer: file, limiter: builder.rateLimiter, priority: builder.ioPriority}
+	}
+	writer := bufio.NewWriter(output)
 	var currentOffset uint64 = 0
 
 	// Write entries and build block index
@@ -95,9 +126,7 @@ func (builder *SSTableBuilder) Build(dir, filename string) (*SSTable, error) {
 			return nil, fmt.Errorf("failed to write entry: %w", err)
 		}
 
-		// Calculate the size of this entry for offset tracking
-		entrySize := uint64(4 + len(entry.Key()) + 4 + len(entry.Value()) + 1 + 8) // keyLen + key + valueLen + value + entryType + timestamp
-		currentOffset = entryStartOffset + entrySize
+		currentOffset = entryStartOffset + encodedEntrySize(entry)
 	}
 
 	if err := writer.Flush(); err != nil {
@@ -110,28 +139,99 @@ func (builder *SSTableBuilder) Build(dir, filename string) (*SSTable, error) {
 		return nil, fmt.Errorf("failed to get file stats: %w", err)
 	}
 
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync SSTable file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close SSTable file: %w", err)
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return nil, fmt.Errorf("failed to install SSTable file: %w", err)
+	}
+	installed = true
+	// The rename itself is only durable once the directory is synced
+	if err := syncDir(dir); err != nil {
+		return nil, fmt.Errorf("failed to sync SSTable directory: %w", err)
+	}
+
+	return builder.newSSTable(filePath, filename, uint64(fileInfo.Size())), nil
+}
+
+// syncDir flushes a directory's entries, such as a file renamed into it, to stable storage
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// newSSTable describes the file written from the builder's sorted entries
+func (builder *SSTableBuilder) newSSTable(filePath, filename string, fileSize uint64) *SSTable {
+	// Track the sequence and write time ranges so tables can be ordered by age,
+	// the latest expiry so fully expired tables can be dropped, and the tombstone count for file picking
+	first := builder.entries[0]
+	minSequence, maxSequence := first.Sequence(), first.Sequence()
+	minTimestamp, maxTimestamp := first.Timestamp(), first.Timestamp()
+	maxExpiresAt := first.ExpiresAt()
+	var tombstoneCount uint32
+	for _, entry := range builder.entries {
+		if entry.IsDeleted() {
+			tombstoneCount++
+		}
+		if entry.Sequence() < minSequence {
+			minSequence = entry.Sequence()
+		}
+		if entry.Sequence() > maxSequence {
+			maxSequence = entry.Sequence()
+		}
+		if entry.Timestamp().Before(minTimestamp) {
+			minTimestamp = entry.Timestamp()
+		}
+		if entry.Timestamp().After(maxTimestamp) {
+			maxTimestamp = entry.Timestamp()
+		}
+		if !entry.HasTTL() {
+			maxExpiresAt = time.Time{}
+		} else if !maxExpiresAt.IsZero() && entry.ExpiresAt().After(maxExpiresAt) {
+			maxExpiresAt = entry.ExpiresAt()
+		}
+	}
+
 	// Create metadata
 	metadata := &SSTableMetadata{
-		Level:       builder.level,
-		FileName:    filename,
-		MinKey:      builder.entries[0].Key(),
-		MaxKey:      builder.entries[len(builder.entries)-1].Key(),
-		EntryCount:  uint32(len(builder.entries)),
-		FileSize:    uint64(fileInfo.Size()),
-		CreatedAt:   time.Now(),
-		BloomFilter: builder.bloomFilter,
-		BlockIndex:  builder.blockIndex,
+		Level:          builder.level,
+		FileName:       filename,
+		MinKey:         builder.entries[0].Key(),
+		MaxKey:         builder.entries[len(builder.entries)-1].Key(),
+		EntryCount:     uint32(len(builder.entries)),
+		MinSequence:    minSequence,
+		MaxSequence:    maxSequence,
+		MinTimestamp:   minTimestamp,
+		MaxTimestamp:   maxTimestamp,
+		MaxExpiresAt:   maxExpiresAt,
+		TombstoneCount: tombstoneCount,
+		FileSize:       fileSize,
+		CreatedAt:      time.Now(),
+		BloomFilter:    builder.bloomFilter,
+		BlockIndex:     builder.blockIndex,
 	}
 
 	return &SSTable{
 		metadata: metadata,
 		filePath: filePath,
-	}, nil
+	}
+}
+
+// encodedEntrySize returns the number of bytes writeEntry uses for the entry
+func encodedEntrySize(entry *Entry) uint64 {
+	return uint64(4 + len(entry.Key()) + 4 + len(entry.Value()) + 1 + 8 + 8 + 8) // keyLen + key + valueLen + value + entryType + timestamp + expiresAt + sequence
 }
 
 // writeEntry writes a single entry to the writer
 func (builder *SSTableBuilder) writeEntry(writer *bufio.Writer, entry *Entry) error {
-	// Entry format: [keyLen][key][valueLen][value][entryType][timestamp]
+	// Entry format: [keyLen][key][valueLen][value][entryType][timestamp][expiresAt][sequence]
 
 	// Write key length and key
 	if err := binary.Write(writer, binary.LittleEndian, uint32(len(entry.key))); err != nil {
@@ -159,9 +259,52 @@ func (builder *SSTableBuilder) writeEntry(writer *bufio.Writer, entry *Entry) er
 		return err
 	}
 
+	// Write expiry time
+	if err := binary.Write(writer, binary.LittleEndian, expiryToUnixNano(entry.expiresAt)); err != nil {
+		return err
+	}
+
+	// Write sequence number
+	if err := binary.Write(writer, binary.LittleEndian, entry.seq); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// OpenSSTable opens an SSTable file written by Build, e.g. after a restart
+// The metadata, bloom filter and block index only live in memory, so they are rebuilt from the entries
+func OpenSSTable(dir, filename string, level int) (*SSTable, error) {
+	filePath := filepath.Join(dir, filename)
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat SSTable file: %w", err)
+	}
+
+	entries, err := (&SSTable{filePath: filePath}).GetAllEntries()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("SSTable %s has no entries", filename)
+	}
+
+	// The entries are already sorted; index them as Build did while writing
+	builder := NewSSTableBuilder(level, uint32(len(entries)))
+	var offset uint64
+	for i, entry := range entries {
+		builder.AddEntry(entry)
+		if i%builder.blockSize == 0 {
+			builder.blockIndex.AddEntry(entry.Key(), offset)
+		}
+		offset += encodedEntrySize(entry)
+	}
+
+	sst := builder.newSSTable(filePath, filename, uint64(fileInfo.Size()))
+	sst.metadata.CreatedAt = fileInfo.ModTime()
+	return sst, nil
+}
+
 // LoadSSTable loads an existing SSTable from disk
 func LoadSSTable(filePath string, metadata *SSTableMetadata) *SSTable {
 	return &SSTable{
@@ -258,11 +401,25 @@ func (sst *SSTable) readEntry(reader *bufio.Reader) (*Entry, error) {
 		return nil, err
 	}
 
+	// Read expiry time
+	var expiresAtNano int64
+	if err := binary.Read(reader, binary.LittleEndian, &expiresAtNano); err != nil {
+		return nil, err
+	}
+
+	// Read sequence number
+	var seq uint64
+	if err := binary.Read(reader, binary.LittleEndian, &seq); err != nil {
+		return nil, err
+	}
+
 	entry := &Entry{
 		key:       key,
 		value:     value,
 		entryType: EntryType(entryType),
 		timestamp: time.Unix(0, timestampNano),
+		expiresAt: expiryFromUnixNano(expiresAtNano),
+		seq:       seq,
 	}
 
 	return entry, nil
@@ -293,16 +450,82 @@ func (sst *SSTable) GetAllEntries() ([]*Entry, error) {
 	return entries, nil
 }
 
+// GetEntriesInRange returns the entries with start <= key < end, seeking to start with the block index
+// A nil start or end leaves that side of the range unbounded
+func (sst *SSTable) GetEntriesInRange(start, end []byte) ([]*Entry, error) {
+	file, err := os.Open(sst.filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SSTable file: %w", err)
+	}
+	defer file.Close()
+
+	startOffset := uint64(0)
+	if start != nil && sst.metadata.BlockIndex != nil {
+		startOffset = sst.metadata.BlockIndex.FindOffset(start)
+	}
+	if _, err := file.Seek(int64(startOffset), 0); err != nil {
+		return nil, fmt.Errorf("failed to seek to offset %d: %w", startOffset, err)
+	}
+
+	reader := bufio.NewReader(file)
+	var entries []*Entry
+
+	for {
+		entry, err := sst.readEntry(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read entry: %w", err)
+		}
+		if start != nil && bytes.Compare(entry.Key(), start) < 0 {
+			continue
+		}
+		if end != nil && bytes.Compare(entry.Key(), end) >= 0 {
+			break
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
 // Metadata returns the metadata of the SSTable
 func (sst *SSTable) Metadata() *SSTableMetadata {
 	return sst.metadata
 }
 
+// MoveToLevel reassigns the table to another level; the file is not rewritten
+func (sst *SSTable) MoveToLevel(level int) {
+	sst.metadata.Level = level
+}
+
+// BeingCompacted returns true while a running compaction task uses the table as input
+func (sst *SSTable) BeingCompacted() bool {
+	return sst.beingCompacted
+}
+
 // Remove removes the SSTable file from disk
 func (sst *SSTable) Remove() error {
 	return os.Remove(sst.filePath)
 }
 
+// ref records that another version references the table
+func (sst *SSTable) ref() {
+	sst.refs.Add(1)
+}
+
+// unref releases a version's reference; the last one deletes the file, which by then
+// no compaction output or reader can still need
+func (sst *SSTable) unref() {
+	if sst.refs.Add(-1) == 0 {
+		if err := sst.Remove(); err != nil && !os.IsNotExist(err) {
+			// In production, this should be logged properly
+			fmt.Printf("Failed to remove obsolete SSTable %s: %v\n", sst.filePath, err)
+		}
+	}
+}
+
 // Iterator creates an iterator for the SSTable
 func (sst *SSTable) Iterator() (*SSTableIterator, error) {
 	file, err := os.Open(sst.filePath)
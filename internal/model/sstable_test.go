@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestSSTableBuildAndGet(t *testing.T) {
@@ -98,6 +99,66 @@ func TestSSTableMetadata(t *testing.T) {
 	}
 }
 
+func TestOpenSSTable(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "sstable_open_test")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	// Enough entries for several block index entries
+	builder := NewSSTableBuilder(2, 250)
+	for i := 0; i < 250; i++ {
+		entry := NewPutEntry([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("value%d", i)))
+		entry.SetSequence(uint64(i + 1))
+		builder.AddEntry(entry)
+	}
+	tombstone := NewDeleteEntry([]byte("key250"))
+	tombstone.SetSequence(251)
+	builder.AddEntry(tombstone)
+	built, err := builder.Build(tmpDir, "open.sst")
+	if err != nil {
+		t.Fatalf("Failed to build SSTable: %v", err)
+	}
+
+	// The table is written under a temporary name and renamed into place
+	if _, err := os.Stat(filepath.Join(tmpDir, "open.sst"+TempFileSuffix)); !os.IsNotExist(err) {
+		t.Errorf("Expected no temporary file after Build, got %v", err)
+	}
+
+	opened, err := OpenSSTable(tmpDir, "open.sst", 2)
+	if err != nil {
+		t.Fatalf("Failed to open SSTable: %v", err)
+	}
+
+	// The metadata kept in memory is rebuilt from the file
+	want, got := built.Metadata(), opened.Metadata()
+	if got.Level != 2 || got.FileName != "open.sst" || got.EntryCount != want.EntryCount || got.FileSize != want.FileSize {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	if string(got.MinKey) != "key000" || string(got.MaxKey) != "key250" {
+		t.Errorf("Expected keys key000..key250, got %s..%s", got.MinKey, got.MaxKey)
+	}
+	if got.MinSequence != 1 || got.MaxSequence != 251 || got.TombstoneCount != 1 {
+		t.Errorf("Expected sequences 1..251 and 1 tombstone, got %d..%d and %d", got.MinSequence, got.MaxSequence, got.TombstoneCount)
+	}
+
+	for _, i := range []int{0, 99, 100, 201, 249} {
+		entry, err := opened.Get([]byte(fmt.Sprintf("key%03d", i)))
+		if err != nil || string(entry.Value()) != fmt.Sprintf("value%d", i) {
+			t.Errorf("key%03d: expected value%d, got %v (err %v)", i, i, entry, err)
+		}
+	}
+	if entry, err := opened.Get([]byte("key250")); err != nil || !entry.IsDeleted() {
+		t.Errorf("Expected tombstone for key250, got %v (err %v)", entry, err)
+	}
+	if _, err := opened.Get([]byte("missing")); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+
+	if _, err := OpenSSTable(tmpDir, "absent.sst", 0); err == nil {
+		t.Error("Expected an error opening a missing file")
+	}
+}
+
 func TestSSTableIterator(t *testing.T) {
 	tmpDir := filepath.Join(os.TempDir(), "sstable_iterator_test")
 	defer os.RemoveAll(tmpDir)
@@ -342,3 +403,74 @@ func TestSSTableBloomFilter(t *testing.T) {
 		t.Errorf("Too many false positives: %d out of 50", falsePositives)
 	}
 }
+
+func TestSSTablePersistsTTL(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "sstable_ttl_test")
+	defer os.RemoveAll(tmpDir)
+
+	builder := NewSSTableBuilder(0, 2)
+	ttlEntry := NewPutEntryWithTTL([]byte("session"), []byte("data"), time.Hour)
+	builder.AddEntry(ttlEntry)
+	builder.AddEntry(NewPutEntry([]byte("user"), []byte("alice")))
+
+	sst, err := builder.Build(tmpDir, "ttl_test.sst")
+	if err != nil {
+		t.Fatalf("Failed to build SSTable: %v", err)
+	}
+
+	entry, err := sst.Get([]byte("session"))
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	if !entry.ExpiresAt().Equal(ttlEntry.ExpiresAt()) {
+		t.Errorf("Expected expiry %v, got %v", ttlEntry.ExpiresAt(), entry.ExpiresAt())
+	}
+
+	// The entry after a TTL entry must still be readable (offsets include the expiry field)
+	entry, err = sst.Get([]byte("user"))
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if entry.HasTTL() {
+		t.Error("Expected entry without TTL to have no expiry")
+	}
+}
+
+func TestSSTableMetadataTimeRanges(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "test_sstable_time_ranges")
+	defer os.RemoveAll(tmpDir)
+
+	build := func(filename string, entries ...*Entry) *SSTable {
+		builder := NewSSTableBuilder(0, uint32(len(entries)))
+		for _, entry := range entries {
+			builder.AddEntry(entry)
+		}
+		sst, err := builder.Build(tmpDir, filename)
+		if err != nil {
+			t.Fatalf("Failed to build SSTable: %v", err)
+		}
+		return sst
+	}
+
+	first := NewPutEntryWithTTL([]byte("a"), []byte("1"), time.Minute)
+	first.SetSequence(7)
+	second := NewPutEntryWithTTL([]byte("b"), []byte("2"), time.Hour)
+	second.SetSequence(3)
+
+	metadata := build("ttl.sst", first, second).Metadata()
+	if metadata.MinSequence != 3 || metadata.MaxSequence != 7 {
+		t.Errorf("Expected sequence range [3, 7], got [%d, %d]", metadata.MinSequence, metadata.MaxSequence)
+	}
+	if !metadata.MinTimestamp.Equal(first.Timestamp()) || !metadata.MaxTimestamp.Equal(second.Timestamp()) {
+		t.Errorf("Unexpected timestamp range [%v, %v]", metadata.MinTimestamp, metadata.MaxTimestamp)
+	}
+	if !metadata.MaxExpiresAt.Equal(second.ExpiresAt()) {
+		t.Errorf("Expected max expiry %v, got %v", second.ExpiresAt(), metadata.MaxExpiresAt)
+	}
+
+	// A single entry without TTL means the table never fully expires
+	metadata = build("mixed.sst", first, NewPutEntry([]byte("c"), []byte("3"))).Metadata()
+	if !metadata.MaxExpiresAt.IsZero() {
+		t.Errorf("Expected no max expiry, got %v", metadata.MaxExpiresAt)
+	}
+}
@@ -0,0 +1,125 @@
+package model
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+// Version is an immutable view of a column family: its memtables and the SSTables of every level
+// Readers acquire a version with TryRef and release it with Unref, so lookups and disk I/O need no lock
+// Writers, flushes and compactions install a new version instead of changing one in use
+// Every version holds a reference to each of its SSTables; a table's file is deleted once
+// no version references it anymore
+type Version struct {
+	refs       atomic.Int32
+	active     *MemTable
+	immutables []*MemTable // oldest first
+	levels     map[int][]*SSTable
+	operator   MergeOperator
+}
+
+// NewVersion creates a version holding one reference for its creator
+// The slices are copied, so the caller may keep changing its own
+func NewVersion(active *MemTable, immutables []*MemTable, levels map[int][]*SSTable, operator MergeOperator) *Version {
+	v := &Version{
+		active:     active,
+		immutables: append([]*MemTable{}, immutables...),
+		levels:     make(map[int][]*SSTable, len(levels)),
+		operator:   operator,
+	}
+	for level, tables := range levels {
+		v.levels[level] = append([]*SSTable{}, tables...)
+		for _, table := range tables {
+			table.ref()
+		}
+	}
+	v.refs.Store(1)
+	return v
+}
+
+// TryRef adds a reference unless the version has already been released; returns false in that case
+func (v *Version) TryRef() bool {
+	for {
+		refs := v.refs.Load()
+		if refs <= 0 {
+			return false
+		}
+		if v.refs.CompareAndSwap(refs, refs+1) {
+			return true
+		}
+	}
+}
+
+// Unref releases a reference; the last one releases the version's SSTables
+func (v *Version) Unref() {
+	if v.refs.Add(-1) != 0 {
+		return
+	}
+	for _, tables := range v.levels {
+		for _, table := range tables {
+			table.unref()
+		}
+	}
+}
+
+// Lookup walks the memtables and SSTables from newest to oldest until the key's value is known
+func (v *Version) Lookup(key []byte) *ValueResolver {
+	return v.LookupAt(key, math.MaxUint64)
+}
+
+// LookupAt is Lookup ignoring memtable writes with a sequence number above sequence, such as those of
+// a batch still being applied; SSTables only ever hold writes that were visible when they were installed
+func (v *Version) LookupAt(key []byte, sequence uint64) *ValueResolver {
+	resolver := NewValueResolver(key, v.operator)
+
+	// Check active memtable first
+	if entry, err := v.active.GetAt(key, sequence); err == nil {
+		if resolver.Add(entry) {
+			return resolver
+		}
+	}
+
+	// Check immutable memtables in reverse order (newest first)
+	for i := len(v.immutables) - 1; i >= 0; i-- {
+		if entry, err := v.immutables[i].GetAt(key, sequence); err == nil {
+			if resolver.Add(entry) {
+				return resolver
+			}
+		}
+	}
+
+	// Level 0 tables may overlap in both keys and age (size-tiered compaction keeps every table there),
+	// so all level 0 versions are collected and visited newest first by sequence number
+	level0Tables := v.levels[0]
+	level0Entries := make([]*Entry, 0)
+	for i := len(level0Tables) - 1; i >= 0; i-- {
+		if entry, err := level0Tables[i].Get(key); err == nil && entry != nil {
+			level0Entries = append(level0Entries, entry)
+		}
+	}
+	sort.SliceStable(level0Entries, func(i, j int) bool {
+		return level0Entries[i].IsNewerThan(level0Entries[j])
+	})
+	for _, entry := range level0Entries {
+		if resolver.Add(entry) {
+			return resolver
+		}
+	}
+
+	// Check SSTables from level 1 upwards
+	for level := 1; level < 10; level++ { // Arbitrary max level
+		tables := v.levels[level]
+		// Tables within a level don't overlap, so we could use binary search here
+		for i := len(tables) - 1; i >= 0; i-- { // Check newest first
+			if entry, err := tables[i].Get(key); err == nil && entry != nil {
+				if resolver.Add(entry) {
+					return resolver
+				}
+			}
+		}
+	}
+
+	// Only merge operands (or nothing) were found
+	return resolver
+}
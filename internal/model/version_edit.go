@@ -0,0 +1,26 @@
+package model
+
+// VersionEdit describes a change to the SSTables of a column family
+// A compaction's inputs and all of its outputs, including those of every subcompaction,
+// are recorded in one edit so they are installed together
+type VersionEdit struct {
+	DeletedTables []*SSTable
+	AddedTables   []*SSTable
+	MovedTables   []*SSTable // reassigned to MoveLevel; their files are kept as they are
+	MoveLevel     int
+}
+
+// NewCompactionEdit returns the edit that replaces a task's inputs with its outputs
+// A trivial move only changes the level of its input
+func NewCompactionEdit(task *CompactionTask, outputs []*SSTable) *VersionEdit {
+	if task.CompactionType == TrivialMove {
+		return &VersionEdit{
+			MovedTables: task.InputSSTables,
+			MoveLevel:   task.OutputLevel,
+		}
+	}
+	return &VersionEdit{
+		DeletedTables: task.InputSSTables,
+		AddedTables:   outputs,
+	}
+}
@@ -0,0 +1,84 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVersionKeepsTablesUntilReleased(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "version_test")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	builder := NewSSTableBuilder(0, 1)
+	builder.AddEntry(NewPutEntry([]byte("key1"), []byte("value1")))
+	sst, err := builder.Build(tmpDir, "version.sst")
+	if err != nil {
+		t.Fatalf("Failed to build SSTable: %v", err)
+	}
+	path := filepath.Join(tmpDir, "version.sst")
+
+	memTable := NewMemTable(10)
+	oldVersion := NewVersion(memTable, nil, map[int][]*SSTable{0: {sst}}, nil)
+
+	// A reader holds the old version while the table is compacted away
+	if !oldVersion.TryRef() {
+		t.Fatal("Expected to reference the current version")
+	}
+	newVersion := NewVersion(memTable, nil, map[int][]*SSTable{}, nil)
+	oldVersion.Unref()
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Table file should survive while a reader uses it: %v", err)
+	}
+	value, err := oldVersion.Lookup([]byte("key1")).Value()
+	if err != nil || string(value) != "value1" {
+		t.Errorf("Expected value1 from the old version, got %s (err %v)", value, err)
+	}
+	if _, err := newVersion.Lookup([]byte("key1")).Value(); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound from the new version, got %v", err)
+	}
+
+	// The reader releases the old version: the file goes, and the version cannot be acquired again
+	oldVersion.Unref()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected table file to be removed, got %v", err)
+	}
+	if oldVersion.TryRef() {
+		t.Error("Expected TryRef to fail on a released version")
+	}
+}
+
+func TestVersionLookupOrder(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "version_lookup_test")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	older := NewPutEntry([]byte("key1"), []byte("sstable"))
+	older.SetSequence(1)
+	builder := NewSSTableBuilder(1, 1)
+	builder.AddEntry(older)
+	sst, err := builder.Build(tmpDir, "lookup.sst")
+	if err != nil {
+		t.Fatalf("Failed to build SSTable: %v", err)
+	}
+
+	immutable := NewMemTable(10)
+	immutable.Put([]byte("key1"), []byte("immutable"))
+	immutable.Put([]byte("key2"), []byte("immutable"))
+	immutable.SetReadOnly()
+	active := NewMemTable(10)
+	active.Put([]byte("key2"), []byte("active"))
+
+	version := NewVersion(active, []*MemTable{immutable}, map[int][]*SSTable{1: {sst}}, nil)
+	defer version.Unref()
+
+	tests := map[string]string{"key1": "immutable", "key2": "active"}
+	for key, expected := range tests {
+		value, err := version.Lookup([]byte(key)).Value()
+		if err != nil || string(value) != expected {
+			t.Errorf("%s: expected %s, got %s (err %v)", key, expected, value, err)
+		}
+	}
+}
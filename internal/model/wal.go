@@ -2,80 +2,173 @@ package model
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
 	"time"
 )
 
+var (
+	// errTornRecord marks a WAL record that was not completely written
+	errTornRecord = errors.New("torn WAL record")
+	// errStaleRecord marks bytes left in a recycled WAL file by the log that used it before
+	errStaleRecord = errors.New("stale WAL record")
+)
+
+// walRecordHeaderSize is the size of a record's payload length, checksum and log number
+const walRecordHeaderSize = 12
+
+// maxReusedWALBuffer is the largest record buffer kept for the next write
+const maxReusedWALBuffer = 64 * 1024
+
 // WAL represents a Write-Ahead Log
 // This is a domain service responsible for durability
+// Writes must not be called concurrently; the service serializes them
+// Every record carries the WAL's log number, so a recycled file can be overwritten in place:
+// the old log's records that follow the last live one are recognized as stale and ignored
 type WAL struct {
-	file   *os.File
-	writer *bufio.Writer
-	path   string
+	file      *os.File
+	writer    *bufio.Writer
+	path      string
+	logNumber uint32
+	buffer    []byte // encoding buffer reused across records
+}
+
+// WALOptions configures a WAL file
+type WALOptions struct {
+	LogNumber       uint32 // written into every record; records with another number are not part of this log
+	PreallocateSize int64  // bytes of disk space reserved when the file is opened; zero reserves nothing
 }
 
 // NewWAL creates a new WAL with the specified file path
 func NewWAL(dir, filename string) (*WAL, error) {
+	return NewWALWithOptions(dir, filename, WALOptions{})
+}
+
+// NewWALWithOptions opens the WAL file, creating it if needed; writes continue after its last live record
+func NewWALWithOptions(dir, filename string, options WALOptions) (*WAL, error) {
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create WAL directory: %w", err)
 	}
 
 	path := filepath.Join(dir, filename)
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	_, end, err := readLiveRecords(path, options.LogNumber)
+	if err != nil {
+		return nil, err
+	}
+	return openWAL(path, end, options)
+}
+
+// RecycleWAL reuses an obsolete WAL file for a new log instead of creating one
+// The file is renamed and overwritten from the start; its blocks are already allocated,
+// so syncing records written into it does not need to update the file system's metadata
+func RecycleWAL(dir, oldFilename, filename string, options WALOptions) (*WAL, error) {
+	path := filepath.Join(dir, filename)
+	if err := os.Rename(filepath.Join(dir, oldFilename), path); err != nil {
+		return nil, fmt.Errorf("failed to recycle WAL file: %w", err)
+	}
+	return openWAL(path, 0, options)
+}
+
+// openWAL opens the WAL file for writing at the given offset
+func openWAL(path string, offset int64, options WALOptions) (*WAL, error) {
+	// Not O_APPEND: a recycled file is written from the start, over the records of its previous log
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open WAL file: %w", err)
 	}
+	if options.PreallocateSize > 0 {
+		if err := preallocate(file, options.PreallocateSize); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to preallocate WAL file: %w", err)
+		}
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to seek WAL file: %w", err)
+	}
 
 	return &WAL{
-		file:   file,
-		writer: bufio.NewWriter(file),
-		path:   path,
+		file:      file,
+		writer:    bufio.NewWriter(file),
+		path:      path,
+		logNumber: options.LogNumber,
 	}, nil
 }
 
-// WriteEntry writes an entry to the WAL
+// WriteEntry writes an entry to the WAL as a single record
 func (w *WAL) WriteEntry(entry *Entry) error {
-	// Entry format: [keyLen][key][valueLen][value][entryType][timestamp]
+	return w.WriteEntries([]*Entry{entry})
+}
 
-	// Write key length and key
-	if err := binary.Write(w.writer, binary.LittleEndian, uint32(len(entry.key))); err != nil {
-		return fmt.Errorf("failed to write key length: %w", err)
-	}
-	if _, err := w.writer.Write(entry.key); err != nil {
-		return fmt.Errorf("failed to write key: %w", err)
+// WriteEntries writes entries to the WAL as one atomic record
+// Record format: [payloadLen][crc32(logNumber, payload)][logNumber][payload],
+// payload: [entryCount]([columnFamilyID][entry])*
+// A record that was only partially written is discarded as a whole on recovery
+func (w *WAL) WriteEntries(entries []*Entry) error {
+	// Encode into the reused buffer, leaving room for the length and checksum, which depend on the payload
+	record := w.buffer[:0]
+	record = binary.LittleEndian.AppendUint32(record, 0)
+	record = binary.LittleEndian.AppendUint32(record, 0)
+	record = binary.LittleEndian.AppendUint32(record, w.logNumber)
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(entries)))
+	for _, entry := range entries {
+		record = binary.LittleEndian.AppendUint32(record, entry.familyID)
+		record = appendEntry(record, entry)
 	}
 
-	// Write value length and value
-	if err := binary.Write(w.writer, binary.LittleEndian, uint32(len(entry.value))); err != nil {
-		return fmt.Errorf("failed to write value length: %w", err)
-	}
-	if _, err := w.writer.Write(entry.value); err != nil {
-		return fmt.Errorf("failed to write value: %w", err)
-	}
+	// Write record header; the checksum covers the log number too
+	payload := record[walRecordHeaderSize:]
+	binary.LittleEndian.PutUint32(record[0:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:], crc32.ChecksumIEEE(record[8:]))
 
-	// Write entry type
-	if err := binary.Write(w.writer, binary.LittleEndian, uint8(entry.entryType)); err != nil {
-		return fmt.Errorf("failed to write entry type: %w", err)
+	// Keep the buffer for the next record unless a large batch grew it
+	if cap(record) <= maxReusedWALBuffer {
+		w.buffer = record
+	} else {
+		w.buffer = nil
 	}
 
-	// Write timestamp (Unix nano)
-	if err := binary.Write(w.writer, binary.LittleEndian, entry.timestamp.UnixNano()); err != nil {
-		return fmt.Errorf("failed to write timestamp: %w", err)
+	if _, err := w.writer.Write(record); err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
 	}
-
 	return nil
 }
 
-// Flush flushes the buffered writes to disk
+// appendEntry appends the encoding of a single entry to a WAL record payload
+func appendEntry(buffer []byte, entry *Entry) []byte {
+	// Entry format: [keyLen][key][valueLen][value][entryType][timestamp][expiresAt][sequence]
+	buffer = binary.LittleEndian.AppendUint32(buffer, uint32(len(entry.key)))
+	buffer = append(buffer, entry.key...)
+	buffer = binary.LittleEndian.AppendUint32(buffer, uint32(len(entry.value)))
+	buffer = append(buffer, entry.value...)
+	buffer = append(buffer, uint8(entry.entryType))
+	buffer = binary.LittleEndian.AppendUint64(buffer, uint64(entry.timestamp.UnixNano()))
+	// Expiry time is 0 if the entry never expires
+	buffer = binary.LittleEndian.AppendUint64(buffer, uint64(expiryToUnixNano(entry.expiresAt)))
+	return binary.LittleEndian.AppendUint64(buffer, entry.seq)
+}
+
+// Flush hands the buffered records to the operating system; they survive a crash of the process,
+// but not of the machine
 func (w *WAL) Flush() error {
 	if err := w.writer.Flush(); err != nil {
 		return fmt.Errorf("failed to flush WAL buffer: %w", err)
 	}
-	if err := w.file.Sync(); err != nil {
+	return nil
+}
+
+// Sync flushes the buffered records and forces them to disk
+func (w *WAL) Sync() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := syncData(w.file); err != nil {
 		return fmt.Errorf("failed to sync WAL file: %w", err)
 	}
 	return nil
@@ -83,7 +176,7 @@ func (w *WAL) Flush() error {
 
 // Close closes the WAL file
 func (w *WAL) Close() error {
-	if err := w.Flush(); err != nil {
+	if err := w.Sync(); err != nil {
 		return err
 	}
 	return w.file.Close()
@@ -91,46 +184,119 @@ func (w *WAL) Close() error {
 
 // Recover reads entries from the WAL file and returns them
 func (w *WAL) Recover() ([]*Entry, error) {
-	// Close current file and reopen for reading
-	if err := w.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close WAL for recovery: %w", err)
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to flush WAL for recovery: %w", err)
 	}
 
-	file, err := os.Open(w.path)
+	entries, _, err := readLiveRecords(w.path, w.logNumber)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ReadWAL returns the entries of a WAL file written with the given log number, without opening it for writing
+func ReadWAL(dir, filename string, logNumber uint32) ([]*Entry, error) {
+	entries, _, err := readLiveRecords(filepath.Join(dir, filename), logNumber)
+	return entries, err
+}
+
+// readLiveRecords reads the records of the given log from the start of the file
+// Returns their entries and the offset after the last one, where the next record is written
+func readLiveRecords(path string, logNumber uint32) ([]*Entry, int64, error) {
+	file, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return []*Entry{}, nil // No WAL file exists, return empty slice
+			return []*Entry{}, 0, nil // No WAL file exists, return empty slice
 		}
-		return nil, fmt.Errorf("failed to open WAL for recovery: %w", err)
+		return nil, 0, fmt.Errorf("failed to open WAL for recovery: %w", err)
 	}
 	defer file.Close()
 
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to stat WAL: %w", err)
+	}
+
 	reader := bufio.NewReader(file)
 	var entries []*Entry
+	var offset int64
 
 	for {
-		entry, err := w.readEntry(reader)
-		if err == io.EOF {
+		recordEntries, size, err := readRecord(reader, logNumber, info.Size()-offset)
+		if err == io.EOF || err == errTornRecord || err == errStaleRecord {
+			// A torn record at the tail was never acknowledged, so it is dropped;
+			// what follows the live records of a recycled file belongs to its previous log
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to read entry from WAL: %w", err)
+			return nil, 0, fmt.Errorf("failed to read record from WAL: %w", err)
 		}
-		entries = append(entries, entry)
+		entries = append(entries, recordEntries...)
+		offset += size
 	}
 
-	// Reopen file for writing
-	w.file, err = os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		return nil, fmt.Errorf("failed to reopen WAL for writing: %w", err)
+	return entries, offset, nil
+}
+
+// readRecord reads a single record of the given log from the reader and returns its entries and size
+// remaining is the number of bytes left in the file, which bounds the payload of a live record
+func readRecord(reader *bufio.Reader, logNumber uint32, remaining int64) ([]*Entry, int64, error) {
+	// Read record header
+	header := make([]byte, walRecordHeaderSize)
+	if n, err := io.ReadFull(reader, header); err != nil {
+		if n > 0 {
+			return nil, 0, errTornRecord
+		}
+		return nil, 0, err
 	}
-	w.writer = bufio.NewWriter(w.file)
+	payloadLen := binary.LittleEndian.Uint32(header[0:])
+	checksum := binary.LittleEndian.Uint32(header[4:])
 
-	return entries, nil
+	// A payload always holds the entry count, so a zero length is preallocated space, not a record;
+	// a record of another log is left over from the file's previous use
+	if payloadLen == 0 || binary.LittleEndian.Uint32(header[8:]) != logNumber {
+		return nil, 0, errStaleRecord
+	}
+	if int64(payloadLen) > remaining-walRecordHeaderSize {
+		return nil, 0, errTornRecord
+	}
+
+	// Read and verify payload
+	payload := make([]byte, payloadLen)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, errTornRecord
+	}
+	crc := crc32.Update(crc32.ChecksumIEEE(header[8:]), crc32.IEEETable, payload)
+	if crc != checksum {
+		return nil, 0, errTornRecord
+	}
+
+	payloadReader := bytes.NewReader(payload)
+	var entryCount uint32
+	if err := binary.Read(payloadReader, binary.LittleEndian, &entryCount); err != nil {
+		return nil, 0, fmt.Errorf("failed to read entry count: %w", err)
+	}
+
+	entries := make([]*Entry, 0, entryCount)
+	for i := uint32(0); i < entryCount; i++ {
+		var familyID uint32
+		if err := binary.Read(payloadReader, binary.LittleEndian, &familyID); err != nil {
+			return nil, 0, fmt.Errorf("failed to read column family ID: %w", err)
+		}
+		entry, err := readWALEntry(payloadReader)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read entry: %w", err)
+		}
+		entry.familyID = familyID
+		entries = append(entries, entry)
+	}
+
+	return entries, walRecordHeaderSize + int64(payloadLen), nil
 }
 
-// readEntry reads a single entry from the reader
-func (w *WAL) readEntry(reader *bufio.Reader) (*Entry, error) {
+// readWALEntry reads a single entry from a record payload
+func readWALEntry(reader io.Reader) (*Entry, error) {
 	// Read key length
 	var keyLen uint32
 	if err := binary.Read(reader, binary.LittleEndian, &keyLen); err != nil {
@@ -167,11 +333,25 @@ func (w *WAL) readEntry(reader *bufio.Reader) (*Entry, error) {
 		return nil, err
 	}
 
+	// Read expiry time
+	var expiresAtNano int64
+	if err := binary.Read(reader, binary.LittleEndian, &expiresAtNano); err != nil {
+		return nil, err
+	}
+
+	// Read sequence number
+	var seq uint64
+	if err := binary.Read(reader, binary.LittleEndian, &seq); err != nil {
+		return nil, err
+	}
+
 	entry := &Entry{
 		key:       key,
 		value:     value,
 		entryType: EntryType(entryType),
 		timestamp: time.Unix(0, timestampNano),
+		expiresAt: expiryFromUnixNano(expiresAtNano),
+		seq:       seq,
 	}
 
 	return entry, nil
@@ -0,0 +1,28 @@
+//go:build linux
+
+package model
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE: the blocks are reserved but the file size stays,
+// so recovery still ends at the last written byte
+const fallocKeepSize = 0x01
+
+// preallocate reserves size bytes of disk space for the file
+// File systems without fallocate support get no reservation; the file then grows as it is written
+func preallocate(file *os.File, size int64) error {
+	err := syscall.Fallocate(int(file.Fd()), fallocKeepSize, 0, size)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return nil
+	}
+	return err
+}
+
+// syncData forces the file's data to disk, skipping metadata such as the modification time
+// that recovery does not need
+func syncData(file *os.File) error {
+	return syscall.Fdatasync(int(file.Fd()))
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package model
+
+import "os"
+
+// preallocate is a no-op where fallocate is not available; the file grows as it is written
+func preallocate(file *os.File, size int64) error {
+	return nil
+}
+
+// syncData forces the file to disk
+func syncData(file *os.File) error {
+	return file.Sync()
+}
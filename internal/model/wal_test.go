@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestWALWriteAndRecover(t *testing.T) {
@@ -161,3 +162,212 @@ func TestWALPersistence(t *testing.T) {
 		t.Errorf("Expected key 'persistent_key', got '%s'", entries[0].Key())
 	}
 }
+
+func TestWALRecoverTTL(t *testing.T) {
+	// Create temporary directory for test
+	tmpDir := filepath.Join(os.TempDir(), "wal_test_ttl")
+	defer os.RemoveAll(tmpDir)
+
+	wal, err := NewWAL(tmpDir, "ttl.wal")
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer wal.Close()
+
+	ttlEntry := NewPutEntryWithTTL([]byte("session"), []byte("data"), time.Hour)
+	ttlEntry.SetSequence(41)
+	plainEntry := NewPutEntry([]byte("user"), []byte("alice"))
+	plainEntry.SetSequence(42)
+
+	for _, entry := range []*Entry{ttlEntry, plainEntry} {
+		if err := wal.WriteEntry(entry); err != nil {
+			t.Fatalf("Failed to write entry: %v", err)
+		}
+	}
+
+	entries, err := wal.Recover()
+	if err != nil {
+		t.Fatalf("Failed to recover entries: %v", err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+
+	if !entries[0].ExpiresAt().Equal(ttlEntry.ExpiresAt()) {
+		t.Errorf("Expected expiry %v, got %v", ttlEntry.ExpiresAt(), entries[0].ExpiresAt())
+	}
+
+	if entries[1].HasTTL() {
+		t.Error("Expected entry without TTL to be recovered without expiry")
+	}
+
+	for i, expected := range []uint64{41, 42} {
+		if entries[i].Sequence() != expected {
+			t.Errorf("Entry %d: expected sequence %d, got %d", i, expected, entries[i].Sequence())
+		}
+	}
+}
+
+func TestWALDropsTornRecord(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "wal_test_torn")
+	defer os.RemoveAll(tmpDir)
+
+	wal, err := NewWAL(tmpDir, "torn.wal")
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+
+	if err := wal.WriteEntry(NewPutEntry([]byte("committed"), []byte("value"))); err != nil {
+		t.Fatalf("Failed to write entry: %v", err)
+	}
+	batch := []*Entry{
+		NewPutEntry([]byte("batch1"), []byte("value1")),
+		NewPutEntry([]byte("batch2"), []byte("value2")),
+	}
+	if err := wal.WriteEntries(batch); err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatalf("Failed to close WAL: %v", err)
+	}
+
+	// Simulate a crash in the middle of writing the batch record
+	path := filepath.Join(tmpDir, "torn.wal")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat WAL: %v", err)
+	}
+	if err := os.Truncate(path, info.Size()-5); err != nil {
+		t.Fatalf("Failed to truncate WAL: %v", err)
+	}
+
+	wal, err = NewWAL(tmpDir, "torn.wal")
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer wal.Close()
+
+	entries, err := wal.Recover()
+	if err != nil {
+		t.Fatalf("Failed to recover entries: %v", err)
+	}
+
+	// The whole batch is dropped, the earlier record survives
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+	if string(entries[0].Key()) != "committed" {
+		t.Errorf("Expected key committed, got %s", entries[0].Key())
+	}
+}
+
+func TestWALRecycleIgnoresStaleRecords(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "wal_test_recycle")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	// The old log holds more records than the new one will overwrite, all of the same size,
+	// so the stale records stay intact right after the live ones
+	oldWAL, err := NewWALWithOptions(tmpDir, "wal_1.log", WALOptions{LogNumber: 1, PreallocateSize: 64 * 1024})
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	for _, key := range []string{"old1", "old2", "old3"} {
+		if err := oldWAL.WriteEntry(NewPutEntry([]byte(key), []byte("stale"))); err != nil {
+			t.Fatalf("Failed to write entry: %v", err)
+		}
+	}
+	if err := oldWAL.Close(); err != nil {
+		t.Fatalf("Failed to close WAL: %v", err)
+	}
+
+	// Preallocation keeps the file size, so readers still stop at the last record
+	info, err := os.Stat(filepath.Join(tmpDir, "wal_1.log"))
+	if err != nil {
+		t.Fatalf("Failed to stat WAL: %v", err)
+	}
+	if info.Size() >= 64*1024 {
+		t.Errorf("Expected preallocation to keep the file size, got %d bytes", info.Size())
+	}
+
+	wal, err := RecycleWAL(tmpDir, "wal_1.log", "wal_2.log", WALOptions{LogNumber: 2})
+	if err != nil {
+		t.Fatalf("Failed to recycle WAL: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "wal_1.log")); !os.IsNotExist(err) {
+		t.Errorf("Expected the old file to be renamed, got %v", err)
+	}
+	if err := wal.WriteEntry(NewPutEntry([]byte("new1"), []byte("fresh"))); err != nil {
+		t.Fatalf("Failed to write entry: %v", err)
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatalf("Failed to close WAL: %v", err)
+	}
+
+	// Reopening continues after the live record instead of after the stale ones
+	wal, err = NewWALWithOptions(tmpDir, "wal_2.log", WALOptions{LogNumber: 2})
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer wal.Close()
+	if err := wal.WriteEntry(NewPutEntry([]byte("new2"), []byte("fresh"))); err != nil {
+		t.Fatalf("Failed to write entry: %v", err)
+	}
+
+	entries, err := wal.Recover()
+	if err != nil {
+		t.Fatalf("Failed to recover entries: %v", err)
+	}
+	var keys []string
+	for _, entry := range entries {
+		keys = append(keys, string(entry.Key()))
+	}
+	if len(keys) != 2 || keys[0] != "new1" || keys[1] != "new2" {
+		t.Errorf("Expected only the new log's records [new1 new2], got %v", keys)
+	}
+}
+
+func TestWALIgnoresPreallocatedZeros(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "wal_test_zeros")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	wal, err := NewWAL(tmpDir, "zeros.wal")
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	if err := wal.WriteEntry(NewPutEntry([]byte("key1"), []byte("value1"))); err != nil {
+		t.Fatalf("Failed to write entry: %v", err)
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatalf("Failed to close WAL: %v", err)
+	}
+
+	// Space preallocated by extending the file reads back as zeros
+	path := filepath.Join(tmpDir, "zeros.wal")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat WAL: %v", err)
+	}
+	if err := os.Truncate(path, info.Size()+4096); err != nil {
+		t.Fatalf("Failed to extend WAL: %v", err)
+	}
+
+	wal, err = NewWAL(tmpDir, "zeros.wal")
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer wal.Close()
+	if err := wal.WriteEntry(NewPutEntry([]byte("key2"), []byte("value2"))); err != nil {
+		t.Fatalf("Failed to write entry: %v", err)
+	}
+
+	entries, err := wal.Recover()
+	if err != nil {
+		t.Fatalf("Failed to recover entries: %v", err)
+	}
+	if len(entries) != 2 || string(entries[1].Key()) != "key2" {
+		t.Fatalf("Expected both records before the zeros, got %d entries", len(entries))
+	}
+}
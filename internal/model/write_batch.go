@@ -0,0 +1,77 @@
+package model
+
+import (
+	"time"
+)
+
+// WriteBatch collects writes that are logged as one WAL record and applied atomically
+// Writes may target different column families; the batch stays atomic across them
+type WriteBatch struct {
+	entries  []*Entry
+	families []string // column family name per entry ("" for the default family)
+}
+
+// NewWriteBatch creates an empty write batch
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{
+		entries:  make([]*Entry, 0),
+		families: make([]string, 0),
+	}
+}
+
+// Put adds a key-value pair to the batch
+func (b *WriteBatch) Put(key, value []byte) {
+	b.add("", NewPutEntry(key, value))
+}
+
+// PutCF adds a key-value pair for the given column family to the batch
+func (b *WriteBatch) PutCF(family string, key, value []byte) {
+	b.add(family, NewPutEntry(key, value))
+}
+
+// PutWithTTL adds a key-value pair that expires after the given TTL to the batch
+func (b *WriteBatch) PutWithTTL(key, value []byte, ttl time.Duration) {
+	b.add("", NewPutEntryWithTTL(key, value, ttl))
+}
+
+// Delete adds a tombstone for the key to the batch
+func (b *WriteBatch) Delete(key []byte) {
+	b.add("", NewDeleteEntry(key))
+}
+
+// DeleteCF adds a tombstone for the key in the given column family to the batch
+func (b *WriteBatch) DeleteCF(family string, key []byte) {
+	b.add(family, NewDeleteEntry(key))
+}
+
+// Merge adds a merge operand for the key to the batch
+func (b *WriteBatch) Merge(key, operand []byte) {
+	b.add("", NewMergeEntry(key, operand))
+}
+
+// add appends an entry destined for the given column family
+func (b *WriteBatch) add(family string, entry *Entry) {
+	b.entries = append(b.entries, entry)
+	b.families = append(b.families, family)
+}
+
+// Entries returns the entries of the batch in insertion order
+func (b *WriteBatch) Entries() []*Entry {
+	return b.entries
+}
+
+// ColumnFamilies returns the column family name of each entry, aligned with Entries ("" for the default family)
+func (b *WriteBatch) ColumnFamilies() []string {
+	return b.families
+}
+
+// Count returns the number of writes in the batch
+func (b *WriteBatch) Count() int {
+	return len(b.entries)
+}
+
+// Clear removes all writes from the batch
+func (b *WriteBatch) Clear() {
+	b.entries = b.entries[:0]
+	b.families = b.families[:0]
+}
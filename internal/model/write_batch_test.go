@@ -0,0 +1,35 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWriteBatch(t *testing.T) {
+	batch := NewWriteBatch()
+
+	batch.Put([]byte("key1"), []byte("value1"))
+	batch.PutWithTTL([]byte("key2"), []byte("value2"), time.Hour)
+	batch.Delete([]byte("key3"))
+	batch.Merge([]byte("key4"), []byte("1"))
+
+	if batch.Count() != 4 {
+		t.Fatalf("Expected 4 writes, got %d", batch.Count())
+	}
+
+	expectedTypes := []EntryType{EntryTypePut, EntryTypePut, EntryTypeDelete, EntryTypeMerge}
+	for i, entry := range batch.Entries() {
+		if entry.Type() != expectedTypes[i] {
+			t.Errorf("Entry %d: expected type %v, got %v", i, expectedTypes[i], entry.Type())
+		}
+	}
+
+	if !batch.Entries()[1].HasTTL() {
+		t.Error("Expected TTL entry to carry its expiry")
+	}
+
+	batch.Clear()
+	if batch.Count() != 0 {
+		t.Errorf("Expected empty batch after clear, got %d", batch.Count())
+	}
+}
@@ -0,0 +1,61 @@
+package model
+
+import "sync/atomic"
+
+// WriteBufferManager caps the memory of all memtables of a service, across column families
+// Memtables reserve memory as entries are added and free it once they have been flushed
+type WriteBufferManager struct {
+	bufferSize    int64
+	memoryUsage   atomic.Int64 // all memtables not yet flushed
+	mutableMemory atomic.Int64 // active memtables only
+}
+
+// NewWriteBufferManager creates a manager allowing bufferSize bytes of memtables; zero means unlimited
+func NewWriteBufferManager(bufferSize int64) *WriteBufferManager {
+	return &WriteBufferManager{bufferSize: bufferSize}
+}
+
+// BufferSize returns the memory limit; zero means unlimited
+func (m *WriteBufferManager) BufferSize() int64 {
+	return m.bufferSize
+}
+
+// MemoryUsage returns the bytes held by memtables that have not been flushed yet
+func (m *WriteBufferManager) MemoryUsage() int64 {
+	return m.memoryUsage.Load()
+}
+
+// ShouldFlush reports whether an active memtable should be switched to free memory
+// Active memtables may use 7/8 of the budget; once the budget is exhausted, flushing
+// continues while they still hold half of it
+func (m *WriteBufferManager) ShouldFlush() bool {
+	if m.bufferSize <= 0 {
+		return false
+	}
+	mutable := m.mutableMemory.Load()
+	if mutable >= m.bufferSize*7/8 {
+		return true
+	}
+	return m.memoryUsage.Load() >= m.bufferSize && mutable >= m.bufferSize/2
+}
+
+// ShouldStall reports whether memtables use the whole budget, so writes must wait for flushes
+func (m *WriteBufferManager) ShouldStall() bool {
+	return m.bufferSize > 0 && m.memoryUsage.Load() >= m.bufferSize
+}
+
+// reserve accounts for memory added to an active memtable
+func (m *WriteBufferManager) reserve(bytes int64) {
+	m.memoryUsage.Add(bytes)
+	m.mutableMemory.Add(bytes)
+}
+
+// markImmutable moves a memtable's memory from the active to the immutable share
+func (m *WriteBufferManager) markImmutable(bytes int64) {
+	m.mutableMemory.Add(-bytes)
+}
+
+// free releases the memory of a flushed memtable
+func (m *WriteBufferManager) free(bytes int64) {
+	m.memoryUsage.Add(-bytes)
+}
@@ -0,0 +1,56 @@
+package model
+
+import "testing"
+
+func TestWriteBufferManager(t *testing.T) {
+	manager := NewWriteBufferManager(8000)
+
+	active := NewMemTable(0)
+	active.SetWriteBufferManager(manager)
+	for i := 0; i < 10; i++ {
+		active.Put([]byte{byte(i)}, make([]byte, 500-memTableEntryOverhead-1))
+	}
+	if manager.MemoryUsage() != 5000 || manager.ShouldFlush() {
+		t.Fatalf("Expected 5000 bytes without flush, got %d (flush %v)", manager.MemoryUsage(), manager.ShouldFlush())
+	}
+
+	// Active memtables may use 7/8 of the buffer
+	for i := 10; i < 14; i++ {
+		active.Put([]byte{byte(i)}, make([]byte, 500-memTableEntryOverhead-1))
+	}
+	if !manager.ShouldFlush() || manager.ShouldStall() {
+		t.Fatalf("Expected flush without stall at %d bytes", manager.MemoryUsage())
+	}
+
+	// Once switched, the memory stays accounted until the flush, but no longer asks for one
+	active.SetReadOnly()
+	if manager.ShouldFlush() {
+		t.Error("Expected no flush request for immutable memory")
+	}
+
+	next := NewMemTable(0)
+	next.SetWriteBufferManager(manager)
+	for i := 0; i < 2; i++ {
+		next.Put([]byte{byte(i)}, make([]byte, 500-memTableEntryOverhead-1))
+	}
+	if !manager.ShouldStall() {
+		t.Errorf("Expected stall at %d bytes", manager.MemoryUsage())
+	}
+
+	active.Release()
+	active.Release()
+	if manager.MemoryUsage() != 1000 || manager.ShouldStall() {
+		t.Errorf("Expected 1000 bytes after the flush, got %d", manager.MemoryUsage())
+	}
+}
+
+func TestWriteBufferManagerUnlimited(t *testing.T) {
+	manager := NewWriteBufferManager(0)
+
+	table := NewMemTable(0)
+	table.SetWriteBufferManager(manager)
+	table.Put([]byte("key"), make([]byte, 1<<20))
+	if manager.ShouldFlush() || manager.ShouldStall() {
+		t.Error("Expected an unlimited manager never to flush or stall")
+	}
+}
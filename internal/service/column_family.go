@@ -0,0 +1,303 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Bloom0716/mini-bigtable/internal/model"
+)
+
+// DefaultColumnFamily is the name of the column family used by the non-CF APIs
+const DefaultColumnFamily = "default"
+
+var (
+	ErrColumnFamilyExists   = errors.New("column family already exists")
+	ErrColumnFamilyNotFound = errors.New("column family not found")
+)
+
+// ColumnFamilyOptions configures a column family
+type ColumnFamilyOptions struct {
+	MaxTableSize       int // maximum number of entries in a memtable; zero uses the service's default
+	WriteBufferSize    int // approximate bytes a memtable may hold; zero uses the service's default
+	CompactionStrategy model.CompactionStrategy
+	DynamicLevelBytes  bool                    // leveled compaction only: derive level targets from the last level's size
+	FilePicking        model.FilePickingPolicy // leveled compaction only: which table of a level to move down
+}
+
+// columnFamily is a logically separate keyspace with its own memtables, levels and compaction
+// All column families share the service's WAL and sequence numbers
+type columnFamily struct {
+	id              uint32
+	name            string
+	options         ColumnFamilyOptions
+	activeTable     *model.MemTable
+	immutableTables []*model.MemTable
+	sstablesByLevel map[int][]*model.SSTable
+	current         atomic.Pointer[model.Version] // memtables and levels as readers see them
+	// pendingCompactionBytes is computed when a version is installed, so writes checking for stalls need not
+	pendingCompactionBytes uint64
+	sstableDir             string
+	compactionManager      *model.CompactionManager
+}
+
+// ColumnFamilyStats holds statistics about a single column family
+type ColumnFamilyStats struct {
+	Name                string
+	ActiveMemTableSize  int
+	ActiveMemTableBytes int // approximate memory used by the active memtable
+	ImmutableCount      int
+	SSTableStats        map[int]int
+}
+
+// addColumnFamily registers a column family and creates its active memtable (caller must hold s.mu)
+func (s *LSMTableService) addColumnFamily(id uint32, name string, options ColumnFamilyOptions) *columnFamily {
+	if options.MaxTableSize <= 0 {
+		options.MaxTableSize = s.maxTableSize
+	}
+	if options.WriteBufferSize <= 0 {
+		options.WriteBufferSize = s.writeBufferSize
+	}
+
+	// The default family keeps the original SSTable directory; the others get a subdirectory each
+	sstableDir := s.sstableDir
+	if id != 0 {
+		sstableDir = filepath.Join(s.sstableDir, name)
+	}
+
+	compactionManager := model.NewCompactionManager(options.CompactionStrategy)
+	compactionManager.SetDynamicLevelBytes(options.DynamicLevelBytes)
+	compactionManager.SetFilePickingPolicy(options.FilePicking)
+	compactionManager.SetCompactPointers(s.manifest.CompactPointers[id])
+	compactionManager.SetMergeOperator(s.mergeOperator)
+	compactionManager.SetRateLimiter(s.rateLimiter)
+	if s.maxSubcompactions > 0 {
+		compactionManager.SetMaxSubcompactions(s.maxSubcompactions)
+	}
+
+	cf := &columnFamily{
+		id:                id,
+		name:              name,
+		options:           options,
+		immutableTables:   make([]*model.MemTable, 0),
+		sstablesByLevel:   make(map[int][]*model.SSTable),
+		sstableDir:        sstableDir,
+		compactionManager: compactionManager,
+	}
+	cf.activeTable = s.newMemTable(cf)
+	s.installVersion(cf)
+
+	s.familiesMu.Lock()
+	defer s.familiesMu.Unlock()
+	s.families[name] = cf
+	s.familiesByID[id] = cf
+	return cf
+}
+
+// installVersion publishes the family's memtables and levels as its current version (caller must hold s.mu)
+// Readers still using the previous version keep it, and its SSTables, until they release it
+func (s *LSMTableService) installVersion(cf *columnFamily) {
+	version := model.NewVersion(cf.activeTable, cf.immutableTables, cf.sstablesByLevel, s.mergeOperator)
+	cf.pendingCompactionBytes = cf.compactionManager.PendingCompactionBytes(cf.sstablesByLevel)
+	if previous := cf.current.Swap(version); previous != nil {
+		previous.Unref()
+	}
+}
+
+// acquireVersion returns the family's current version; the caller must release it with Unref
+func (cf *columnFamily) acquireVersion() *model.Version {
+	for {
+		// A version replaced in the meantime may already be released; then retry with the new one
+		if version := cf.current.Load(); version.TryRef() {
+			return version
+		}
+	}
+}
+
+// acquireReadVersion returns the family's current version for a reader that does not hold s.mu, together with
+// the newest sequence number it may see; the caller must release the version with Unref
+// Writes of a batch still being applied are newer than that, so the reader sees all of a batch or none of it
+func (s *LSMTableService) acquireReadVersion(cf *columnFamily) (*model.Version, uint64) {
+	for {
+		version := cf.acquireVersion()
+		sequence := s.visibleSequence.Load()
+		// A batch that switched memtables may have been published meanwhile, with part of it
+		// only in a newer version's memtable
+		if cf.current.Load() == version {
+			return version, sequence
+		}
+		version.Unref()
+	}
+}
+
+// CreateColumnFamily creates a new column family and records it in the manifest
+func (s *LSMTableService) CreateColumnFamily(name string, options ColumnFamilyOptions) error {
+	if err := validateColumnFamilyName(name); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.families[name]; ok {
+		return ErrColumnFamilyExists
+	}
+
+	maxTableSize := options.MaxTableSize
+	if maxTableSize <= 0 {
+		maxTableSize = s.maxTableSize
+	}
+	writeBufferSize := options.WriteBufferSize
+	if writeBufferSize <= 0 {
+		writeBufferSize = s.writeBufferSize
+	}
+	descriptor := s.manifest.AddColumnFamily(model.ColumnFamilyDescriptor{
+		Name:               name,
+		MaxTableSize:       maxTableSize,
+		WriteBufferSize:    writeBufferSize,
+		CompactionStrategy: options.CompactionStrategy.String(),
+		DynamicLevelBytes:  options.DynamicLevelBytes,
+		FilePicking:        options.FilePicking.String(),
+	})
+	if err := s.manifest.Save(); err != nil {
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
+
+	s.addColumnFamily(descriptor.ID, name, options)
+	return nil
+}
+
+// ListColumnFamilies returns the names of all column families in creation order
+func (s *LSMTableService) ListColumnFamilies() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	families := make([]*columnFamily, 0, len(s.families))
+	for _, cf := range s.families {
+		families = append(families, cf)
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].id < families[j].id
+	})
+
+	names := make([]string, 0, len(families))
+	for _, cf := range families {
+		names = append(names, cf.name)
+	}
+	return names
+}
+
+// PutCF adds a key-value pair to the given column family
+func (s *LSMTableService) PutCF(family string, key, value []byte) error {
+	return s.PutCFWithOptions(family, key, value, 0, DefaultWriteOptions())
+}
+
+// PutCFWithOptions is PutCF with a TTL (zero never expires) and the given durability
+func (s *LSMTableService) PutCFWithOptions(family string, key, value []byte, ttl time.Duration, options WriteOptions) error {
+	entry, err := newPutEntry(key, value, ttl)
+	if err != nil {
+		return err
+	}
+	if err := s.throttleWrites(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cf, err := s.columnFamily(family)
+	if err != nil {
+		return err
+	}
+	return s.writeEntry(cf, entry, options)
+}
+
+// GetCF retrieves a value for the given key from the given column family
+func (s *LSMTableService) GetCF(family string, key []byte) ([]byte, error) {
+	s.familiesMu.RLock()
+	cf, err := s.columnFamily(family)
+	s.familiesMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	version, sequence := s.acquireReadVersion(cf)
+	defer version.Unref()
+	return version.LookupAt(key, sequence).Value()
+}
+
+// DeleteCF marks a key as deleted in the given column family
+func (s *LSMTableService) DeleteCF(family string, key []byte) error {
+	return s.DeleteCFWithOptions(family, key, DefaultWriteOptions())
+}
+
+// DeleteCFWithOptions marks a key as deleted in the given column family with the given durability
+func (s *LSMTableService) DeleteCFWithOptions(family string, key []byte, options WriteOptions) error {
+	if err := s.throttleWrites(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cf, err := s.columnFamily(family)
+	if err != nil {
+		return err
+	}
+	return s.writeEntry(cf, model.NewDeleteEntry(key), options)
+}
+
+// GetColumnFamilyStats returns statistics about the given column family
+func (s *LSMTableService) GetColumnFamilyStats(family string) (*ColumnFamilyStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cf, err := s.columnFamily(family)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ColumnFamilyStats{
+		Name:                cf.name,
+		ActiveMemTableSize:  cf.activeTable.Size(),
+		ActiveMemTableBytes: cf.activeTable.MemoryUsage(),
+		ImmutableCount:      len(cf.immutableTables),
+		SSTableStats:        cf.sstableStats(),
+	}, nil
+}
+
+// columnFamily looks up a column family by name; "" means the default family (caller must hold s.mu or s.familiesMu)
+func (s *LSMTableService) columnFamily(name string) (*columnFamily, error) {
+	if name == "" {
+		name = DefaultColumnFamily
+	}
+	cf, ok := s.families[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrColumnFamilyNotFound, name)
+	}
+	return cf, nil
+}
+
+// sstableStats returns the number of SSTables per level
+func (cf *columnFamily) sstableStats() map[int]int {
+	stats := make(map[int]int)
+	for level, tables := range cf.sstablesByLevel {
+		stats[level] = len(tables)
+	}
+	return stats
+}
+
+// validateColumnFamilyName checks that a name can be used as an SSTable subdirectory
+func validateColumnFamilyName(name string) error {
+	if name == "" {
+		return fmt.Errorf("column family name cannot be empty")
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid column family name %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Bloom0716/mini-bigtable/internal/model"
+)
+
+func TestLSMTableServiceColumnFamilies(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_column_families")
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 10)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	if err := service.CreateColumnFamily("users", ColumnFamilyOptions{MaxTableSize: 2}); err != nil {
+		t.Fatalf("Failed to create column family: %v", err)
+	}
+	if err := service.CreateColumnFamily("users", ColumnFamilyOptions{}); !errors.Is(err, ErrColumnFamilyExists) {
+		t.Errorf("Expected ErrColumnFamilyExists, got %v", err)
+	}
+	if err := service.CreateColumnFamily("../escape", ColumnFamilyOptions{}); err == nil {
+		t.Error("Expected invalid column family name to be rejected")
+	}
+
+	families := service.ListColumnFamilies()
+	if len(families) != 2 || families[0] != DefaultColumnFamily || families[1] != "users" {
+		t.Errorf("Unexpected column families: %v", families)
+	}
+
+	// The same key is independent in each family
+	if err := service.Put([]byte("key"), []byte("default value")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := service.PutCF("users", []byte("key"), []byte("users value")); err != nil {
+		t.Fatalf("Failed to put to column family: %v", err)
+	}
+
+	value, err := service.Get([]byte("key"))
+	if err != nil || string(value) != "default value" {
+		t.Errorf("Expected default value, got %s (err %v)", value, err)
+	}
+	value, err = service.GetCF("users", []byte("key"))
+	if err != nil || string(value) != "users value" {
+		t.Errorf("Expected users value, got %s (err %v)", value, err)
+	}
+
+	if err := service.DeleteCF("users", []byte("key")); err != nil {
+		t.Fatalf("Failed to delete from column family: %v", err)
+	}
+	if _, err := service.GetCF("users", []byte("key")); err != model.ErrKeyNotFound {
+		t.Errorf("Expected key to be deleted from users, got %v", err)
+	}
+	if _, err := service.Get([]byte("key")); err != nil {
+		t.Errorf("Expected key to remain in default family, got %v", err)
+	}
+
+	if _, err := service.GetCF("missing", []byte("key")); !errors.Is(err, ErrColumnFamilyNotFound) {
+		t.Errorf("Expected ErrColumnFamilyNotFound, got %v", err)
+	}
+
+	// Each family rotates its memtable according to its own size: users (size 2) rotates on "b"
+	for _, key := range []string{"a", "b", "c"} {
+		if err := service.PutCF("users", []byte(key), []byte(key)); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	stats, err := service.GetColumnFamilyStats("users")
+	if err != nil {
+		t.Fatalf("Failed to get column family stats: %v", err)
+	}
+	if stats.ActiveMemTableSize != 2 {
+		t.Errorf("Expected users active memtable size 2, got %d", stats.ActiveMemTableSize)
+	}
+	if activeSize, _ := service.GetMemTableStats(); activeSize != 1 {
+		t.Errorf("Expected default active memtable size 1, got %d", activeSize)
+	}
+}
+
+func TestLSMTableServiceColumnFamilyBatchRecovery(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_column_family_recovery")
+	defer os.RemoveAll(tmpDir)
+
+	service1, err := NewLSMTableService(tmpDir, 10)
+	if err != nil {
+		t.Fatalf("Failed to create first LSM service: %v", err)
+	}
+	if err := service1.CreateColumnFamily("events", ColumnFamilyOptions{CompactionStrategy: model.SizeTieredCompaction}); err != nil {
+		t.Fatalf("Failed to create column family: %v", err)
+	}
+
+	// One batch spans both families
+	batch := model.NewWriteBatch()
+	batch.Put([]byte("user:1"), []byte("Alice"))
+	batch.PutCF("events", []byte("event:1"), []byte("login"))
+	batch.DeleteCF("events", []byte("event:0"))
+	if err := service1.Write(batch); err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+
+	// A batch naming an unknown family is rejected as a whole
+	badBatch := model.NewWriteBatch()
+	badBatch.Put([]byte("user:2"), []byte("Bob"))
+	badBatch.PutCF("missing", []byte("key"), []byte("value"))
+	if err := service1.Write(badBatch); !errors.Is(err, ErrColumnFamilyNotFound) {
+		t.Errorf("Expected ErrColumnFamilyNotFound, got %v", err)
+	}
+	if _, err := service1.Get([]byte("user:2")); err != model.ErrKeyNotFound {
+		t.Errorf("Expected rejected batch to write nothing, got %v", err)
+	}
+
+	if err := service1.Close(); err != nil {
+		t.Fatalf("Failed to close first service: %v", err)
+	}
+
+	// The family is reopened from the manifest and WAL entries are routed back to it on startup
+	service2, err := NewLSMTableService(tmpDir, 10)
+	if err != nil {
+		t.Fatalf("Failed to create second LSM service: %v", err)
+	}
+	defer service2.Close()
+
+	value, err := service2.Get([]byte("user:1"))
+	if err != nil || string(value) != "Alice" {
+		t.Errorf("Expected Alice in default family, got %s (err %v)", value, err)
+	}
+	value, err = service2.GetCF("events", []byte("event:1"))
+	if err != nil || string(value) != "login" {
+		t.Errorf("Expected login in events family, got %s (err %v)", value, err)
+	}
+	if _, err := service2.Get([]byte("event:1")); err != model.ErrKeyNotFound {
+		t.Errorf("Expected event:1 to be absent from default family, got %v", err)
+	}
+}
+
+func TestLSMTableServiceTieredColumnFamilies(t *testing.T) {
+	for _, strategy := range []model.CompactionStrategy{model.SizeTieredCompaction, model.UniversalCompaction, model.TimeWindowCompaction} {
+		t.Run(strategy.String(), func(t *testing.T) {
+			testTieredColumnFamily(t, strategy)
+		})
+	}
+}
+
+// testTieredColumnFamily checks reads across flushes and compactions of a strategy that keeps every table in level 0
+func testTieredColumnFamily(t *testing.T, strategy model.CompactionStrategy) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_tiered_"+strategy.String())
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 10)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	if err := service.CreateColumnFamily("events", ColumnFamilyOptions{
+		MaxTableSize:       2,
+		CompactionStrategy: strategy,
+	}); err != nil {
+		t.Fatalf("Failed to create column family: %v", err)
+	}
+
+	// Overwrite and delete keys across many flushes and tier compactions
+	for round := 0; round < 10; round++ {
+		for _, key := range []string{"a", "b"} {
+			if err := service.PutCF("events", []byte(key), []byte(fmt.Sprintf("%s%d", key, round))); err != nil {
+				t.Fatalf("Failed to put %s: %v", key, err)
+			}
+		}
+		if err := service.DeleteCF("events", []byte("b")); err != nil {
+			t.Fatalf("Failed to delete b: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	value, err := service.GetCF("events", []byte("a"))
+	if err != nil || string(value) != "a9" {
+		t.Errorf("Expected a9, got %s (err %v)", value, err)
+	}
+	if _, err := service.GetCF("events", []byte("b")); err != model.ErrKeyNotFound {
+		t.Errorf("Expected b to be deleted, got %v", err)
+	}
+
+	// Tiered strategies keep every table in level 0
+	stats, err := service.GetColumnFamilyStats("events")
+	if err != nil {
+		t.Fatalf("Failed to get column family stats: %v", err)
+	}
+	for level, count := range stats.SSTableStats {
+		if level != 0 && count > 0 {
+			t.Errorf("Expected no tables outside level 0, found %d in level %d", count, level)
+		}
+	}
+}
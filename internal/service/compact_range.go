@@ -0,0 +1,158 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Bloom0716/mini-bigtable/internal/model"
+)
+
+// ErrCompactionCancelled is returned when the service closes while a manual compaction is running
+var ErrCompactionCancelled = errors.New("compaction cancelled: service is closing")
+
+// CompactRange compacts the default column family's tables holding keys in [start, end] level by level
+// down to the bottom level, purging deleted and expired entries; nil bounds are unbounded
+// It returns once the compaction has finished
+func (s *LSMTableService) CompactRange(start, end []byte) error {
+	return s.CompactRangeCF(DefaultColumnFamily, start, end)
+}
+
+// CompactRangeCF compacts a key range of the given column family and waits for it to finish
+func (s *LSMTableService) CompactRangeCF(family string, start, end []byte) error {
+	cf, mc, err := s.startManualCompaction(family, start, end)
+	if err != nil {
+		return err
+	}
+	return s.runManualCompaction(cf, mc)
+}
+
+// StartCompactRangeCF starts compacting a key range of the given column family and returns immediately
+// Progress is reported by ManualCompactionProgress
+func (s *LSMTableService) StartCompactRangeCF(family string, start, end []byte) error {
+	cf, mc, err := s.startManualCompaction(family, start, end)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := s.runManualCompaction(cf, mc); err != nil {
+			fmt.Printf("Manual compaction failed: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// ManualCompactionProgress returns the progress of the most recently started manual compaction
+// ok is false if none has been started
+func (s *LSMTableService) ManualCompactionProgress() (progress model.ManualCompactionProgress, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.manualCompaction == nil {
+		return model.ManualCompactionProgress{}, false
+	}
+	return s.manualCompaction.Progress(), true
+}
+
+// startManualCompaction registers a manual compaction; the caller must then call runManualCompaction
+func (s *LSMTableService) startManualCompaction(family string, start, end []byte) (*columnFamily, *model.ManualCompaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.backgroundStop:
+		return nil, nil, ErrCompactionCancelled
+	default:
+	}
+
+	cf, err := s.columnFamily(family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mc := model.NewManualCompaction(start, end)
+	s.manualCompaction = mc
+	// Close waits for the compaction like for the background workers
+	s.backgroundWG.Add(1)
+	return cf, mc, nil
+}
+
+// runManualCompaction executes the tasks of a manual compaction one at a time
+// Like background compactions, each task runs without holding s.mu and its results are installed atomically
+func (s *LSMTableService) runManualCompaction(cf *columnFamily, mc *model.ManualCompaction) error {
+	defer s.backgroundWG.Done()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Flush the memtables first so recent writes and deletes are compacted as well
+	if cf.activeTable.Size() > 0 {
+		if err := s.rotateMemTable(cf); err != nil {
+			err = fmt.Errorf("failed to rotate memtable: %w", err)
+			mc.Fail(err)
+			return err
+		}
+	}
+	// A failed flush is only retried after a backoff, so ask for an attempt now and give up if one fails
+	failures := s.flushFailures
+	if len(cf.immutableTables) > 0 {
+		s.scheduleFlush()
+	}
+	for len(cf.immutableTables) > 0 {
+		select {
+		case <-s.backgroundStop:
+			mc.Fail(ErrCompactionCancelled)
+			return ErrCompactionCancelled
+		default:
+		}
+		if s.flushFailures != failures {
+			err := fmt.Errorf("failed to flush memtables: %w", s.lastFlushErr)
+			mc.Fail(err)
+			return err
+		}
+		// Woken whenever a flush finishes or fails
+		s.backgroundWorkDone.Wait()
+	}
+
+	for {
+		select {
+		case <-s.backgroundStop:
+			mc.Fail(ErrCompactionCancelled)
+			return ErrCompactionCancelled
+		default:
+		}
+
+		task, busy := cf.compactionManager.NextManualTask(mc, cf.sstablesByLevel)
+		if busy {
+			// Wait for the conflicting compaction to release its tables
+			s.backgroundWorkDone.Wait()
+			continue
+		}
+		if task == nil {
+			break
+		}
+
+		cf.compactionManager.StartCompaction(task)
+		s.mu.Unlock()
+		outputTables, err := cf.compactionManager.ExecuteCompaction(task, cf.sstableDir)
+		s.mu.Lock()
+		cf.compactionManager.FinishCompaction(task)
+		s.backgroundWorkDone.Broadcast()
+
+		if err != nil {
+			err = fmt.Errorf("failed to execute compaction: %w", err)
+			mc.Fail(err)
+			return err
+		}
+
+		if err := s.applyVersionEdit(cf, model.NewCompactionEdit(task, outputTables)); err != nil {
+			mc.Fail(err)
+			return err
+		}
+		mc.Complete(task)
+	}
+
+	// Moving data down may have pushed levels over their targets
+	s.scheduleCompaction()
+	return nil
+}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Bloom0716/mini-bigtable/internal/model"
+)
+
+// flushJob is an immutable memtable waiting to be written to level 0 of its column family
+type flushJob struct {
+	cf    *columnFamily
+	table *model.MemTable
+}
+
+// Delays before retrying a failed flush; the delay doubles with every failure in a row
+const (
+	minFlushRetryDelay = 100 * time.Millisecond
+	maxFlushRetryDelay = 10 * time.Second
+)
+
+// scheduleFlush wakes the flush worker without blocking
+func (s *LSMTableService) scheduleFlush() {
+	select {
+	case s.flushSignal <- struct{}{}:
+	default:
+	}
+}
+
+// flushWorker flushes queued memtables one at a time, in rotation order, until the service is closed
+// A failed flush is retried on a timer, since writes stalled on the queued memtables cannot rotate another one
+func (s *LSMTableService) flushWorker() {
+	defer s.backgroundWG.Done()
+
+	var retry <-chan time.Time
+	retryDelay := minFlushRetryDelay
+	for {
+		select {
+		case <-s.backgroundStop:
+			return
+		case <-s.flushSignal:
+		case <-retry:
+		}
+
+		for {
+			flushed, err := s.flushOldest()
+			if err != nil {
+				retry = time.After(retryDelay)
+				retryDelay = min(2*retryDelay, maxFlushRetryDelay)
+				break
+			}
+			retry, retryDelay = nil, minFlushRetryDelay
+			if !flushed {
+				break
+			}
+			select {
+			case <-s.backgroundStop:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// flushOldest writes the oldest queued memtable to an SSTable without holding s.mu, then installs it;
+// returns false if the queue is empty, and the error if the flush failed and the memtable stays queued
+func (s *LSMTableService) flushOldest() (bool, error) {
+	s.mu.Lock()
+	if len(s.flushQueue) == 0 {
+		s.mu.Unlock()
+		return false, nil
+	}
+	job := s.flushQueue[0]
+	filename := s.nextSSTableFileName()
+	s.mu.Unlock()
+
+	// The memtable is read-only and stays visible to readers until the SSTable replaces it
+	sstable, err := s.buildFlushTable(job, filename)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err == nil {
+		err = s.installFlush(job, sstable)
+	}
+	if err != nil {
+		// In production, this should be logged properly
+		fmt.Printf("Failed to flush memtable: %v\n", err)
+		// Waiters for the flush must not wait for a retry that may never succeed
+		s.flushFailures++
+		s.lastFlushErr = err
+		s.backgroundWorkDone.Broadcast()
+		return false, err
+	}
+	return true, nil
+}
+
+// flushPendingLocked flushes every queued memtable while holding s.mu; used once the flush worker has stopped
+func (s *LSMTableService) flushPendingLocked() error {
+	for len(s.flushQueue) > 0 {
+		job := s.flushQueue[0]
+		sstable, err := s.buildFlushTable(job, s.nextSSTableFileName())
+		if err != nil {
+			return fmt.Errorf("failed to build SSTable: %w", err)
+		}
+		if err := s.installFlush(job, sstable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildFlushTable writes a memtable's entries to a level 0 SSTable; returns nil if the memtable is empty
+func (s *LSMTableService) buildFlushTable(job flushJob, filename string) (*model.SSTable, error) {
+	entries := job.table.GetAllEntries()
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	builder := model.NewSSTableBuilder(0, uint32(len(entries)))
+	builder.SetRateLimiter(s.rateLimiter, model.IOPriorityHigh)
+	for _, entry := range entries {
+		builder.AddEntry(entry)
+	}
+	return builder.Build(job.cf.sstableDir, filename)
+}
+
+// installFlush records the flushed memtable's SSTable in the manifest, then replaces the memtable with it
+// in one step and deletes WAL files no memtable needs anymore (caller must hold s.mu)
+// If the manifest cannot be saved the SSTable is deleted and the memtable stays queued for another flush
+func (s *LSMTableService) installFlush(job flushJob, sstable *model.SSTable) error {
+	cf := job.cf
+	levels, immutables, logNumber := cf.sstablesByLevel, cf.immutableTables, s.manifest.LogNumber
+	flushedSequence := s.manifest.FlushedSequences[cf.id]
+	if sstable != nil {
+		cf.sstablesByLevel = editedLevels(levels, &model.VersionEdit{AddedTables: []*model.SSTable{sstable}})
+		// Memtables of a family are flushed in order, so everything up to the table's newest entry is flushed
+		s.manifest.SetFlushedSequence(cf.id, max(flushedSequence, sstable.Metadata().MaxSequence))
+	}
+	for i, table := range cf.immutableTables {
+		if table == job.table {
+			cf.immutableTables = append(cf.immutableTables[:i:i], cf.immutableTables[i+1:]...)
+			break
+		}
+	}
+
+	// The WAL files go only once the manifest no longer needs them to rebuild the flushed memtable
+	s.manifest.SetSSTables(cf.id, cf.sstablesByLevel)
+	s.manifest.LogNumber = s.oldestLiveWAL()
+	if err := s.manifest.Save(); err != nil {
+		cf.sstablesByLevel, cf.immutableTables = levels, immutables
+		s.manifest.SetSSTables(cf.id, levels)
+		s.manifest.SetFlushedSequence(cf.id, flushedSequence)
+		s.manifest.LogNumber = logNumber
+		if sstable != nil {
+			if err := sstable.Remove(); err != nil && !os.IsNotExist(err) {
+				fmt.Printf("Failed to remove abandoned SSTable %s: %v\n", sstable.Metadata().FileName, err)
+			}
+		}
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
+
+	s.flushQueue = s.flushQueue[1:]
+	s.installVersion(cf)
+	job.table.Release()
+	s.purgeObsoleteWALs()
+
+	// Writes stalled on the immutable memtable count may continue
+	s.backgroundWorkDone.Broadcast()
+
+	// Check if compaction is needed
+	if cf.compactionManager.ShouldCompact(cf.sstablesByLevel) {
+		s.scheduleCompaction()
+	}
+	return nil
+}
+
+// purgeObsoleteWALs deletes the WAL files older than every unflushed memtable (caller must hold s.mu)
+// The WAL is shared, so a file stays until the memtables of all column families written to it are flushed
+// Up to Options.RecycledWALFiles obsolete files are renamed and kept for the next rotations instead
+func (s *LSMTableService) purgeObsoleteWALs() {
+	oldestLive := s.oldestLiveWAL()
+	for ; s.oldestWAL < oldestLive; s.oldestWAL++ {
+		if err := s.discardWAL(s.oldestWAL); err != nil {
+			fmt.Printf("Failed to discard obsolete WAL %d: %v\n", s.oldestWAL, err)
+			return
+		}
+	}
+}
+
+// oldestLiveWAL returns the oldest WAL file that may hold entries missing from the SSTables (caller must hold s.mu)
+func (s *LSMTableService) oldestLiveWAL() int {
+	if len(s.unrecoveredWALs) > 0 {
+		return s.unrecoveredWALs[0]
+	}
+
+	oldestLive := s.currentWALNumber()
+	for _, cf := range s.families {
+		logNumber := cf.activeTable.LogNumber()
+		if len(cf.immutableTables) > 0 {
+			logNumber = cf.immutableTables[0].LogNumber()
+		}
+		if logNumber < oldestLive {
+			oldestLive = logNumber
+		}
+	}
+	return oldestLive
+}
+
+// discardWAL keeps an obsolete WAL file for reuse if the pool has room, and deletes it otherwise (caller must hold s.mu)
+func (s *LSMTableService) discardWAL(number int) error {
+	path := filepath.Join(s.walDir, walFileName(number))
+	if len(s.recycledWALs) < s.maxRecycledWALs {
+		// Renamed so the file is no longer taken for a log that still needs replaying
+		recycled := recycledWALFileName(number)
+		err := os.Rename(path, filepath.Join(s.walDir, recycled))
+		if err == nil {
+			s.recycledWALs = append(s.recycledWALs, recycled)
+			return nil
+		}
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// nextSSTableFileName returns a unique name for a flushed SSTable (caller must hold s.mu)
+func (s *LSMTableService) nextSSTableFileName() string {
+	// Use a dedicated counter: several flushes can run between two WAL rotations
+	filename := fmt.Sprintf("sstable_L0_%d.sst", s.sstableCounter)
+	s.sstableCounter++
+	s.manifest.NextSSTableNumber = s.sstableCounter
+	return filename
+}
+
+// currentWALNumber returns the number of the WAL file new writes go to (caller must hold s.mu)
+func (s *LSMTableService) currentWALNumber() int {
+	if s.wal == nil {
+		return s.walCounter
+	}
+	return s.walCounter - 1
+}
+
+// walFileName returns the name of the WAL file with the given number
+func walFileName(number int) string {
+	return fmt.Sprintf("wal_%d.log", number)
+}
+
+// recycledWALFileName returns the name an obsolete WAL file is kept under until it is reused
+func recycledWALFileName(number int) string {
+	return fmt.Sprintf("recycled_%d.log", number)
+}
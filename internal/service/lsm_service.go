@@ -1,277 +1,793 @@
 package service
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Bloom0716/mini-bigtable/internal/model"
 )
 
+var (
+	ErrConflict      = errors.New("write conflict")
+	ErrServiceClosed = errors.New("service is closed")
+)
+
 // LSMTableService represents the application service for LSM-tree operations
 // This coordinates the interaction between different domain components
+// Data is split into column families that share one WAL, so a batch spanning families stays atomic
 type LSMTableService struct {
 	mu                sync.RWMutex
-	activeTable       *model.MemTable
-	immutableTables   []*model.MemTable
-	sstablesByLevel   map[int][]*model.SSTable
+	familiesMu        sync.RWMutex // guards the family maps for readers that do not take s.mu; writers hold both
+	families          map[string]*columnFamily
+	familiesByID      map[uint32]*columnFamily
+	defaultFamily     *columnFamily
+	manifest          *model.Manifest
 	wal               *model.WAL
 	walDir            string
 	sstableDir        string
 	maxTableSize      int
+	writeBufferSize   int
+	maxSubcompactions int
 	walCounter        int
-	compactionManager *model.CompactionManager
+	sstableCounter    int
+	mergeOperator     model.MergeOperator
+	lastSequence      uint64
+	visibleSequence   atomic.Uint64      // lastSequence as of the last fully applied write; lock-free readers see nothing newer
+	walUnsynced       bool               // writes reached the WAL without an fsync since the last sync
+	walPreallocate    int64              // bytes reserved on disk for each new WAL file
+	maxRecycledWALs   int                // number of obsolete WAL files kept for reuse
+	recycledWALs      []string           // obsolete WAL files kept for reuse by the next rotations
+	rateLimiter       *model.RateLimiter // shared by flushes (high priority) and compactions (low priority)
+	// writeBufferManager caps the memory of all memtables, across column families
+	writeBufferManager *model.WriteBufferManager
+
+	// Memtables waiting to be flushed, in rotation order across all column families
+	flushQueue    []flushJob
+	flushSignal   chan struct{}
+	flushFailures int   // failed flush attempts; the memtable stays queued until the next rotation retries it
+	lastFlushErr  error // error of the most recent failed flush
+	oldestWAL     int   // oldest WAL file not yet deleted
+	// unrecoveredWALs are the WAL files a previous run left behind, oldest first; recoverWALs replays them
+	unrecoveredWALs []int
+
+	// Background compaction worker pool
+	compactionSignal chan struct{}
+	backgroundStop   chan struct{}
+	backgroundWG     sync.WaitGroup
+	stopOnce         sync.Once
+	// backgroundWorkDone is signalled (with s.mu) whenever a flush or compaction finishes
+	backgroundWorkDone *sync.Cond
+	manualCompaction   *model.ManualCompaction // most recently started manual compaction
+
+	writeStall      WriteStallOptions
+	writeStallStats WriteStallStats
+
+	lock          *model.DirectoryLock // held on the data directory until Close
+	recoveryStats RecoveryStats
 }
 
-// NewLSMTableService creates a new LSM-tree table service
+// Options configures an LSMTableService
+type Options struct {
+	MaxTableSize         int           // maximum number of entries in a memtable of the default column family; zero is unlimited
+	WriteBufferSize      int           // approximate bytes (keys, values and overhead) a memtable may hold; zero is unlimited
+	TotalWriteBufferSize int64         // approximate bytes all memtables of the service may hold together; zero is unlimited
+	CompactionWorkers    int           // number of compactions that may run in parallel
+	MaxSubcompactions    int           // number of key ranges one compaction may be split into; zero uses the default
+	WriteRateLimit       int64         // bytes per second written to SSTables by flushes and compactions; zero is unlimited
+	WALSyncInterval      time.Duration // how often writes made without WriteOptions.Sync are synced; zero leaves it to the OS
+	WALPreallocateSize   int64         // bytes of disk space reserved for each WAL file; zero reserves nothing
+	RecycledWALFiles     int           // number of obsolete WAL files kept for reuse instead of deleted
+	// MergeOperator combines merge operands with existing values; it is needed to replay merges from the WAL
+	// when the service is opened, so it must be set here rather than with SetMergeOperator
+	MergeOperator model.MergeOperator
+	WriteStall    WriteStallOptions
+}
+
+// DefaultOptions returns the default service options
+func DefaultOptions() Options {
+	return Options{
+		WriteBufferSize:      4 * 1024 * 1024,
+		TotalWriteBufferSize: 64 * 1024 * 1024,
+		CompactionWorkers:    2,
+		MaxSubcompactions:    4,
+		WALSyncInterval:      time.Second,
+		WALPreallocateSize:   4 * 1024 * 1024,
+		RecycledWALFiles:     2,
+		WriteStall:           DefaultWriteStallOptions(),
+	}
+}
+
+// NewLSMTableService creates a new LSM-tree table service whose memtables hold at most maxTableSize entries
+// An existing data directory is reopened and recovered: see NewLSMTableServiceWithOptions
 func NewLSMTableService(dataDir string, maxTableSize int) (*LSMTableService, error) {
+	options := DefaultOptions()
+	options.MaxTableSize = maxTableSize
+	return NewLSMTableServiceWithOptions(dataDir, options)
+}
+
+// NewLSMTableServiceWithOptions creates a new LSM-tree table service with the given options
+// An existing data directory is reopened: column families and SSTables recorded in its manifest are loaded,
+// and the WAL files the previous run left behind are replayed before the service is returned
+// The directory is locked until Close, so a second service on it fails with model.ErrDirectoryLocked
+func NewLSMTableServiceWithOptions(dataDir string, options Options) (_ *LSMTableService, err error) {
+	if options.CompactionWorkers <= 0 {
+		return nil, fmt.Errorf("compaction workers must be positive, got %d", options.CompactionWorkers)
+	}
+	if options.MaxTableSize < 0 || options.WriteBufferSize < 0 || options.TotalWriteBufferSize < 0 {
+		return nil, fmt.Errorf("memtable sizes cannot be negative")
+	}
+	if options.WALSyncInterval < 0 {
+		return nil, fmt.Errorf("WAL sync interval cannot be negative, got %v", options.WALSyncInterval)
+	}
+	if options.WALPreallocateSize < 0 || options.RecycledWALFiles < 0 {
+		return nil, fmt.Errorf("WAL preallocation and recycling cannot be negative")
+	}
+	if options.WriteRateLimit < 0 {
+		return nil, fmt.Errorf("write rate limit cannot be negative, got %d", options.WriteRateLimit)
+	}
+
+	lock, err := model.LockDirectory(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open data directory: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			lock.Release()
+		}
+	}()
+	recoveryStart := time.Now()
+
+	manifest, err := model.LoadManifest(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load manifest: %w", err)
+	}
+
 	service := &LSMTableService{
-		immutableTables:   make([]*model.MemTable, 0),
-		sstablesByLevel:   make(map[int][]*model.SSTable),
-		walDir:            filepath.Join(dataDir, "wal"),
-		sstableDir:        filepath.Join(dataDir, "sstables"),
-		maxTableSize:      maxTableSize,
-		walCounter:        0,
-		compactionManager: model.NewCompactionManager(model.LeveledCompaction),
+		families:           make(map[string]*columnFamily),
+		familiesByID:       make(map[uint32]*columnFamily),
+		manifest:           manifest,
+		walDir:             filepath.Join(dataDir, "wal"),
+		sstableDir:         filepath.Join(dataDir, "sstables"),
+		maxTableSize:       options.MaxTableSize,
+		writeBufferSize:    options.WriteBufferSize,
+		maxSubcompactions:  options.MaxSubcompactions,
+		walCounter:         0,
+		walPreallocate:     options.WALPreallocateSize,
+		maxRecycledWALs:    options.RecycledWALFiles,
+		sstableCounter:     0,
+		flushSignal:        make(chan struct{}, 1),
+		compactionSignal:   make(chan struct{}, 1),
+		backgroundStop:     make(chan struct{}),
+		rateLimiter:        model.NewRateLimiter(options.WriteRateLimit),
+		writeBufferManager: model.NewWriteBufferManager(options.TotalWriteBufferSize),
+		writeStall:         options.WriteStall,
+		mergeOperator:      options.MergeOperator,
+		lock:               lock,
+	}
+	service.backgroundWorkDone = sync.NewCond(&service.mu)
+
+	// WAL numbers continue after the previous run's files, which the first memtables take theirs from
+	if err := service.findWALs(); err != nil {
+		return nil, fmt.Errorf("failed to find WAL files: %w", err)
+	}
+
+	service.defaultFamily = service.addColumnFamily(0, DefaultColumnFamily, ColumnFamilyOptions{
+		MaxTableSize:       options.MaxTableSize,
+		WriteBufferSize:    options.WriteBufferSize,
+		CompactionStrategy: model.LeveledCompaction,
+	})
+	for _, descriptor := range manifest.ColumnFamilies {
+		strategy, err := model.ParseCompactionStrategy(descriptor.CompactionStrategy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid column family %s in manifest: %w", descriptor.Name, err)
+		}
+		filePicking := model.RoundRobinPicking
+		if descriptor.FilePicking != "" {
+			if filePicking, err = model.ParseFilePickingPolicy(descriptor.FilePicking); err != nil {
+				return nil, fmt.Errorf("invalid column family %s in manifest: %w", descriptor.Name, err)
+			}
+		}
+		service.addColumnFamily(descriptor.ID, descriptor.Name, ColumnFamilyOptions{
+			MaxTableSize:       descriptor.MaxTableSize,
+			WriteBufferSize:    descriptor.WriteBufferSize,
+			CompactionStrategy: strategy,
+			DynamicLevelBytes:  descriptor.DynamicLevelBytes,
+			FilePicking:        filePicking,
+		})
+	}
+
+	if err := service.loadSSTables(); err != nil {
+		return nil, fmt.Errorf("failed to load SSTables: %w", err)
+	}
+	if err := service.removeOrphanedFiles(); err != nil {
+		return nil, fmt.Errorf("failed to remove orphaned files: %w", err)
+	}
+	if err := service.createNewWAL(); err != nil {
+		return nil, fmt.Errorf("failed to create initial WAL: %w", err)
 	}
 
-	if err := service.createNewActiveTable(); err != nil {
-		return nil, fmt.Errorf("failed to create initial active table: %w", err)
+	// Entries the previous run only logged are in SSTables before the first read or write
+	if err := service.recoverWALs(); err != nil {
+		service.wal.Close()
+		return nil, fmt.Errorf("failed to recover: %w", err)
+	}
+	service.visibleSequence.Store(service.lastSequence)
+	service.recoveryStats.LastSequence = service.lastSequence
+	service.recoveryStats.Duration = time.Since(recoveryStart)
+
+	service.backgroundWG.Add(1)
+	go service.flushWorker()
+	for i := 0; i < options.CompactionWorkers; i++ {
+		service.backgroundWG.Add(1)
+		go service.compactionWorker()
+	}
+	if options.WALSyncInterval > 0 {
+		service.backgroundWG.Add(1)
+		go service.walSyncer(options.WALSyncInterval)
 	}
 
 	return service, nil
 }
 
-// Put adds a key-value pair to the LSM-tree
+// Put adds a key-value pair to the LSM-tree; the WAL is synced before it returns
 func (s *LSMTableService) Put(key, value []byte) error {
+	return s.PutWithOptions(key, value, DefaultWriteOptions())
+}
+
+// PutWithOptions adds a key-value pair with the given durability
+func (s *LSMTableService) PutWithOptions(key, value []byte, options WriteOptions) error {
+	if err := s.throttleWrites(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Write to WAL first for durability
-	entry := model.NewPutEntry(key, value)
-	if err := s.wal.WriteEntry(entry); err != nil {
-		return fmt.Errorf("failed to write to WAL: %w", err)
+	return s.writeEntry(s.defaultFamily, model.NewPutEntry(key, value), options)
+}
+
+// PutWithTTL adds a key-value pair that expires after the given TTL
+func (s *LSMTableService) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	return s.PutWithTTLWithOptions(key, value, ttl, DefaultWriteOptions())
+}
+
+// PutWithTTLWithOptions adds a key-value pair that expires after the given TTL with the given durability
+func (s *LSMTableService) PutWithTTLWithOptions(key, value []byte, ttl time.Duration, options WriteOptions) error {
+	if ttl <= 0 {
+		return fmt.Errorf("ttl must be positive, got %v", ttl)
 	}
 
-	// Try to put in active memtable
-	if err := s.activeTable.Put(key, value); err != nil {
-		if err == model.ErrTableFull {
-			// Rotate the memtable
-			if err := s.rotateMemTable(); err != nil {
-				return fmt.Errorf("failed to rotate memtable: %w", err)
-			}
-			// Try again with new active table
-			if err := s.activeTable.Put(key, value); err != nil {
-				return fmt.Errorf("failed to put in new active table: %w", err)
+	if err := s.throttleWrites(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.writeEntry(s.defaultFamily, model.NewPutEntryWithTTL(key, value, ttl), options)
+}
+
+// Write applies all writes in the batch atomically: they are logged as one WAL record
+// and become visible to readers together
+func (s *LSMTableService) Write(batch *model.WriteBatch) error {
+	return s.WriteWithOptions(batch, DefaultWriteOptions())
+}
+
+// WriteWithOptions applies all writes in the batch atomically with the given durability
+func (s *LSMTableService) WriteWithOptions(batch *model.WriteBatch, options WriteOptions) error {
+	if batch.Count() == 0 {
+		return nil
+	}
+
+	if err := s.throttleWrites(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mergeOperator == nil {
+		for _, entry := range batch.Entries() {
+			if entry.IsMerge() {
+				return model.ErrNoMergeOperator
 			}
-		} else {
-			return fmt.Errorf("failed to put in active table: %w", err)
 		}
 	}
 
-	// Flush WAL to ensure durability
-	if err := s.wal.Flush(); err != nil {
-		return fmt.Errorf("failed to flush WAL: %w", err)
+	// Resolve every target family before anything is written
+	families := batch.ColumnFamilies()
+	for i, entry := range batch.Entries() {
+		cf, err := s.columnFamily(families[i])
+		if err != nil {
+			return err
+		}
+		entry.SetColumnFamilyID(cf.id)
 	}
 
-	return nil
+	return s.writeEntries(batch.Entries(), options)
 }
 
-// Get retrieves a value for the given key from the LSM-tree
-func (s *LSMTableService) Get(key []byte) ([]byte, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// writeEntry logs an entry to the WAL and applies it to the family's active memtable (caller must hold s.mu)
+func (s *LSMTableService) writeEntry(cf *columnFamily, entry *model.Entry, options WriteOptions) error {
+	entry.SetColumnFamilyID(cf.id)
+	return s.writeEntries([]*model.Entry{entry}, options)
+}
 
-	// Check active memtable first
-	if entry, err := s.activeTable.Get(key); err == nil {
-		if entry.IsDeleted() {
-			return nil, model.ErrKeyNotFound
-		}
-		return entry.Value(), nil
+// writeEntries logs entries to the WAL as one record and applies each to the active memtable
+// of its column family (caller must hold s.mu)
+func (s *LSMTableService) writeEntries(entries []*model.Entry, options WriteOptions) error {
+	// Assign the next sequence numbers, which also serve as the entries' version tokens
+	for _, entry := range entries {
+		s.lastSequence++
+		entry.SetSequence(s.lastSequence)
 	}
 
-	// Check immutable memtables in reverse order (newest first)
-	for i := len(s.immutableTables) - 1; i >= 0; i-- {
-		if entry, err := s.immutableTables[i].Get(key); err == nil {
-			if entry.IsDeleted() {
-				return nil, model.ErrKeyNotFound
-			}
-			return entry.Value(), nil
+	// Write to WAL first for durability
+	if !options.DisableWAL {
+		if err := s.wal.WriteEntries(entries); err != nil {
+			return fmt.Errorf("failed to write to WAL: %w", err)
 		}
 	}
 
-	// Check SSTables from level 0 upwards
-	for level := 0; level < 10; level++ { // Arbitrary max level
-		tables := s.sstablesByLevel[level]
-		// For level 0, check all tables (they may overlap)
-		// For other levels, we could use binary search since tables don't overlap
-		for i := len(tables) - 1; i >= 0; i-- { // Check newest first
-			if entry, err := tables[i].Get(key); err == nil && entry != nil {
-				if entry.IsDeleted() {
-					return nil, model.ErrKeyNotFound
-				}
-				return entry.Value(), nil
-			}
+	// Try to apply to active memtables
+	for _, entry := range entries {
+		cf, ok := s.familiesByID[entry.ColumnFamilyID()]
+		if !ok {
+			return fmt.Errorf("%w: id %d", ErrColumnFamilyNotFound, entry.ColumnFamilyID())
+		}
+		if err := s.applyToActiveTable(cf, entry); err != nil {
+			return err
 		}
 	}
+	// Lock-free readers only see the entries once all of them are applied
+	s.visibleSequence.Store(s.lastSequence)
+
+	// Flush WAL to ensure durability; unsynced writes are forced to disk by the next sync
+	switch {
+	case options.DisableWAL:
+	case options.Sync:
+		if err := s.wal.Sync(); err != nil {
+			return fmt.Errorf("failed to sync WAL: %w", err)
+		}
+		s.walUnsynced = false
+	default:
+		if err := s.wal.Flush(); err != nil {
+			return fmt.Errorf("failed to flush WAL: %w", err)
+		}
+		s.walUnsynced = true
+	}
 
-	return nil, model.ErrKeyNotFound
+	return s.enforceWriteBufferLimit()
 }
 
-// Delete marks a key as deleted in the LSM-tree
-func (s *LSMTableService) Delete(key []byte) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// enforceWriteBufferLimit switches the largest active memtable when memtables use too much of the
+// service-wide write buffer (caller must hold s.mu)
+// Writes stop in throttleWrites once the budget is exhausted, until flushes free memory
+func (s *LSMTableService) enforceWriteBufferLimit() error {
+	if !s.writeBufferManager.ShouldFlush() {
+		return nil
+	}
 
-	// Write to WAL first for durability
-	entry := model.NewDeleteEntry(key)
-	if err := s.wal.WriteEntry(entry); err != nil {
-		return fmt.Errorf("failed to write to WAL: %w", err)
+	var largest *columnFamily
+	for _, cf := range s.families {
+		if largest == nil || cf.activeTable.MemoryUsage() > largest.activeTable.MemoryUsage() {
+			largest = cf
+		}
+	}
+	if largest == nil || largest.activeTable.Size() == 0 {
+		return nil
 	}
+	if err := s.rotateMemTable(largest); err != nil {
+		return fmt.Errorf("failed to rotate memtable: %w", err)
+	}
+	return nil
+}
 
-	// Try to delete in active memtable
-	if err := s.activeTable.Delete(key); err != nil {
+// applyToActiveTable inserts an entry into the family's active memtable, rotating it when full (caller must hold s.mu)
+func (s *LSMTableService) applyToActiveTable(cf *columnFamily, entry *model.Entry) error {
+	if err := cf.activeTable.PutEntry(entry); err != nil {
 		if err == model.ErrTableFull {
+			// The entry is already in the current WAL, so the new memtable has to keep that file alive
+			logNumber := s.currentWALNumber()
+
 			// Rotate the memtable
-			if err := s.rotateMemTable(); err != nil {
+			if err := s.rotateMemTable(cf); err != nil {
 				return fmt.Errorf("failed to rotate memtable: %w", err)
 			}
+			cf.activeTable.SetLogNumber(logNumber)
 			// Try again with new active table
-			if err := s.activeTable.Delete(key); err != nil {
-				return fmt.Errorf("failed to delete in new active table: %w", err)
+			if err := cf.activeTable.PutEntry(entry); err != nil {
+				return fmt.Errorf("failed to apply entry to new active table: %w", err)
 			}
 		} else {
-			return fmt.Errorf("failed to delete in active table: %w", err)
+			return fmt.Errorf("failed to apply entry to active table: %w", err)
 		}
 	}
+	return nil
+}
 
-	// Flush WAL to ensure durability
-	if err := s.wal.Flush(); err != nil {
-		return fmt.Errorf("failed to flush WAL: %w", err)
+// Merge records a merge operand for the key; operands are combined with the existing value on read and during compaction
+func (s *LSMTableService) Merge(key, operand []byte) error {
+	if err := s.throttleWrites(); err != nil {
+		return err
 	}
 
-	return nil
-}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-// rotateMemTable moves the current active table to immutable and creates a new active table
-func (s *LSMTableService) rotateMemTable() error {
-	// Mark current active table as read-only
-	s.activeTable.SetReadOnly()
+	if s.mergeOperator == nil {
+		return model.ErrNoMergeOperator
+	}
 
-	// Move to immutable list
-	s.immutableTables = append(s.immutableTables, s.activeTable)
+	return s.writeEntry(s.defaultFamily, model.NewMergeEntry(key, operand), DefaultWriteOptions())
+}
 
-	// Create new active table
-	if err := s.createNewActiveTable(); err != nil {
-		return err
+// SetMergeOperator sets the operator used by Merge to combine operands with existing values
+// The operator applies to every column family; a data directory whose WAL may hold merges must be opened
+// with Options.MergeOperator instead, as the WAL is replayed before this can be called
+func (s *LSMTableService) SetMergeOperator(operator model.MergeOperator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mergeOperator = operator
+	for _, cf := range s.families {
+		cf.compactionManager.SetMergeOperator(operator)
+		cf.activeTable.SetMergeOperator(operator)
+		s.installVersion(cf)
 	}
+}
 
-	// Trigger background flush of immutable table to SSTable
-	go func() {
-		s.flushImmutableTable()
-	}()
+// Get retrieves a value for the given key from the LSM-tree
+func (s *LSMTableService) Get(key []byte) ([]byte, error) {
+	value, _, err := s.GetWithVersion(key)
+	return value, err
+}
 
-	return nil
+// GetWithVersion retrieves a value together with its version token (the sequence number of its newest write)
+// Reads do not take s.mu: they search a reference-counted version, so slow SSTable reads never block writers
+func (s *LSMTableService) GetWithVersion(key []byte) ([]byte, uint64, error) {
+	version, sequence := s.acquireReadVersion(s.defaultFamily)
+	defer version.Unref()
+
+	resolver := version.LookupAt(key, sequence)
+	value, err := resolver.Value()
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, resolver.Version(), nil
+}
+
+// lookup searches the family's current version for the key (caller must hold s.mu)
+// Versions are only replaced under s.mu, so the current one cannot be released during the lookup
+func (s *LSMTableService) lookup(cf *columnFamily, key []byte) *model.ValueResolver {
+	return cf.current.Load().Lookup(key)
 }
 
-// flushImmutableTable flushes the oldest immutable table to an SSTable (with locking)
-func (s *LSMTableService) flushImmutableTable() {
+// CompareAndSwap atomically replaces the value of key with newValue if its current value equals expectedValue
+// Returns ErrConflict if the key is missing or holds a different value
+func (s *LSMTableService) CompareAndSwap(key, expectedValue, newValue []byte) error {
+	if err := s.throttleWrites(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.flushImmutableTableInternal()
+
+	current, err := s.lookup(s.defaultFamily, key).Value()
+	if err == model.ErrKeyNotFound {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read current value: %w", err)
+	}
+
+	if !bytes.Equal(current, expectedValue) {
+		return ErrConflict
+	}
+
+	return s.writeEntry(s.defaultFamily, model.NewPutEntry(key, newValue), DefaultWriteOptions())
 }
 
-// flushImmutableTableInternal flushes the oldest immutable table to an SSTable (without locking)
-func (s *LSMTableService) flushImmutableTableInternal() {
+// PutIfAbsent atomically stores the key-value pair only if the key has no live value
+// Returns ErrConflict if the key already exists
+func (s *LSMTableService) PutIfAbsent(key, value []byte) error {
+	return s.PutIfAbsentWithOptions(key, value, 0, DefaultWriteOptions())
+}
 
-	if len(s.immutableTables) == 0 {
-		return
+// PutIfAbsentWithOptions is PutIfAbsent with a TTL (zero never expires) and the given durability
+func (s *LSMTableService) PutIfAbsentWithOptions(key, value []byte, ttl time.Duration, options WriteOptions) error {
+	entry, err := newPutEntry(key, value, ttl)
+	if err != nil {
+		return err
+	}
+	if err := s.throttleWrites(); err != nil {
+		return err
 	}
 
-	// Get the oldest immutable table
-	immutableTable := s.immutableTables[0]
-	s.immutableTables = s.immutableTables[1:]
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	// Convert to SSTable
-	entries := immutableTable.GetAllEntries()
-	if len(entries) == 0 {
-		return
+	_, err = s.lookup(s.defaultFamily, key).Value()
+	if err == nil {
+		return ErrConflict
+	}
+	if err != model.ErrKeyNotFound {
+		return fmt.Errorf("failed to read current value: %w", err)
 	}
 
-	// Build SSTable
-	builder := model.NewSSTableBuilder(0, uint32(len(entries)))
-	for _, entry := range entries {
-		builder.AddEntry(entry)
+	return s.writeEntry(s.defaultFamily, entry, options)
+}
+
+// PutIfVersion atomically stores the key-value pair only if the key's current version matches
+// the version token returned by GetWithVersion
+// Returns ErrConflict if the key is missing or has been written since
+func (s *LSMTableService) PutIfVersion(key, value []byte, version uint64) error {
+	return s.PutIfVersionWithOptions(key, value, version, 0, DefaultWriteOptions())
+}
+
+// PutIfVersionWithOptions is PutIfVersion with a TTL (zero never expires) and the given durability
+func (s *LSMTableService) PutIfVersionWithOptions(key, value []byte, version uint64, ttl time.Duration, options WriteOptions) error {
+	entry, err := newPutEntry(key, value, ttl)
+	if err != nil {
+		return err
+	}
+	if err := s.throttleWrites(); err != nil {
+		return err
 	}
 
-	filename := fmt.Sprintf("sstable_L0_%d.sst", s.walCounter)
-	sstable, err := builder.Build(s.sstableDir, filename)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resolver := s.lookup(s.defaultFamily, key)
+	_, err = resolver.Value()
+	if err == model.ErrKeyNotFound {
+		return ErrConflict
+	}
 	if err != nil {
-		// In production, this should be logged properly
-		fmt.Printf("Failed to build SSTable: %v\n", err)
-		return
+		return fmt.Errorf("failed to read current value: %w", err)
 	}
 
-	// Add to level 0
-	s.sstablesByLevel[0] = append(s.sstablesByLevel[0], sstable)
+	if resolver.Version() != version {
+		return ErrConflict
+	}
+
+	return s.writeEntry(s.defaultFamily, entry, options)
+}
 
-	// Check if compaction is needed
-	if s.compactionManager.ShouldCompact(s.sstablesByLevel) {
-		go s.runCompaction()
+// newPutEntry creates the entry a put with an optional TTL writes; a zero TTL never expires
+func newPutEntry(key, value []byte, ttl time.Duration) (*model.Entry, error) {
+	if ttl < 0 {
+		return nil, fmt.Errorf("ttl cannot be negative, got %v", ttl)
+	}
+	if ttl == 0 {
+		return model.NewPutEntry(key, value), nil
 	}
+	return model.NewPutEntryWithTTL(key, value, ttl), nil
 }
 
-// runCompaction runs background compaction
-func (s *LSMTableService) runCompaction() {
+// Delete marks a key as deleted in the LSM-tree; the WAL is synced before it returns
+func (s *LSMTableService) Delete(key []byte) error {
+	return s.DeleteWithOptions(key, DefaultWriteOptions())
+}
+
+// DeleteWithOptions marks a key as deleted with the given durability
+func (s *LSMTableService) DeleteWithOptions(key []byte, options WriteOptions) error {
+	if err := s.throttleWrites(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	task := s.compactionManager.SelectCompactionTask(s.sstablesByLevel)
+	return s.writeEntry(s.defaultFamily, model.NewDeleteEntry(key), options)
+}
+
+// rotateMemTable makes the family's active memtable immutable and queues it for flushing (caller must hold s.mu)
+func (s *LSMTableService) rotateMemTable(cf *columnFamily) error {
+	// Mark current active table as read-only
+	cf.activeTable.SetReadOnly()
+
+	// Move to immutable list
+	cf.immutableTables = append(cf.immutableTables, cf.activeTable)
+	s.flushQueue = append(s.flushQueue, flushJob{cf: cf, table: cf.activeTable})
+
+	// Create new WAL and active table
+	if err := s.createNewWAL(); err != nil {
+		return err
+	}
+	cf.activeTable = s.newMemTable(cf)
+	s.installVersion(cf)
+
+	s.scheduleFlush()
+	return nil
+}
+
+// WriteRateLimit returns the bytes per second flushes and compactions may write; zero means unlimited
+func (s *LSMTableService) WriteRateLimit() int64 {
+	return s.rateLimiter.BytesPerSecond()
+}
+
+// SetWriteRateLimit changes the bytes per second flushes and compactions may write; zero disables limiting
+// Flushes are served before compactions when both wait for the limiter
+func (s *LSMTableService) SetWriteRateLimit(bytesPerSecond int64) error {
+	if bytesPerSecond < 0 {
+		return fmt.Errorf("write rate limit cannot be negative, got %d", bytesPerSecond)
+	}
+	s.rateLimiter.SetBytesPerSecond(bytesPerSecond)
+	return nil
+}
+
+// scheduleCompaction wakes an idle compaction worker without blocking
+func (s *LSMTableService) scheduleCompaction() {
+	select {
+	case s.compactionSignal <- struct{}{}:
+	default:
+	}
+}
+
+// compactionWorker runs compaction tasks until the service is closed
+func (s *LSMTableService) compactionWorker() {
+	defer s.backgroundWG.Done()
+
+	for {
+		select {
+		case <-s.backgroundStop:
+			return
+		case <-s.compactionSignal:
+		}
+
+		for s.runCompaction() {
+			select {
+			case <-s.backgroundStop:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// runCompaction selects a compaction task that does not conflict with running ones, executes it without
+// holding s.mu and installs its results; returns false if there was nothing to do
+func (s *LSMTableService) runCompaction() bool {
+	s.mu.Lock()
+	cf, task := s.pickCompaction()
 	if task == nil {
-		return
+		s.mu.Unlock()
+		return false
 	}
+	cf.compactionManager.StartCompaction(task)
+	s.mu.Unlock()
+
+	// Let another worker look for a non-conflicting task in the meantime
+	s.scheduleCompaction()
+
+	// Input tables are immutable and stay registered until the results are installed,
+	// so reads and writes continue while the merge runs
+	outputTables, err := cf.compactionManager.ExecuteCompaction(task, cf.sstableDir)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.backgroundWorkDone.Broadcast()
+	defer cf.compactionManager.FinishCompaction(task)
 
-	// Execute compaction
-	outputTables, err := s.compactionManager.ExecuteCompaction(task, s.sstableDir)
 	if err != nil {
 		fmt.Printf("Failed to execute compaction: %v\n", err)
-		return
+		return false
+	}
+
+	// Persist the round-robin compaction pointers so key space keeps being compacted evenly after a restart
+	s.manifest.SetCompactPointers(cf.id, cf.compactionManager.CompactPointers())
+
+	// Install the outputs of all subcompactions together
+	if err := s.applyVersionEdit(cf, model.NewCompactionEdit(task, outputTables)); err != nil {
+		fmt.Printf("Failed to install compaction: %v\n", err)
+		return false
 	}
+	return true
+}
 
-	// Update SSTable registry
-	s.updateSSTablesAfterCompaction(task, outputTables)
+// pickCompaction returns the first column family with a compaction task available (caller must hold s.mu)
+func (s *LSMTableService) pickCompaction() (*columnFamily, *model.CompactionTask) {
+	for _, cf := range s.families {
+		if task := cf.compactionManager.SelectCompactionTask(cf.sstablesByLevel); task != nil {
+			return cf, task
+		}
+	}
+	return nil, nil
 }
 
-// updateSSTablesAfterCompaction updates the SSTable registry after compaction
-func (s *LSMTableService) updateSSTablesAfterCompaction(task *model.CompactionTask, outputTables []*model.SSTable) {
-	// Remove input SSTables from their levels
-	for _, inputTable := range task.InputSSTables {
-		level := inputTable.Metadata().Level
-		tables := s.sstablesByLevel[level]
+// stopBackgroundWork stops the flush and compaction workers and waits for their running work to be installed
+func (s *LSMTableService) stopBackgroundWork() {
+	s.stopOnce.Do(func() {
+		// Closed under s.mu so manual compactions either see the stop or are already counted in backgroundWG
+		s.mu.Lock()
+		close(s.backgroundStop)
+		s.backgroundWorkDone.Broadcast()
+		s.mu.Unlock()
+	})
+	s.backgroundWG.Wait()
+}
 
-		// Find and remove the input table
-		for i, table := range tables {
-			if table == inputTable {
-				s.sstablesByLevel[level] = append(tables[:i], tables[i+1:]...)
-				break
+// applyVersionEdit records the edit in the manifest, then updates the family's SSTable registry and installs it
+// as a new version (caller must hold s.mu)
+// Readers see either all or none of the edit; the files of deleted tables are removed once
+// the last version still listing them is released
+// If the manifest cannot be saved the edit is abandoned: its new tables are deleted and the old ones kept,
+// so the registry never lists tables a restart would not find
+func (s *LSMTableService) applyVersionEdit(cf *columnFamily, edit *model.VersionEdit) error {
+	levels := editedLevels(cf.sstablesByLevel, edit)
+	s.manifest.SetSSTables(cf.id, levels)
+	if err := s.manifest.Save(); err != nil {
+		s.manifest.SetSSTables(cf.id, cf.sstablesByLevel)
+		for _, table := range edit.AddedTables {
+			if err := table.Remove(); err != nil && !os.IsNotExist(err) {
+				fmt.Printf("Failed to remove abandoned SSTable %s: %v\n", table.Metadata().FileName, err)
 			}
 		}
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
+
+	// Moved SSTables keep their files and only change level
+	for _, movedTable := range edit.MovedTables {
+		movedTable.MoveToLevel(edit.MoveLevel)
+	}
+	cf.sstablesByLevel = levels
+	s.installVersion(cf)
+	return nil
+}
 
-		// Remove the file
-		inputTable.Remove()
+// editedLevels returns a copy of levels with the edit applied; levels itself is not changed
+func editedLevels(levels map[int][]*model.SSTable, edit *model.VersionEdit) map[int][]*model.SSTable {
+	// Fresh slices: a task's inputs may share the backing arrays of the current levels
+	edited := make(map[int][]*model.SSTable, len(levels))
+	for level, tables := range levels {
+		edited[level] = append([]*model.SSTable{}, tables...)
 	}
 
-	// Add output SSTables to their level
-	for _, outputTable := range outputTables {
+	for _, inputTable := range edit.DeletedTables {
+		removeFromLevel(edited, inputTable)
+	}
+	for _, movedTable := range edit.MovedTables {
+		removeFromLevel(edited, movedTable)
+		edited[edit.MoveLevel] = append(edited[edit.MoveLevel], movedTable)
+	}
+	for _, outputTable := range edit.AddedTables {
 		level := outputTable.Metadata().Level
-		s.sstablesByLevel[level] = append(s.sstablesByLevel[level], outputTable)
+		edited[level] = append(edited[level], outputTable)
+	}
+	return edited
+}
+
+// removeFromLevel unregisters a table from its level
+func removeFromLevel(levels map[int][]*model.SSTable, target *model.SSTable) {
+	level := target.Metadata().Level
+	tables := levels[level]
+	for i, table := range tables {
+		if table == target {
+			levels[level] = append(tables[:i], tables[i+1:]...)
+			return
+		}
 	}
 }
 
-// createNewActiveTable creates a new active memtable and WAL
-func (s *LSMTableService) createNewActiveTable() error {
+// newMemTable creates an active memtable for the column family
+func (s *LSMTableService) newMemTable(cf *columnFamily) *model.MemTable {
+	table := model.NewMemTable(cf.options.MaxTableSize)
+	table.SetMaxBytes(cf.options.WriteBufferSize)
+	table.SetWriteBufferManager(s.writeBufferManager)
+	table.SetMergeOperator(s.mergeOperator)
+	table.SetLogNumber(s.currentWALNumber())
+	return table
+}
+
+// createNewWAL closes the current WAL, if any, and starts a new one shared by all column families
+func (s *LSMTableService) createNewWAL() error {
 	// Close current WAL if exists
 	if s.wal != nil {
 		if err := s.wal.Close(); err != nil {
@@ -279,29 +795,37 @@ func (s *LSMTableService) createNewActiveTable() error {
 		}
 	}
 
-	// Create new WAL
-	walFilename := fmt.Sprintf("wal_%d.log", s.walCounter)
-	wal, err := model.NewWAL(s.walDir, walFilename)
+	// Create new WAL, reusing an obsolete file if one is kept
+	options := model.WALOptions{LogNumber: uint32(s.walCounter), PreallocateSize: s.walPreallocate}
+	var wal *model.WAL
+	var err error
+	if n := len(s.recycledWALs); n > 0 {
+		wal, err = model.RecycleWAL(s.walDir, s.recycledWALs[n-1], walFileName(s.walCounter), options)
+		s.recycledWALs = s.recycledWALs[:n-1]
+	} else {
+		wal, err = model.NewWALWithOptions(s.walDir, walFileName(s.walCounter), options)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create new WAL: %w", err)
 	}
 	s.wal = wal
 	s.walCounter++
 
-	// Create new active memtable
-	s.activeTable = model.NewMemTable(s.maxTableSize)
-
 	return nil
 }
 
 // Close closes the LSM-tree service and all associated resources
 func (s *LSMTableService) Close() error {
+	// Running flushes and compactions need s.mu to install their results
+	s.stopBackgroundWork()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.releaseLock()
 
 	// Flush all remaining immutable tables before closing
-	for len(s.immutableTables) > 0 {
-		s.flushImmutableTableInternal()
+	if err := s.flushPendingLocked(); err != nil {
+		return err
 	}
 
 	if s.wal != nil {
@@ -310,56 +834,15 @@ func (s *LSMTableService) Close() error {
 	return nil
 }
 
-// Recovery recovers the LSM-tree service from WAL files and existing SSTables
-func (s *LSMTableService) Recovery() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// First, load existing SSTables
-	if err := s.loadExistingSSTables(); err != nil {
-		return fmt.Errorf("failed to load existing SSTables: %w", err)
+// releaseLock lets other processes open the data directory (caller must hold s.mu)
+func (s *LSMTableService) releaseLock() {
+	if s.lock == nil {
+		return
 	}
-
-	// Then recover from WAL
-	if s.wal != nil {
-		entries, err := s.wal.Recover()
-		if err != nil {
-			return fmt.Errorf("failed to recover from WAL: %w", err)
-		}
-
-		// Replay entries into the active memtable
-		for _, entry := range entries {
-			if entry.IsDeleted() {
-				if err := s.activeTable.Delete(entry.Key()); err != nil {
-					if err == model.ErrTableFull {
-						if err := s.rotateMemTable(); err != nil {
-							return fmt.Errorf("failed to rotate memtable during recovery: %w", err)
-						}
-						if err := s.activeTable.Delete(entry.Key()); err != nil {
-							return fmt.Errorf("failed to replay delete entry during recovery: %w", err)
-						}
-					} else {
-						return fmt.Errorf("failed to replay delete entry: %w", err)
-					}
-				}
-			} else {
-				if err := s.activeTable.Put(entry.Key(), entry.Value()); err != nil {
-					if err == model.ErrTableFull {
-						if err := s.rotateMemTable(); err != nil {
-							return fmt.Errorf("failed to rotate memtable during recovery: %w", err)
-						}
-						if err := s.activeTable.Put(entry.Key(), entry.Value()); err != nil {
-							return fmt.Errorf("failed to replay put entry during recovery: %w", err)
-						}
-					} else {
-						return fmt.Errorf("failed to replay put entry: %w", err)
-					}
-				}
-			}
-		}
+	if err := s.lock.Release(); err != nil {
+		fmt.Printf("Failed to release data directory lock: %v\n", err)
 	}
-
-	return nil
+	s.lock = nil
 }
 
 // GetMemTableStats returns statistics about the current memtables
@@ -367,7 +850,13 @@ func (s *LSMTableService) GetMemTableStats() (activeSize int, immutableCount int
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	return s.activeTable.Size(), len(s.immutableTables)
+	return s.defaultFamily.activeTable.Size(), len(s.defaultFamily.immutableTables)
+}
+
+// GetWriteBufferStats returns the approximate memory held by unflushed memtables of all column families
+// and the service-wide limit; a limit of zero means unlimited
+func (s *LSMTableService) GetWriteBufferStats() (memoryUsage, bufferSize int64) {
+	return s.writeBufferManager.MemoryUsage(), s.writeBufferManager.BufferSize()
 }
 
 // GetSSTableStats returns statistics about SSTables
@@ -375,47 +864,5 @@ func (s *LSMTableService) GetSSTableStats() map[int]int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	stats := make(map[int]int)
-	for level, tables := range s.sstablesByLevel {
-		stats[level] = len(tables)
-	}
-	return stats
-}
-
-// loadExistingSSTables loads existing SSTables from disk
-func (s *LSMTableService) loadExistingSSTables() error {
-	// Check if SSTable directory exists
-	if _, err := os.Stat(s.sstableDir); os.IsNotExist(err) {
-		return nil // No SSTables directory, nothing to load
-	}
-
-	// Read directory contents
-	entries, err := os.ReadDir(s.sstableDir)
-	if err != nil {
-		return fmt.Errorf("failed to read SSTable directory: %w", err)
-	}
-
-	// Load all .sst files
-	for _, entry := range entries {
-		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sst") {
-			continue
-		}
-
-		// Parse level from filename (assuming format: sstable_L<level>_<id>.sst)
-		level := 0 // Default to level 0
-		if strings.Contains(entry.Name(), "_L0_") {
-			level = 0
-		} else if strings.Contains(entry.Name(), "_L1_") {
-			level = 1
-		}
-		// Add more levels as needed
-
-		// Load SSTable
-		// filePath := filepath.Join(s.sstableDir, entry.Name())
-		// Note: We need to implement LoadSSTable method in domain
-		// For now, we'll skip this and just note that SSTables exist
-		fmt.Printf("Found SSTable file: %s (level %d)\n", entry.Name(), level)
-	}
-
-	return nil
+	return s.defaultFamily.sstableStats()
 }
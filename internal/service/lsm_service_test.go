@@ -1,9 +1,16 @@
 package service
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Bloom0716/mini-bigtable/internal/model"
 )
@@ -198,17 +205,13 @@ func TestLSMTableServiceRecovery(t *testing.T) {
 		t.Fatalf("Failed to close first service: %v", err)
 	}
 
-	// Create new service and recover
+	// Create new service, which recovers on its own
 	service2, err := NewLSMTableService(tmpDir, 5)
 	if err != nil {
 		t.Fatalf("Failed to create second LSM service: %v", err)
 	}
 	defer service2.Close()
 
-	if err := service2.Recovery(); err != nil {
-		t.Fatalf("Failed to recover: %v", err)
-	}
-
 	// Verify recovered data
 	// key1 and key3 should exist
 	for _, key := range []string{"key1", "key3"} {
@@ -228,3 +231,1489 @@ func TestLSMTableServiceRecovery(t *testing.T) {
 		t.Errorf("Expected key2 to be deleted after recovery, got error: %v", err)
 	}
 }
+
+func TestLSMTableServiceRecoveryFlushesWALs(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_recovery_flush")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	// A WAL left behind by a run that crashed before flushing anything
+	walDir := filepath.Join(tmpDir, "wal")
+	wal, err := model.NewWAL(walDir, "wal_0.log")
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	for i := 0; i < 40; i++ {
+		entry := model.NewPutEntry([]byte(fmt.Sprintf("key%02d", i)), bytes.Repeat([]byte{'v'}, 100))
+		entry.SetSequence(uint64(i + 1))
+		if err := wal.WriteEntry(entry); err != nil {
+			t.Fatalf("Failed to write entry: %v", err)
+		}
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatalf("Failed to close WAL: %v", err)
+	}
+	walData, err := os.ReadFile(filepath.Join(walDir, "wal_0.log"))
+	if err != nil {
+		t.Fatalf("Failed to read WAL: %v", err)
+	}
+
+	// Memtables of 2KB hold a few entries each, so replay flushes several times
+	options := DefaultOptions()
+	options.WriteBufferSize = 2048
+	service, err := NewLSMTableServiceWithOptions(tmpDir, options)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(walDir, "wal_0.log")); !os.IsNotExist(err) {
+		t.Errorf("Expected the replayed WAL to be deleted, got %v", err)
+	}
+	manifest, err := model.LoadManifest(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if manifest.LogNumber != 1 || manifest.NextSSTableNumber < 2 || len(manifest.SSTables) == 0 {
+		t.Errorf("Expected log number 1 and several recorded tables, got %d, %d and %v", manifest.LogNumber, manifest.NextSSTableNumber, manifest.SSTables)
+	}
+	if active, immutables := service.GetMemTableStats(); active != 0 || immutables != 0 {
+		t.Errorf("Expected replayed entries to be flushed, got %d active entries and %d immutable memtables", active, immutables)
+	}
+	stats := service.GetRecoveryStats()
+	if stats.ReplayedWALs != 1 || stats.ReplayedEntries != 40 || stats.FlushedSSTables != manifest.NextSSTableNumber || stats.LastSequence != 40 {
+		t.Errorf("Unexpected recovery stats: %+v", stats)
+	}
+
+	// New writes are sequenced after the recovered ones
+	if err := service.Put([]byte("key40"), []byte("new")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if _, version, err := service.GetWithVersion([]byte("key40")); err != nil || version != 41 {
+		t.Errorf("Expected version 41, got %d (err %v)", version, err)
+	}
+	if err := service.Close(); err != nil {
+		t.Fatalf("Failed to close service: %v", err)
+	}
+
+	// As if the first run crashed after recording the tables but before deleting the WAL
+	if err := os.WriteFile(filepath.Join(walDir, "wal_0.log"), walData, 0644); err != nil {
+		t.Fatalf("Failed to restore WAL: %v", err)
+	}
+	service, err = NewLSMTableServiceWithOptions(tmpDir, options)
+	if err != nil {
+		t.Fatalf("Failed to reopen LSM service: %v", err)
+	}
+	defer service.Close()
+
+	if _, err := os.Stat(filepath.Join(walDir, "wal_0.log")); !os.IsNotExist(err) {
+		t.Errorf("Expected the obsolete WAL to be deleted on open, got %v", err)
+	}
+	// Only the WAL holding key40 is replayed
+	stats = service.GetRecoveryStats()
+	if stats.ObsoleteWALs != 1 || stats.ReplayedWALs != 1 || stats.ReplayedEntries != 1 || stats.LoadedSSTables == 0 {
+		t.Errorf("Unexpected recovery stats after reopening: %+v", stats)
+	}
+	if value, err := service.Get([]byte("key40")); err != nil || string(value) != "new" {
+		t.Errorf("Expected key40 to be recovered, got %q (err %v)", value, err)
+	}
+	for i := 0; i < 40; i++ {
+		value, err := service.Get([]byte(fmt.Sprintf("key%02d", i)))
+		if err != nil || len(value) != 100 {
+			t.Errorf("key%02d: expected the recovered value, got %q (err %v)", i, value, err)
+		}
+	}
+}
+
+func TestLSMTableServiceRecoveryReplaysMerges(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_recovery_merge")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	// A WAL left behind by a run that crashed after merging into a key
+	walDir := filepath.Join(tmpDir, "wal")
+	wal, err := model.NewWAL(walDir, "wal_0.log")
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	put := model.NewPutEntry([]byte("counter"), []byte("10"))
+	put.SetSequence(1)
+	merge := model.NewMergeEntry([]byte("counter"), []byte("5"))
+	merge.SetSequence(2)
+	for _, entry := range []*model.Entry{put, merge} {
+		if err := wal.WriteEntry(entry); err != nil {
+			t.Fatalf("Failed to write entry: %v", err)
+		}
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatalf("Failed to close WAL: %v", err)
+	}
+
+	// Without an operator the merge cannot be replayed, and the WAL is left for the next attempt
+	if _, err := NewLSMTableService(tmpDir, 10); !errors.Is(err, model.ErrNoMergeOperator) {
+		t.Fatalf("Expected ErrNoMergeOperator, got %v", err)
+	}
+
+	options := DefaultOptions()
+	options.MergeOperator = model.NewInt64AddOperator()
+	service, err := NewLSMTableServiceWithOptions(tmpDir, options)
+	if err != nil {
+		t.Fatalf("Failed to open LSM service: %v", err)
+	}
+	defer service.Close()
+
+	if value, err := service.Get([]byte("counter")); err != nil || string(value) != "15" {
+		t.Errorf("Expected 15, got %q (err %v)", value, err)
+	}
+	if err := service.Merge([]byte("counter"), []byte("1")); err != nil {
+		t.Fatalf("Failed to merge: %v", err)
+	}
+	if value, err := service.Get([]byte("counter")); err != nil || string(value) != "16" {
+		t.Errorf("Expected 16, got %q (err %v)", value, err)
+	}
+}
+
+func TestLSMTableServiceRecoverySkipsFlushedEntries(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_recovery_flushed")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	options := DefaultOptions()
+	options.MaxTableSize = 2
+	options.MergeOperator = model.NewInt64AddOperator()
+	service, err := NewLSMTableServiceWithOptions(tmpDir, options)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+
+	// The flushed memtables share a WAL with the active one, so the WAL outlives the flush
+	for _, key := range []string{"c", "a", "b"} {
+		if err := service.Put([]byte(key), []byte("10")); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	if err := service.Merge([]byte("c"), []byte("1")); err != nil {
+		t.Fatalf("Failed to merge: %v", err)
+	}
+	if err := service.Put([]byte("d"), []byte("10")); err != nil {
+		t.Fatalf("Failed to put d: %v", err)
+	}
+	if err := service.Close(); err != nil {
+		t.Fatalf("Failed to close service: %v", err)
+	}
+
+	service, err = NewLSMTableServiceWithOptions(tmpDir, options)
+	if err != nil {
+		t.Fatalf("Failed to reopen LSM service: %v", err)
+	}
+	defer service.Close()
+
+	// Replaying the flushed merge again would add its operand twice
+	if value, err := service.Get([]byte("c")); err != nil || string(value) != "11" {
+		t.Errorf("Expected 11, got %q (err %v)", value, err)
+	}
+	for _, key := range []string{"a", "b", "d"} {
+		if value, err := service.Get([]byte(key)); err != nil || string(value) != "10" {
+			t.Errorf("Expected 10 for %s, got %q (err %v)", key, value, err)
+		}
+	}
+}
+
+func TestLSMTableServiceLocksDataDirectory(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_lock")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 10)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+
+	// A second service would replay and flush the same WAL files
+	if _, err := NewLSMTableService(tmpDir, 10); !errors.Is(err, model.ErrDirectoryLocked) {
+		t.Errorf("Expected ErrDirectoryLocked, got %v", err)
+	}
+
+	if err := service.Close(); err != nil {
+		t.Fatalf("Failed to close service: %v", err)
+	}
+	service, err = NewLSMTableService(tmpDir, 10)
+	if err != nil {
+		t.Fatalf("Failed to reopen LSM service after close: %v", err)
+	}
+	service.Close()
+}
+
+func TestLSMTableServiceRemovesOrphanedFiles(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_orphaned_files")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 2)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	if err := service.CreateColumnFamily("users", ColumnFamilyOptions{MaxTableSize: 2}); err != nil {
+		t.Fatalf("Failed to create column family: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err := service.Put(key, []byte("value")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+		if err := service.PutCF("users", key, []byte("user")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	if err := service.Close(); err != nil {
+		t.Fatalf("Failed to close service: %v", err)
+	}
+
+	// Files a crashed flush or compaction left behind, never recorded in the manifest
+	sstableDir := filepath.Join(tmpDir, "sstables")
+	orphans := []string{
+		filepath.Join(sstableDir, "sstable_99.sst"),
+		filepath.Join(sstableDir, "sstable_100.sst.tmp"),
+		filepath.Join(sstableDir, "users", "sstable_101.sst"),
+	}
+	for _, path := range orphans {
+		if err := os.WriteFile(path, []byte("partial"), 0644); err != nil {
+			t.Fatalf("Failed to write orphaned file: %v", err)
+		}
+	}
+
+	service, err = NewLSMTableService(tmpDir, 2)
+	if err != nil {
+		t.Fatalf("Failed to reopen LSM service: %v", err)
+	}
+	defer service.Close()
+
+	for _, path := range orphans {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be deleted, got %v", path, err)
+		}
+	}
+	if stats := service.GetRecoveryStats(); stats.OrphanedFiles != len(orphans) || stats.LoadedSSTables == 0 {
+		t.Errorf("Unexpected recovery stats: %+v", stats)
+	}
+	for i := 0; i < 5; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if value, err := service.Get(key); err != nil || string(value) != "value" {
+			t.Errorf("%s: expected value, got %q (err %v)", key, value, err)
+		}
+		if value, err := service.GetCF("users", key); err != nil || string(value) != "user" {
+			t.Errorf("%s: expected user, got %q (err %v)", key, value, err)
+		}
+	}
+}
+
+func TestLSMTableServiceManifestSaveFailure(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_manifest_failure")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 2)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+
+	for i := 0; i < 6; i++ {
+		if err := service.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	for {
+		if _, immutables := service.GetMemTableStats(); immutables == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	countTables := func() int {
+		files, _ := filepath.Glob(filepath.Join(tmpDir, "sstables", "*.sst"))
+		return len(files)
+	}
+	tables := countTables()
+
+	// A directory in place of the manifest's temporary file makes every save fail
+	blocker := filepath.Join(tmpDir, model.ManifestFileName+model.TempFileSuffix)
+	if err := os.Mkdir(blocker, 0755); err != nil {
+		t.Fatalf("Failed to block manifest: %v", err)
+	}
+
+	// A compaction whose edit cannot be recorded keeps its inputs and deletes its output
+	service.mu.Lock()
+	cf := service.defaultFamily
+	inputs := append([]*model.SSTable{}, cf.sstablesByLevel[0]...)
+	builder := model.NewSSTableBuilder(1, 8)
+	for _, input := range inputs {
+		entries, err := input.GetAllEntries()
+		if err != nil {
+			t.Fatalf("Failed to read SSTable: %v", err)
+		}
+		for _, entry := range entries {
+			builder.AddEntry(entry)
+		}
+	}
+	output, err := builder.Build(cf.sstableDir, "sstable_level_1_output.sst")
+	if err != nil {
+		t.Fatalf("Failed to build SSTable: %v", err)
+	}
+	err = service.applyVersionEdit(cf, &model.VersionEdit{DeletedTables: inputs, AddedTables: []*model.SSTable{output}})
+	level0, level1 := len(cf.sstablesByLevel[0]), len(cf.sstablesByLevel[1])
+	service.mu.Unlock()
+	if err == nil {
+		t.Fatal("Expected the edit to fail")
+	}
+	if level0 != len(inputs) || level1 != 0 || countTables() != tables {
+		t.Errorf("Expected the edit to be abandoned, got %d level 0 and %d level 1 tables and %d files", level0, level1, countTables())
+	}
+
+	// A flush that cannot be recorded keeps its memtable
+	for i := 6; i < 8; i++ {
+		if err := service.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	service.stopBackgroundWork()
+	if _, immutables := service.GetMemTableStats(); immutables == 0 {
+		t.Error("Expected the memtable to stay unflushed")
+	}
+	service.mu.Lock()
+	err = service.flushPendingLocked()
+	service.mu.Unlock()
+	if err == nil {
+		t.Error("Expected the flush to fail")
+	}
+	if countTables() != tables {
+		t.Errorf("Expected the abandoned SSTables to be deleted, got %d files", countTables())
+	}
+	for i := 0; i < 8; i++ {
+		if _, err := service.Get([]byte(fmt.Sprintf("key%d", i))); err != nil {
+			t.Errorf("key%d: %v", i, err)
+		}
+	}
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatalf("Failed to unblock manifest: %v", err)
+	}
+	if err := service.Close(); err != nil {
+		t.Fatalf("Failed to close service: %v", err)
+	}
+	service, err = NewLSMTableService(tmpDir, 2)
+	if err != nil {
+		t.Fatalf("Failed to reopen LSM service: %v", err)
+	}
+	defer service.Close()
+	for i := 0; i < 8; i++ {
+		if _, err := service.Get([]byte(fmt.Sprintf("key%d", i))); err != nil {
+			t.Errorf("key%d after reopening: %v", i, err)
+		}
+	}
+}
+
+func TestLSMTableServiceRotationKeepsLoggedEntries(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_rotation_log_number")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 2)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	// The batch is logged to wal_0 as one record; its third entry rotates the memtable to wal_1
+	batch := model.NewWriteBatch()
+	batch.Put([]byte("key1"), []byte("value1"))
+	batch.Put([]byte("key2"), []byte("value2"))
+	batch.Put([]byte("key3"), []byte("value3"))
+	if err := service.Write(batch); err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	if current := service.currentWALNumber(); current != 1 {
+		t.Fatalf("Expected the batch to rotate to wal_1, current is wal_%d", current)
+	}
+	// wal_0 must outlive the flush of the first memtable, as key3 is only logged there
+	if logNumber := service.defaultFamily.activeTable.LogNumber(); logNumber != 0 {
+		t.Errorf("Expected the new memtable to keep wal_0, got wal_%d", logNumber)
+	}
+}
+
+func TestLSMTableServicePutWithTTL(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_ttl")
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 3)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	if err := service.PutWithTTL([]byte("session"), []byte("data"), 20*time.Millisecond); err != nil {
+		t.Fatalf("Failed to put with TTL: %v", err)
+	}
+
+	value, err := service.Get([]byte("session"))
+	if err != nil {
+		t.Fatalf("Failed to get before expiry: %v", err)
+	}
+	if string(value) != "data" {
+		t.Errorf("Expected value data, got %s", value)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if _, err := service.Get([]byte("session")); err != model.ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound after expiry, got %v", err)
+	}
+
+	if err := service.PutWithTTL([]byte("session"), []byte("data"), 0); err == nil {
+		t.Error("Expected error for non-positive TTL")
+	}
+}
+
+func TestLSMTableServiceMerge(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_merge")
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 2)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	if err := service.Merge([]byte("counter"), []byte("1")); err != model.ErrNoMergeOperator {
+		t.Errorf("Expected ErrNoMergeOperator, got %v", err)
+	}
+
+	service.SetMergeOperator(model.NewInt64AddOperator())
+
+	if err := service.Put([]byte("counter"), []byte("10")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+
+	// Spread operands across memtable rotations
+	for i := 0; i < 5; i++ {
+		if err := service.Merge([]byte("counter"), []byte("1")); err != nil {
+			t.Fatalf("Failed to merge: %v", err)
+		}
+		if err := service.Put([]byte{byte(i)}, []byte("filler")); err != nil {
+			t.Fatalf("Failed to put filler: %v", err)
+		}
+	}
+
+	value, err := service.Get([]byte("counter"))
+	if err != nil {
+		t.Fatalf("Failed to get counter: %v", err)
+	}
+	if string(value) != "15" {
+		t.Errorf("Expected 15, got %s", value)
+	}
+
+	// Operands on a missing key start from an empty value
+	if err := service.Merge([]byte("fresh"), []byte("3")); err != nil {
+		t.Fatalf("Failed to merge: %v", err)
+	}
+	value, err = service.Get([]byte("fresh"))
+	if err != nil {
+		t.Fatalf("Failed to get fresh: %v", err)
+	}
+	if string(value) != "3" {
+		t.Errorf("Expected 3, got %s", value)
+	}
+
+	// A value merged onto one with a TTL expires with it
+	if err := service.PutWithTTL([]byte("session"), []byte("10"), 50*time.Millisecond); err != nil {
+		t.Fatalf("Failed to put with TTL: %v", err)
+	}
+	if err := service.Merge([]byte("session"), []byte("1")); err != nil {
+		t.Fatalf("Failed to merge: %v", err)
+	}
+	if value, err := service.Get([]byte("session")); err != nil || string(value) != "11" {
+		t.Errorf("Expected 11, got %q (err %v)", value, err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := service.Get([]byte("session")); err != model.ErrKeyNotFound {
+		t.Errorf("Expected the merged value to expire, got %v", err)
+	}
+}
+
+func TestLSMTableServiceCompareAndSwap(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_cas")
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 3)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	key := []byte("lock")
+
+	// Swapping a missing key conflicts
+	if err := service.CompareAndSwap(key, []byte("free"), []byte("held")); err != ErrConflict {
+		t.Errorf("Expected ErrConflict for missing key, got %v", err)
+	}
+
+	if err := service.PutIfAbsent(key, []byte("free")); err != nil {
+		t.Fatalf("Failed to put if absent: %v", err)
+	}
+	if err := service.PutIfAbsent(key, []byte("other")); err != ErrConflict {
+		t.Errorf("Expected ErrConflict for existing key, got %v", err)
+	}
+
+	if err := service.CompareAndSwap(key, []byte("free"), []byte("held")); err != nil {
+		t.Fatalf("Failed to compare and swap: %v", err)
+	}
+	if err := service.CompareAndSwap(key, []byte("free"), []byte("held")); err != ErrConflict {
+		t.Errorf("Expected ErrConflict for stale expected value, got %v", err)
+	}
+
+	value, err := service.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get: %v", err)
+	}
+	if string(value) != "held" {
+		t.Errorf("Expected held, got %s", value)
+	}
+
+	// A deleted key is absent again
+	if err := service.Delete(key); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if err := service.PutIfAbsent(key, []byte("free")); err != nil {
+		t.Errorf("Expected put if absent to succeed after delete, got %v", err)
+	}
+}
+
+func TestLSMTableServicePutIfVersion(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_version")
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 3)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	key := []byte("doc")
+	if err := service.Put(key, []byte("v1")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+
+	_, version, err := service.GetWithVersion(key)
+	if err != nil {
+		t.Fatalf("Failed to get with version: %v", err)
+	}
+	if version == 0 {
+		t.Fatal("Expected non-zero version token")
+	}
+
+	if err := service.PutIfVersion(key, []byte("v2"), version); err != nil {
+		t.Fatalf("Failed to put with matching version: %v", err)
+	}
+
+	// The old token is stale now
+	if err := service.PutIfVersion(key, []byte("v3"), version); err != ErrConflict {
+		t.Errorf("Expected ErrConflict for stale version, got %v", err)
+	}
+
+	value, newVersion, err := service.GetWithVersion(key)
+	if err != nil {
+		t.Fatalf("Failed to get with version: %v", err)
+	}
+	if string(value) != "v2" {
+		t.Errorf("Expected v2, got %s", value)
+	}
+	if newVersion <= version {
+		t.Errorf("Expected version to increase past %d, got %d", version, newVersion)
+	}
+}
+
+func TestLSMTableServiceWriteBatch(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_batch")
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 2)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	if err := service.Put([]byte("stale"), []byte("value")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+
+	// The batch spans a memtable rotation
+	batch := model.NewWriteBatch()
+	batch.Put([]byte("a"), []byte("1"))
+	batch.Put([]byte("b"), []byte("2"))
+	batch.Put([]byte("c"), []byte("3"))
+	batch.Delete([]byte("stale"))
+
+	if err := service.Write(batch); err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+
+	for key, expected := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		value, err := service.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("Failed to get %s: %v", key, err)
+		}
+		if string(value) != expected {
+			t.Errorf("Key %s: expected %s, got %s", key, expected, value)
+		}
+	}
+	if _, err := service.Get([]byte("stale")); err != model.ErrKeyNotFound {
+		t.Errorf("Expected stale to be deleted, got %v", err)
+	}
+
+	// Merges in a batch need an operator
+	mergeBatch := model.NewWriteBatch()
+	mergeBatch.Merge([]byte("counter"), []byte("1"))
+	if err := service.Write(mergeBatch); err != model.ErrNoMergeOperator {
+		t.Errorf("Expected ErrNoMergeOperator, got %v", err)
+	}
+}
+
+func TestLSMTableServiceWriteBatchIsAtomicForReaders(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_batch_atomic")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	// Memtables of 1KB fill every few batches, so some batches are split across two memtables
+	options := DefaultOptions()
+	options.WriteBufferSize = 1024
+	service, err := NewLSMTableServiceWithOptions(tmpDir, options)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	const writes = 2000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= writes; i++ {
+			value := []byte(strconv.Itoa(i))
+			batch := model.NewWriteBatch()
+			batch.Put([]byte("a"), value)
+			batch.Put([]byte("b"), value)
+			if err := service.WriteWithOptions(batch, WriteOptions{}); err != nil {
+				t.Errorf("Failed to write batch: %v", err)
+				return
+			}
+		}
+	}()
+
+	// a is written first in every batch, so b can never be older than the a read before it
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				a, errA := service.Get([]byte("a"))
+				b, errB := service.Get([]byte("b"))
+				if errA == model.ErrKeyNotFound {
+					continue
+				}
+				if errA != nil || errB != nil {
+					t.Errorf("Failed to get: %v, %v", errA, errB)
+					return
+				}
+				numA, _ := strconv.Atoi(string(a))
+				numB, _ := strconv.Atoi(string(b))
+				if numB < numA {
+					t.Errorf("Saw half of a batch: a=%d, b=%d", numA, numB)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	<-done
+}
+
+func TestLSMTableServiceParallelCompaction(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_parallel_compaction")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	if _, err := NewLSMTableServiceWithOptions(tmpDir, Options{MaxTableSize: 4}); err == nil {
+		t.Error("Expected error for zero compaction workers")
+	}
+
+	service, err := NewLSMTableServiceWithOptions(tmpDir, Options{MaxTableSize: 4, CompactionWorkers: 4})
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+
+	// Reads run while flushes and compactions happen in the background
+	const numKeys = 200
+	for i := 0; i < numKeys; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		if err := service.Put(key, []byte(fmt.Sprintf("value%d", i))); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+		if i%10 == 0 {
+			for j := 0; j <= i; j += 7 {
+				value, err := service.Get([]byte(fmt.Sprintf("key%03d", j)))
+				if err != nil {
+					t.Fatalf("Failed to get key%03d: %v", j, err)
+				}
+				if expected := fmt.Sprintf("value%d", j); string(value) != expected {
+					t.Fatalf("key%03d: expected %s, got %s", j, expected, value)
+				}
+			}
+		}
+	}
+
+	// Close waits for running compactions to be installed
+	if err := service.Close(); err != nil {
+		t.Fatalf("Failed to close service: %v", err)
+	}
+	if running := service.defaultFamily.compactionManager.RunningCompactions(); running != 0 {
+		t.Errorf("Expected no running compactions after close, got %d", running)
+	}
+	for i := 0; i < numKeys; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		value, err := service.Get(key)
+		if err != nil {
+			t.Fatalf("Failed to get %s after close: %v", key, err)
+		}
+		if expected := fmt.Sprintf("value%d", i); string(value) != expected {
+			t.Errorf("%s: expected %s, got %s", key, expected, value)
+		}
+	}
+}
+
+func TestLSMTableServiceCompactRange(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_compact_range")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 5)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	if _, ok := service.ManualCompactionProgress(); ok {
+		t.Error("Expected no manual compaction progress before the first one")
+	}
+
+	// Bulk delete every other key
+	for i := 0; i < 40; i++ {
+		if err := service.Put([]byte(fmt.Sprintf("key%02d", i)), []byte("value")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	for i := 0; i < 40; i += 2 {
+		if err := service.Delete([]byte(fmt.Sprintf("key%02d", i))); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+	}
+
+	if err := service.CompactRange(nil, nil); err != nil {
+		t.Fatalf("Failed to compact range: %v", err)
+	}
+
+	progress, ok := service.ManualCompactionProgress()
+	if !ok || !progress.Done || progress.Err != nil || progress.TasksCompleted == 0 {
+		t.Errorf("Unexpected progress: %+v", progress)
+	}
+
+	// Everything now lives in one level without tombstones
+	service.mu.RLock()
+	levels := 0
+	for _, tables := range service.defaultFamily.sstablesByLevel {
+		if len(tables) == 0 {
+			continue
+		}
+		levels++
+		for _, table := range tables {
+			if table.Metadata().TombstoneCount != 0 {
+				t.Errorf("Expected tombstones to be purged, found %d", table.Metadata().TombstoneCount)
+			}
+		}
+	}
+	activeSize := service.defaultFamily.activeTable.Size()
+	service.mu.RUnlock()
+	if levels != 1 {
+		t.Errorf("Expected tables in a single level, got %d levels", levels)
+	}
+	if activeSize != 0 {
+		t.Errorf("Expected memtable to be flushed, got %d entries", activeSize)
+	}
+
+	for i := 0; i < 40; i++ {
+		value, err := service.Get([]byte(fmt.Sprintf("key%02d", i)))
+		if i%2 == 0 {
+			if err != model.ErrKeyNotFound {
+				t.Errorf("key%02d: expected deleted, got %s, %v", i, value, err)
+			}
+		} else if err != nil {
+			t.Errorf("key%02d: %v", i, err)
+		}
+	}
+
+	if err := service.CompactRangeCF("missing", nil, nil); !errors.Is(err, ErrColumnFamilyNotFound) {
+		t.Errorf("Expected ErrColumnFamilyNotFound, got %v", err)
+	}
+}
+
+func TestLSMTableServiceCompactRangeFlushFailure(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_compact_range_flush_failure")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 2)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	// Flushes fail while the manifest cannot be saved
+	blocker := filepath.Join(tmpDir, model.ManifestFileName+model.TempFileSuffix)
+	if err := os.Mkdir(blocker, 0755); err != nil {
+		t.Fatalf("Failed to block manifest: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := service.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+
+	compactRange := func() error {
+		result := make(chan error, 1)
+		go func() { result <- service.CompactRange(nil, nil) }()
+		select {
+		case err := <-result:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("CompactRange did not return")
+			return nil
+		}
+	}
+
+	// The compaction gives up instead of waiting for memtables that cannot be flushed
+	if err := compactRange(); err == nil {
+		t.Error("Expected CompactRange to fail while memtables cannot be flushed")
+	}
+	if progress, ok := service.ManualCompactionProgress(); !ok || progress.Err == nil {
+		t.Errorf("Expected the failure in the progress, got %+v", progress)
+	}
+
+	// Once flushes work again, the next compaction retries them
+	if err := os.Remove(blocker); err != nil {
+		t.Fatalf("Failed to unblock manifest: %v", err)
+	}
+	if err := compactRange(); err != nil {
+		t.Fatalf("Failed to compact range: %v", err)
+	}
+	if _, immutables := service.GetMemTableStats(); immutables != 0 {
+		t.Errorf("Expected every memtable to be flushed, got %d", immutables)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := service.Get([]byte(fmt.Sprintf("key%d", i))); err != nil {
+			t.Errorf("key%d: %v", i, err)
+		}
+	}
+}
+
+func TestLSMTableServiceRetriesFailedFlushes(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_flush_retry")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 2)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	// A directory in place of the manifest's temporary file makes every save fail
+	blocker := filepath.Join(tmpDir, model.ManifestFileName+model.TempFileSuffix)
+	if err := os.Mkdir(blocker, 0755); err != nil {
+		t.Fatalf("Failed to block manifest: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := service.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	for {
+		service.mu.Lock()
+		failures := service.flushFailures
+		service.mu.Unlock()
+		if failures > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// No further write rotates a memtable, so only the worker's own retry can flush it
+	if err := os.Remove(blocker); err != nil {
+		t.Fatalf("Failed to unblock manifest: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, immutables := service.GetMemTableStats(); immutables == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the failed flush to be retried")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLSMTableServiceStoppedWritesFailWithFlushes(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_stopped_write_failure")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	options := DefaultOptions()
+	options.MaxTableSize = 2
+	options.WriteStall = WriteStallOptions{StopImmutableMemTables: 1}
+	service, err := NewLSMTableServiceWithOptions(tmpDir, options)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	// A directory in place of the manifest's temporary file makes every save fail
+	blocker := filepath.Join(tmpDir, model.ManifestFileName+model.TempFileSuffix)
+	if err := os.Mkdir(blocker, 0755); err != nil {
+		t.Fatalf("Failed to block manifest: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := service.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+
+	// The queued memtable stops writes and cannot be flushed
+	done := make(chan error, 1)
+	go func() {
+		done <- service.Put([]byte("key3"), []byte("value"))
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected the stopped write to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stopped write still waiting for a failing flush")
+	}
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatalf("Failed to unblock manifest: %v", err)
+	}
+	for {
+		if _, immutables := service.GetMemTableStats(); immutables == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := service.Put([]byte("key3"), []byte("value")); err != nil {
+		t.Errorf("Expected writes to continue once flushes succeed, got %v", err)
+	}
+}
+
+func TestLSMTableServiceWriteStalls(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_write_stalls")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	options := DefaultOptions()
+	options.MaxTableSize = 100
+	options.WriteStall = WriteStallOptions{
+		SlowdownImmutableMemTables: 1,
+		StopImmutableMemTables:     2,
+		SlowdownDelay:              50 * time.Millisecond,
+	}
+	service, err := NewLSMTableServiceWithOptions(tmpDir, options)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	// Hold back memtables as if flushes fell behind; they are not queued, so only release catches up
+	cf := service.defaultFamily
+	release := func() {
+		service.mu.Lock()
+		defer service.mu.Unlock()
+		cf.immutableTables = cf.immutableTables[1:]
+		service.backgroundWorkDone.Broadcast()
+	}
+	service.mu.Lock()
+	cf.immutableTables = append(cf.immutableTables, service.newMemTable(cf))
+	service.mu.Unlock()
+
+	stats := service.GetWriteStallStats()
+	if stats.Condition != WriteStallDelayed || stats.Reason == "" {
+		t.Errorf("Expected delayed writes with a reason, got %+v", stats)
+	}
+	start := time.Now()
+	if err := service.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected write to be delayed, took %v", elapsed)
+	}
+
+	// Past the stop threshold writes wait until a flush catches up
+	service.mu.Lock()
+	cf.immutableTables = append(cf.immutableTables, service.newMemTable(cf))
+	service.mu.Unlock()
+	if condition := service.GetWriteStallStats().Condition; condition != WriteStallStopped {
+		t.Errorf("Expected stopped writes, got %s", condition)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- service.Put([]byte("b"), []byte("2"))
+	}()
+	// Measure from the moment the write is stopped, not from when its goroutine was started
+	for service.GetWriteStallStats().StoppedWrites == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Expected write to be stopped, returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected write to continue after flush")
+	}
+
+	stats = service.GetWriteStallStats()
+	if stats.DelayedWrites != 2 || stats.StoppedWrites != 1 || stats.StopTime < 100*time.Millisecond {
+		t.Errorf("Unexpected stall stats: %+v", stats)
+	}
+	release()
+	if condition := service.GetWriteStallStats().Condition; condition != WriteStallNone {
+		t.Errorf("Expected writes to proceed normally, got %s", condition)
+	}
+}
+
+func TestLSMTableServiceFlushPipeline(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_flush_pipeline")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 2)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	// Three rotations; the last entry stays in the active memtable
+	for i := 0; i < 7; i++ {
+		if err := service.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, immutableCount := service.GetMemTableStats(); immutableCount == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for flushes")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Memtables are flushed in rotation order
+	service.mu.RLock()
+	level0 := append([]*model.SSTable{}, service.defaultFamily.sstablesByLevel[0]...)
+	queued := len(service.flushQueue)
+	service.mu.RUnlock()
+	if len(level0) != 3 || queued != 0 {
+		t.Fatalf("Expected 3 level 0 tables and an empty queue, got %d tables and %d queued", len(level0), queued)
+	}
+	for i := 1; i < len(level0); i++ {
+		if level0[i].Metadata().MinSequence <= level0[i-1].Metadata().MaxSequence {
+			t.Errorf("Table %d was flushed out of order", i)
+		}
+	}
+
+	// Only the WALs of the active memtable are left: key6 was logged to wal_2 before it rotated the memtable;
+	// obsolete ones were recycled or deleted
+	walFiles, err := filepath.Glob(filepath.Join(tmpDir, "wal", "wal_*.log"))
+	if err != nil {
+		t.Fatalf("Failed to list WAL files: %v", err)
+	}
+	if len(walFiles) != 2 || filepath.Base(walFiles[0]) != "wal_2.log" || filepath.Base(walFiles[1]) != "wal_3.log" {
+		t.Errorf("Expected only wal_2.log and wal_3.log to remain, got %v", walFiles)
+	}
+
+	for i := 0; i < 7; i++ {
+		value, err := service.Get([]byte(fmt.Sprintf("key%d", i)))
+		if err != nil || string(value) != fmt.Sprintf("value%d", i) {
+			t.Errorf("key%d: expected value%d, got %s (err %v)", i, i, value, err)
+		}
+	}
+}
+
+func TestLSMTableServiceRecyclesWALFiles(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_wal_recycling")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	// A recycled file left by an earlier run is not reused: WAL numbers start over
+	walDir := filepath.Join(tmpDir, "wal")
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatalf("Failed to create WAL directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(walDir, "recycled_7.log"), []byte("stale"), 0644); err != nil {
+		t.Fatalf("Failed to write recycled WAL: %v", err)
+	}
+
+	options := DefaultOptions()
+	options.MaxTableSize = 1
+	options.RecycledWALFiles = 1
+	service, err := NewLSMTableServiceWithOptions(tmpDir, options)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	listWALDir := func() []string {
+		files, err := os.ReadDir(walDir)
+		if err != nil {
+			t.Fatalf("Failed to read WAL directory: %v", err)
+		}
+		names := make([]string, 0, len(files))
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		return names
+	}
+	if names := listWALDir(); len(names) != 1 || names[0] != "wal_0.log" {
+		t.Fatalf("Expected only wal_0.log at startup, got %v", names)
+	}
+
+	// Each put rotates the memtable; waiting for its flush makes the WAL before the previous one obsolete,
+	// as the put was logged to the previous WAL before it rotated
+	for i := 0; i < 4; i++ {
+		if err := service.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if _, immutableCount := service.GetMemTableStats(); immutableCount == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Timed out waiting for flushes")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// wal_0 was kept and became wal_3; wal_1 waits for the next rotation
+	if names := listWALDir(); len(names) != 3 || names[0] != "recycled_1.log" || names[1] != "wal_2.log" || names[2] != "wal_3.log" {
+		t.Errorf("Expected [recycled_1.log wal_2.log wal_3.log], got %v", names)
+	}
+
+	for i := 0; i < 4; i++ {
+		value, err := service.Get([]byte(fmt.Sprintf("key%d", i)))
+		if err != nil || string(value) != fmt.Sprintf("value%d", i) {
+			t.Errorf("key%d: expected value%d, got %s (err %v)", i, i, value, err)
+		}
+	}
+}
+
+func TestLSMTableServiceReadsDoNotBlockWrites(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_versions")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 5)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	if err := service.Put([]byte("pinned"), []byte("old")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := service.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+
+	// A long-running read holds the current version, whose only copy of the key is in an SSTable
+	version := service.defaultFamily.acquireVersion()
+
+	// Writers, flushes and compactions continue meanwhile, alongside concurrent readers
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if _, err := service.Get([]byte("key0")); err != nil && err != model.ErrKeyNotFound {
+					t.Errorf("Concurrent read failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := service.Put([]byte(fmt.Sprintf("key%d", i%20)), []byte(fmt.Sprintf("value%d", i))); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	if err := service.Put([]byte("pinned"), []byte("new")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := service.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+	close(done)
+	readers.Wait()
+
+	// The pinned version still sees the value it started with, although its tables were compacted away
+	value, err := version.Lookup([]byte("pinned")).Value()
+	if err != nil || string(value) != "old" {
+		t.Errorf("Expected pinned version to read old, got %s (err %v)", value, err)
+	}
+	version.Unref()
+
+	value, err = service.Get([]byte("pinned"))
+	if err != nil || string(value) != "new" {
+		t.Errorf("Expected new, got %s (err %v)", value, err)
+	}
+	for i := 80; i < 100; i++ {
+		value, err := service.Get([]byte(fmt.Sprintf("key%d", i%20)))
+		if err != nil || string(value) != fmt.Sprintf("value%d", i) {
+			t.Errorf("key%d: expected value%d, got %s (err %v)", i%20, i, value, err)
+		}
+	}
+}
+
+func TestLSMTableServiceWriteBufferSizing(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_write_buffer")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	options := DefaultOptions()
+	options.WriteBufferSize = 4096
+	options.TotalWriteBufferSize = 6000
+	service, err := NewLSMTableServiceWithOptions(tmpDir, options)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	// Memtables are switched by bytes: the third large value does not fit into 4096 bytes
+	value := make([]byte, 1500)
+	for i := 0; i < 3; i++ {
+		if err := service.Put([]byte(fmt.Sprintf("key%d", i)), value); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	if activeSize, _ := service.GetMemTableStats(); activeSize != 1 {
+		t.Errorf("Expected the memtable to be switched after two values, active size is %d", activeSize)
+	}
+
+	// A family with a large buffer is switched once all active memtables use 7/8 of the service's buffer
+	if err := service.CreateColumnFamily("events", ColumnFamilyOptions{WriteBufferSize: 1 << 20}); err != nil {
+		t.Fatalf("Failed to create column family: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := service.PutCF("events", []byte(fmt.Sprintf("event%d", i)), make([]byte, 1200)); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	stats, err := service.GetColumnFamilyStats("events")
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if stats.ActiveMemTableSize != 0 {
+		t.Errorf("Expected the largest active memtable to be switched, events holds %d keys", stats.ActiveMemTableSize)
+	}
+
+	// Flushed memtables return their memory
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		defaultStats, _ := service.GetColumnFamilyStats(DefaultColumnFamily)
+		eventStats, _ := service.GetColumnFamilyStats("events")
+		if defaultStats.ImmutableCount == 0 && eventStats.ImmutableCount == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for flushes")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	memoryUsage, bufferSize := service.GetWriteBufferStats()
+	defaultStats, _ := service.GetColumnFamilyStats(DefaultColumnFamily)
+	if bufferSize != 6000 || memoryUsage != int64(defaultStats.ActiveMemTableBytes) {
+		t.Errorf("Expected only the active default memtable (%d bytes) to be accounted, got %d of %d", defaultStats.ActiveMemTableBytes, memoryUsage, bufferSize)
+	}
+
+	for i := 0; i < 3; i++ {
+		if got, err := service.GetCF("events", []byte(fmt.Sprintf("event%d", i))); err != nil || len(got) != 1200 {
+			t.Errorf("event%d: expected 1200 bytes, got %d (err %v)", i, len(got), err)
+		}
+	}
+}
+
+func BenchmarkLSMTableServicePut(b *testing.B) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_bench_put")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableServiceWithOptions(tmpDir, DefaultOptions())
+	if err != nil {
+		b.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	keys := make([][]byte, 1024)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key%08d", i))
+	}
+	value := make([]byte, 100)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := service.Put(keys[i%len(keys)], value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLSMTableServiceGet(b *testing.B) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_bench_get")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableServiceWithOptions(tmpDir, DefaultOptions())
+	if err != nil {
+		b.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	keys := make([][]byte, 1024)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key%08d", i))
+		if err := service.Put(keys[i], make([]byte, 100)); err != nil {
+			b.Fatalf("Failed to put: %v", err)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := service.Get(keys[i%len(keys)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestLSMTableServiceWriteOptions(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_write_options")
+	os.RemoveAll(tmpDir)
+	defer os.RemoveAll(tmpDir)
+
+	options := DefaultOptions()
+	options.WALSyncInterval = 10 * time.Millisecond
+	service, err := NewLSMTableServiceWithOptions(tmpDir, options)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	if err := service.Put([]byte("sync"), []byte("1")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := service.PutWithOptions([]byte("async"), []byte("2"), WriteOptions{}); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := service.PutWithOptions([]byte("nowal"), []byte("3"), WriteOptions{DisableWAL: true}); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := service.PutWithTTLWithOptions([]byte("ttl"), []byte("4"), time.Hour, WriteOptions{DisableWAL: true}); err != nil {
+		t.Fatalf("Failed to put with TTL: %v", err)
+	}
+	if err := service.PutCFWithOptions(DefaultColumnFamily, []byte("cf"), []byte("5"), 0, WriteOptions{}); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	batch := model.NewWriteBatch()
+	batch.Delete([]byte("sync"))
+	if err := service.WriteWithOptions(batch, WriteOptions{}); err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+
+	// Every write is visible, whatever its durability
+	if _, err := service.Get([]byte("sync")); err != model.ErrKeyNotFound {
+		t.Errorf("Expected sync to be deleted, got %v", err)
+	}
+	for key, expected := range map[string]string{"async": "2", "nowal": "3", "ttl": "4", "cf": "5"} {
+		if value, err := service.Get([]byte(key)); err != nil || string(value) != expected {
+			t.Errorf("%s: expected %s, got %s (err %v)", key, expected, value, err)
+		}
+	}
+
+	// The periodic sync catches up with the unsynced writes
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		service.mu.RLock()
+		unsynced := service.walUnsynced
+		service.mu.RUnlock()
+		if !unsynced {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the WAL to be synced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Async writes reached the WAL, writes without WAL did not
+	wal, err := model.NewWAL(filepath.Join(tmpDir, "wal"), "wal_0.log")
+	if err != nil {
+		t.Fatalf("Failed to open WAL: %v", err)
+	}
+	defer wal.Close()
+	entries, err := wal.Recover()
+	if err != nil {
+		t.Fatalf("Failed to read WAL: %v", err)
+	}
+	logged := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		logged = append(logged, string(entry.Key()))
+	}
+	if strings.Join(logged, ",") != "sync,async,cf,sync" {
+		t.Errorf("Expected sync,async,cf,sync in the WAL, got %v", logged)
+	}
+}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bloom0716/mini-bigtable/internal/model"
+)
+
+// RecoveryStats describes what the service recovered from its data directory when it was opened
+type RecoveryStats struct {
+	LoadedSSTables  int    // SSTables reopened from the manifest
+	OrphanedFiles   int    // SSTable and temporary files the manifest does not list, deleted
+	ObsoleteWALs    int    // WAL files of already flushed memtables, deleted without replay
+	ReplayedWALs    int    // WAL files replayed, flushed and deleted
+	ReplayedEntries int    // entries replayed from them
+	FlushedSSTables int    // level 0 tables written from the replayed entries
+	LastSequence    uint64 // sequence number new writes continue after
+	Duration        time.Duration
+}
+
+// GetRecoveryStats returns what the service recovered when it was opened; it does not change afterwards
+func (s *LSMTableService) GetRecoveryStats() RecoveryStats {
+	return s.recoveryStats
+}
+
+// loadSSTables opens the SSTables recorded in the manifest and installs them in their column families
+// Sequence numbers continue after the newest entry found on disk
+func (s *LSMTableService) loadSSTables() error {
+	loaded := make(map[*columnFamily]bool)
+	for _, descriptor := range s.manifest.SSTables {
+		cf, ok := s.familiesByID[descriptor.ColumnFamilyID]
+		if !ok {
+			return fmt.Errorf("%w: id %d", ErrColumnFamilyNotFound, descriptor.ColumnFamilyID)
+		}
+		table, err := model.OpenSSTable(cf.sstableDir, descriptor.FileName, descriptor.Level)
+		if err != nil {
+			return err
+		}
+		cf.sstablesByLevel[descriptor.Level] = append(cf.sstablesByLevel[descriptor.Level], table)
+		if table.Metadata().MaxSequence > s.lastSequence {
+			s.lastSequence = table.Metadata().MaxSequence
+		}
+		loaded[cf] = true
+		s.recoveryStats.LoadedSSTables++
+	}
+
+	for cf := range loaded {
+		s.installVersion(cf)
+	}
+	s.sstableCounter = s.manifest.NextSSTableNumber
+	return nil
+}
+
+// removeOrphanedFiles deletes the files in the families' SSTable directories that the manifest does not list:
+// temporary files of interrupted builds, and tables of flushes or compactions that crashed before being recorded
+func (s *LSMTableService) removeOrphanedFiles() error {
+	live := make(map[string]bool, len(s.manifest.SSTables))
+	for _, descriptor := range s.manifest.SSTables {
+		if cf, ok := s.familiesByID[descriptor.ColumnFamilyID]; ok {
+			live[filepath.Join(cf.sstableDir, descriptor.FileName)] = true
+		}
+	}
+
+	for _, cf := range s.families {
+		files, err := os.ReadDir(cf.sstableDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, file := range files {
+			// The default family's directory also holds the other families' directories
+			if file.IsDir() {
+				continue
+			}
+			name := file.Name()
+			if !strings.HasSuffix(name, ".sst") && !strings.HasSuffix(name, model.TempFileSuffix) {
+				continue
+			}
+			path := filepath.Join(cf.sstableDir, name)
+			if live[path] {
+				continue
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			s.recoveryStats.OrphanedFiles++
+		}
+	}
+	return nil
+}
+
+// findWALs picks up the WAL files of a previous run, before the first WAL of this run is created
+// Files older than the manifest's log number only hold flushed entries and are deleted; the others are left
+// for recoverWALs, and new files are numbered after them. Recycled files are kept for reuse: every record they
+// still hold carries an older log number than any file they can become
+func (s *LSMTableService) findWALs() error {
+	// Files older than this run's first one belong to recoverWALs, which deletes them itself
+	s.walCounter = s.manifest.LogNumber
+	defer func() { s.oldestWAL = s.walCounter }()
+
+	files, err := os.ReadDir(s.walDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var recycled []string
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasPrefix(name, "recycled_") {
+			recycled = append(recycled, name)
+			continue
+		}
+		number, ok := parseWALFileName(name)
+		if !ok {
+			continue
+		}
+		if number < s.manifest.LogNumber {
+			if err := os.Remove(filepath.Join(s.walDir, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			s.recoveryStats.ObsoleteWALs++
+			continue
+		}
+		s.unrecoveredWALs = append(s.unrecoveredWALs, number)
+		if number >= s.walCounter {
+			s.walCounter = number + 1
+		}
+	}
+	sort.Ints(s.unrecoveredWALs)
+
+	for _, name := range recycled {
+		if len(s.recycledWALs) < s.maxRecycledWALs {
+			s.recycledWALs = append(s.recycledWALs, name)
+		} else if err := os.Remove(filepath.Join(s.walDir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseWALFileName returns the number of a WAL file named by walFileName
+func parseWALFileName(name string) (int, bool) {
+	if !strings.HasPrefix(name, "wal_") || !strings.HasSuffix(name, ".log") {
+		return 0, false
+	}
+	number, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "wal_"), ".log"))
+	if err != nil || number < 0 {
+		return 0, false
+	}
+	return number, true
+}
+
+// recoverWALs replays the WAL files a previous run left behind, oldest first, while the service is opened
+// Each file is replayed into memtables of its column families, which are flushed to level 0 right away;
+// only once the manifest records the new SSTables is the file deleted, so a crash in between replays it again
+func (s *LSMTableService) recoverWALs() error {
+	for len(s.unrecoveredWALs) > 0 {
+		number := s.unrecoveredWALs[0]
+		if err := s.recoverWAL(number); err != nil {
+			return fmt.Errorf("failed to recover from WAL %d: %w", number, err)
+		}
+	}
+
+	for _, cf := range s.families {
+		if cf.compactionManager.ShouldCompact(cf.sstablesByLevel) {
+			s.scheduleCompaction()
+			break
+		}
+	}
+	return nil
+}
+
+// recoverWAL replays one WAL file into level 0 SSTables, records them and deletes the file
+func (s *LSMTableService) recoverWAL(number int) error {
+	entries, err := model.ReadWAL(s.walDir, walFileName(number), uint32(number))
+	if err != nil {
+		return err
+	}
+
+	// Replay entries into memtables of their families, keeping their original timestamps, TTLs and sequence numbers;
+	// a memtable is only as large as the family's write buffer, so a long WAL is replayed in bounded memory
+	tables := make(map[*columnFamily]*model.MemTable)
+	for _, entry := range entries {
+		cf, ok := s.familiesByID[entry.ColumnFamilyID()]
+		if !ok {
+			return fmt.Errorf("%w: id %d", ErrColumnFamilyNotFound, entry.ColumnFamilyID())
+		}
+		if entry.Sequence() > s.lastSequence {
+			s.lastSequence = entry.Sequence()
+		}
+		// The file may be live only for newer entries of another memtable; flushed ones are in the SSTables
+		if entry.Sequence() <= s.manifest.FlushedSequences[cf.id] {
+			continue
+		}
+		s.recoveryStats.ReplayedEntries++
+
+		table, ok := tables[cf]
+		if !ok {
+			table = s.newMemTable(cf)
+			tables[cf] = table
+		}
+		err := table.PutEntry(entry)
+		if err == model.ErrTableFull {
+			if err := s.flushRecoveredTable(cf, table); err != nil {
+				return err
+			}
+			table = s.newMemTable(cf)
+			tables[cf] = table
+			err = table.PutEntry(entry)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to replay entry during recovery: %w", err)
+		}
+	}
+	for cf, table := range tables {
+		if err := s.flushRecoveredTable(cf, table); err != nil {
+			return err
+		}
+	}
+
+	// The file is obsolete once the manifest lists the tables holding its entries
+	s.unrecoveredWALs = s.unrecoveredWALs[1:]
+	s.manifest.LogNumber = s.oldestLiveWAL()
+	if err := s.manifest.Save(); err != nil {
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
+	s.recoveryStats.ReplayedWALs++
+	return s.discardWAL(number)
+}
+
+// flushRecoveredTable writes a memtable replayed from the WAL to level 0 of its family
+func (s *LSMTableService) flushRecoveredTable(cf *columnFamily, table *model.MemTable) error {
+	defer table.Release()
+
+	sstable, err := s.buildFlushTable(flushJob{cf: cf, table: table}, s.nextSSTableFileName())
+	if err != nil {
+		return fmt.Errorf("failed to build SSTable: %w", err)
+	}
+	if sstable == nil {
+		return nil
+	}
+	cf.sstablesByLevel[0] = append(cf.sstablesByLevel[0], sstable)
+	s.installVersion(cf)
+	s.manifest.SetSSTables(cf.id, cf.sstablesByLevel)
+	s.manifest.SetFlushedSequence(cf.id, max(s.manifest.FlushedSequences[cf.id], sstable.Metadata().MaxSequence))
+	s.recoveryStats.FlushedSSTables++
+	return nil
+}
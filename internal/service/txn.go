@@ -0,0 +1,130 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Bloom0716/mini-bigtable/internal/model"
+)
+
+var (
+	ErrTxnDone = errors.New("transaction already committed or rolled back")
+)
+
+// Txn is an optimistic transaction
+// Writes are buffered until Commit, and every key read through the transaction is validated
+// at commit time: if any of them was written after the transaction's snapshot, Commit fails with ErrConflict
+type Txn struct {
+	service     *LSMTableService
+	snapshotSeq uint64
+	batch       *model.WriteBatch
+	pending     map[string]*model.Entry // latest buffered write per key, for read-your-own-writes
+	readSet     map[string]struct{}
+	done        bool
+}
+
+// BeginTxn starts a new optimistic transaction at the current sequence number
+func (s *LSMTableService) BeginTxn() *Txn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return &Txn{
+		service:     s,
+		snapshotSeq: s.lastSequence,
+		batch:       model.NewWriteBatch(),
+		pending:     make(map[string]*model.Entry),
+		readSet:     make(map[string]struct{}),
+	}
+}
+
+// SnapshotSequence returns the sequence number the transaction started at
+func (t *Txn) SnapshotSequence() uint64 {
+	return t.snapshotSeq
+}
+
+// Get reads a key, seeing the transaction's own buffered writes first
+// The key is added to the read set and validated on Commit
+func (t *Txn) Get(key []byte) ([]byte, error) {
+	if t.done {
+		return nil, ErrTxnDone
+	}
+
+	if entry, ok := t.pending[string(key)]; ok {
+		if entry.IsDeleted() {
+			return nil, model.ErrKeyNotFound
+		}
+		return entry.Value(), nil
+	}
+
+	t.readSet[string(key)] = struct{}{}
+	return t.service.Get(key)
+}
+
+// Put buffers a key-value pair to be written on Commit
+func (t *Txn) Put(key, value []byte) error {
+	if t.done {
+		return ErrTxnDone
+	}
+
+	t.batch.Put(key, value)
+	t.trackPending()
+	return nil
+}
+
+// Delete buffers a tombstone to be written on Commit
+func (t *Txn) Delete(key []byte) error {
+	if t.done {
+		return ErrTxnDone
+	}
+
+	t.batch.Delete(key)
+	t.trackPending()
+	return nil
+}
+
+// trackPending remembers the most recently buffered write for read-your-own-writes
+func (t *Txn) trackPending() {
+	entries := t.batch.Entries()
+	entry := entries[len(entries)-1]
+	t.pending[string(entry.Key())] = entry
+}
+
+// Commit validates the read set and applies all buffered writes as one atomic WAL record
+// Returns ErrConflict if any key read by the transaction changed after its snapshot
+func (t *Txn) Commit() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+
+	s := t.service
+	if err := s.throttleWrites(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate that nothing the transaction read has been written since its snapshot
+	for key := range t.readSet {
+		if s.lookup(s.defaultFamily, []byte(key)).Version() > t.snapshotSeq {
+			return ErrConflict
+		}
+	}
+
+	if t.batch.Count() == 0 {
+		return nil
+	}
+
+	if err := s.writeEntries(t.batch.Entries(), DefaultWriteOptions()); err != nil {
+		return fmt.Errorf("failed to apply transaction writes: %w", err)
+	}
+	return nil
+}
+
+// Rollback discards all buffered writes
+func (t *Txn) Rollback() {
+	t.done = true
+	t.batch.Clear()
+	t.pending = make(map[string]*model.Entry)
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Bloom0716/mini-bigtable/internal/model"
+)
+
+func TestTxnCommit(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_txn_commit")
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 3)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	if err := service.Put([]byte("from"), []byte("100")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+
+	txn := service.BeginTxn()
+
+	value, err := txn.Get([]byte("from"))
+	if err != nil {
+		t.Fatalf("Failed to get in txn: %v", err)
+	}
+	if string(value) != "100" {
+		t.Errorf("Expected 100, got %s", value)
+	}
+
+	txn.Put([]byte("from"), []byte("50"))
+	txn.Put([]byte("to"), []byte("50"))
+
+	// Buffered writes are visible to the transaction but not to others
+	value, err = txn.Get([]byte("to"))
+	if err != nil || string(value) != "50" {
+		t.Errorf("Expected own write 50, got %s (err %v)", value, err)
+	}
+	if _, err := service.Get([]byte("to")); err != model.ErrKeyNotFound {
+		t.Errorf("Expected uncommitted write to be invisible, got %v", err)
+	}
+
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+
+	for key, expected := range map[string]string{"from": "50", "to": "50"} {
+		value, err := service.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("Failed to get %s: %v", key, err)
+		}
+		if string(value) != expected {
+			t.Errorf("Key %s: expected %s, got %s", key, expected, value)
+		}
+	}
+
+	if err := txn.Commit(); err != ErrTxnDone {
+		t.Errorf("Expected ErrTxnDone on second commit, got %v", err)
+	}
+}
+
+func TestTxnConflict(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_txn_conflict")
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 3)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	if err := service.Put([]byte("balance"), []byte("100")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+
+	txn := service.BeginTxn()
+	if _, err := txn.Get([]byte("balance")); err != nil {
+		t.Fatalf("Failed to get in txn: %v", err)
+	}
+	// Reading a missing key also protects against it being created concurrently
+	if _, err := txn.Get([]byte("audit")); err != model.ErrKeyNotFound {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+	txn.Put([]byte("balance"), []byte("90"))
+
+	// A concurrent writer changes a key in the read set
+	if err := service.Put([]byte("audit"), []byte("entry")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+
+	if err := txn.Commit(); err != ErrConflict {
+		t.Fatalf("Expected ErrConflict, got %v", err)
+	}
+
+	// None of the transaction's writes were applied
+	value, err := service.Get([]byte("balance"))
+	if err != nil {
+		t.Fatalf("Failed to get: %v", err)
+	}
+	if string(value) != "100" {
+		t.Errorf("Expected 100, got %s", value)
+	}
+}
+
+func TestTxnRollback(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "lsm_test_txn_rollback")
+	defer os.RemoveAll(tmpDir)
+
+	service, err := NewLSMTableService(tmpDir, 3)
+	if err != nil {
+		t.Fatalf("Failed to create LSM service: %v", err)
+	}
+	defer service.Close()
+
+	txn := service.BeginTxn()
+	txn.Put([]byte("key"), []byte("value"))
+	txn.Rollback()
+
+	if err := txn.Commit(); err != ErrTxnDone {
+		t.Errorf("Expected ErrTxnDone after rollback, got %v", err)
+	}
+	if _, err := service.Get([]byte("key")); err != model.ErrKeyNotFound {
+		t.Errorf("Expected rolled back write to be discarded, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// WriteOptions controls the durability of a single write
+type WriteOptions struct {
+	// Sync forces the WAL to disk before the write returns. Without it the write is handed to the
+	// operating system and survives a crash of the process; Options.WALSyncInterval bounds how long
+	// it may be lost to a crash of the machine
+	Sync bool
+	// DisableWAL skips the WAL: the write only lives in its memtable until that is flushed
+	DisableWAL bool
+}
+
+// DefaultWriteOptions returns the options used by writes that take none: every write is synced
+func DefaultWriteOptions() WriteOptions {
+	return WriteOptions{Sync: true}
+}
+
+// SyncWAL forces writes made without WriteOptions.Sync to disk
+func (s *LSMTableService) SyncWAL() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.syncWAL()
+}
+
+// syncWAL fsyncs the current WAL if unsynced writes reached it (caller must hold s.mu)
+// Older WAL files were synced when they were closed
+func (s *LSMTableService) syncWAL() error {
+	if !s.walUnsynced || s.wal == nil {
+		return nil
+	}
+	if err := s.wal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync WAL: %w", err)
+	}
+	s.walUnsynced = false
+	return nil
+}
+
+// walSyncer periodically syncs the WAL until the service is closed
+func (s *LSMTableService) walSyncer(interval time.Duration) {
+	defer s.backgroundWG.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.backgroundStop:
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.SyncWAL(); err != nil {
+			// In production, this should be logged properly
+			fmt.Printf("%v\n", err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Bloom0716/mini-bigtable/internal/model"
+)
+
+// WriteStallOptions configures when writes are delayed, and then stopped, because flushes or compactions
+// fall behind. A zero threshold disables that trigger
+type WriteStallOptions struct {
+	SlowdownImmutableMemTables     int           // immutable memtables waiting for flush in one column family
+	StopImmutableMemTables         int           // immutable memtables at which writes stop
+	SlowdownL0Files                int           // level 0 tables of a leveled column family
+	StopL0Files                    int           // level 0 tables at which writes stop
+	SlowdownPendingCompactionBytes uint64        // bytes compaction has to rewrite in one column family
+	StopPendingCompactionBytes     uint64        // pending compaction bytes at which writes stop
+	SlowdownDelay                  time.Duration // added to every write while writes are slowed down
+}
+
+// DefaultWriteStallOptions returns the default write stall thresholds
+func DefaultWriteStallOptions() WriteStallOptions {
+	return WriteStallOptions{
+		SlowdownImmutableMemTables:     3,
+		StopImmutableMemTables:         5,
+		SlowdownL0Files:                20,
+		StopL0Files:                    36,
+		SlowdownPendingCompactionBytes: 64 * 1024 * 1024 * 1024,
+		StopPendingCompactionBytes:     256 * 1024 * 1024 * 1024,
+		SlowdownDelay:                  time.Millisecond,
+	}
+}
+
+// WriteStallCondition describes how writes are currently throttled
+type WriteStallCondition int
+
+const (
+	WriteStallNone    WriteStallCondition = iota // writes proceed normally
+	WriteStallDelayed                            // every write is delayed
+	WriteStallStopped                            // writes wait until background work catches up
+)
+
+// String returns the name of the condition
+func (c WriteStallCondition) String() string {
+	switch c {
+	case WriteStallDelayed:
+		return "delayed"
+	case WriteStallStopped:
+		return "stopped"
+	default:
+		return "normal"
+	}
+}
+
+// WriteStallStats holds the current write stall condition and totals since the service started
+type WriteStallStats struct {
+	Condition     WriteStallCondition
+	Reason        string // which threshold caused the condition; empty when writes proceed normally
+	DelayedWrites uint64
+	StoppedWrites uint64
+	DelayTime     time.Duration
+	StopTime      time.Duration
+}
+
+// GetWriteStallStats returns the current write stall condition and stall totals
+func (s *LSMTableService) GetWriteStallStats() WriteStallStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := s.writeStallStats
+	stats.Condition, stats.Reason = s.writeStallCondition()
+	return stats
+}
+
+// throttleWrites delays or stops the calling write while background work is behind
+// It is called before a write takes s.mu for itself, so conditional writes stay atomic
+// A stopped write fails with the flush error if a flush fails while it waits
+func (s *LSMTableService) throttleWrites() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	condition, _ := s.writeStallCondition()
+	if condition == WriteStallStopped {
+		s.writeStallStats.StoppedWrites++
+		stoppedAt := time.Now()
+		failures := s.flushFailures
+		for condition == WriteStallStopped {
+			select {
+			case <-s.backgroundStop:
+				return ErrServiceClosed
+			default:
+			}
+
+			// Woken whenever a flush or compaction finishes, or a flush fails
+			s.backgroundWorkDone.Wait()
+			if s.flushFailures != failures {
+				// The flush is retried only after a backoff and may keep failing
+				s.writeStallStats.StopTime += time.Since(stoppedAt)
+				return fmt.Errorf("writes stopped and failed to flush memtables: %w", s.lastFlushErr)
+			}
+			condition, _ = s.writeStallCondition()
+		}
+		s.writeStallStats.StopTime += time.Since(stoppedAt)
+	}
+
+	if condition == WriteStallDelayed {
+		delay := s.writeStall.SlowdownDelay
+		s.writeStallStats.DelayedWrites++
+		s.writeStallStats.DelayTime += delay
+
+		s.mu.Unlock()
+		time.Sleep(delay)
+		s.mu.Lock()
+	}
+	return nil
+}
+
+// writeStallCondition returns the worst condition over all column families and its reason (caller must hold s.mu)
+func (s *LSMTableService) writeStallCondition() (WriteStallCondition, string) {
+	// Memtables waiting for flush hold the whole write buffer; the running flushes will free it
+	if s.writeBufferManager.ShouldStall() && len(s.flushQueue) > 0 {
+		return WriteStallStopped, fmt.Sprintf("memtables use %d bytes (write buffer size %d)", s.writeBufferManager.MemoryUsage(), s.writeBufferManager.BufferSize())
+	}
+
+	worst, worstReason := WriteStallNone, ""
+	for _, cf := range s.families {
+		condition, reason := s.columnFamilyStallCondition(cf)
+		if condition > worst {
+			worst, worstReason = condition, reason
+		}
+	}
+	return worst, worstReason
+}
+
+// columnFamilyStallCondition checks one column family against the stall thresholds (caller must hold s.mu)
+func (s *LSMTableService) columnFamilyStallCondition(cf *columnFamily) (WriteStallCondition, string) {
+	options := s.writeStall
+
+	immutables := len(cf.immutableTables)
+	if exceeds(immutables, options.StopImmutableMemTables) {
+		return WriteStallStopped, fmt.Sprintf("column family %s has %d immutable memtables (stop at %d)", cf.name, immutables, options.StopImmutableMemTables)
+	}
+
+	// Tiered strategies keep all of their data in level 0, so only leveled families count level 0 tables
+	l0Files := 0
+	if cf.options.CompactionStrategy == model.LeveledCompaction {
+		l0Files = len(cf.sstablesByLevel[0])
+	}
+	if exceeds(l0Files, options.StopL0Files) {
+		return WriteStallStopped, fmt.Sprintf("column family %s has %d level 0 files (stop at %d)", cf.name, l0Files, options.StopL0Files)
+	}
+
+	pending := cf.pendingCompactionBytes
+	if options.StopPendingCompactionBytes > 0 && pending >= options.StopPendingCompactionBytes {
+		return WriteStallStopped, fmt.Sprintf("column family %s has %d pending compaction bytes (stop at %d)", cf.name, pending, options.StopPendingCompactionBytes)
+	}
+
+	if exceeds(immutables, options.SlowdownImmutableMemTables) {
+		return WriteStallDelayed, fmt.Sprintf("column family %s has %d immutable memtables (slowdown at %d)", cf.name, immutables, options.SlowdownImmutableMemTables)
+	}
+	if exceeds(l0Files, options.SlowdownL0Files) {
+		return WriteStallDelayed, fmt.Sprintf("column family %s has %d level 0 files (slowdown at %d)", cf.name, l0Files, options.SlowdownL0Files)
+	}
+	if options.SlowdownPendingCompactionBytes > 0 && pending >= options.SlowdownPendingCompactionBytes {
+		return WriteStallDelayed, fmt.Sprintf("column family %s has %d pending compaction bytes (slowdown at %d)", cf.name, pending, options.SlowdownPendingCompactionBytes)
+	}
+
+	return WriteStallNone, ""
+}
+
+// exceeds reports whether a count reached a threshold; a zero threshold never triggers
+func exceeds(count, threshold int) bool {
+	return threshold > 0 && count >= threshold
+}